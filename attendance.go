@@ -0,0 +1,182 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// attendanceMarks are the marks an attendee can be given. The stored
+// values match the server's.
+var attendanceMarks = []struct{ value, label string }{
+	{"present", "Present"},
+	{"late", "Late"},
+	{"absent", "Absent"},
+	{"excused", "Excused"},
+}
+
+func attendanceLabel(value string) string {
+	for _, m := range attendanceMarks {
+		if m.value == value {
+			return m.label
+		}
+	}
+	return ""
+}
+
+func attendanceValue(label string) string {
+	for _, m := range attendanceMarks {
+		if m.label == label {
+			return m.value
+		}
+	}
+	return ""
+}
+
+// bookingAttendees returns the people a booking's user field names. A
+// group booking lists them separated by commas.
+func bookingAttendees(b Booking) []string {
+	var names []string
+	for _, name := range strings.Split(b.User, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// loadAttendance returns the attendees marked so far for a booking, in
+// the order they were added, and their marks
+func (bs *BookingSystem) loadAttendance(bookingID int64) ([]string, map[string]string, error) {
+	rows, err := bs.db.Query("SELECT attendee, status FROM attendance WHERE booking_id = ? ORDER BY id", bookingID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	marks := make(map[string]string)
+	for rows.Next() {
+		var name, status string
+		if err := rows.Scan(&name, &status); err != nil {
+			return nil, nil, err
+		}
+		names = append(names, name)
+		marks[name] = status
+	}
+	return names, marks, rows.Err()
+}
+
+// saveAttendance stores the marks that changed and audits them in one
+// transaction
+func (bs *BookingSystem) saveAttendance(bookingID int64, before, after map[string]string) error {
+	tx, err := bs.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	changes := make(map[string]fieldChange)
+	now := time.Now()
+	for name, status := range after {
+		old := before[name]
+		if old == status {
+			continue
+		}
+		var markedAt sql.NullTime
+		if status != "" {
+			markedAt = sql.NullTime{Time: now, Valid: true}
+		}
+		_, err := tx.Exec(`
+			INSERT INTO attendance (booking_id, attendee, status, marked_at)
+			VALUES (?, ?, ?, ?)
+			ON CONFLICT (booking_id, attendee) DO UPDATE SET status = excluded.status, marked_at = excluded.marked_at
+		`, bookingID, name, status, markedAt)
+		if err != nil {
+			return err
+		}
+		changes[name] = fieldChange{Before: old, After: status}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	if err := recordAudit(tx, "booking.attendance", "booking", bookingID, changes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// showAttendanceDialog lets the user mark each attendee of a booking that
+// has started, and add anyone who came without being booked
+func (bs *BookingSystem) showAttendanceDialog(booking Booking) {
+	if time.Now().Before(booking.StartTime) {
+		dialog.ShowError(errors.New("attendance can't be marked before the booking starts"), bs.window)
+		return
+	}
+
+	names, before, err := bs.loadAttendance(booking.ID)
+	if err != nil {
+		dialog.ShowError(err, bs.window)
+		return
+	}
+	for _, name := range bookingAttendees(booking) {
+		if _, ok := before[name]; !ok {
+			names = append(names, name)
+		}
+	}
+
+	labels := make([]string, len(attendanceMarks))
+	for i, m := range attendanceMarks {
+		labels[i] = m.label
+	}
+	selects := make(map[string]*widget.Select)
+	form := widget.NewForm()
+	addRow := func(name string) {
+		if _, ok := selects[name]; ok {
+			return
+		}
+		sel := widget.NewSelect(labels, nil)
+		sel.PlaceHolder = "Not marked"
+		if label := attendanceLabel(before[name]); label != "" {
+			sel.SetSelected(label)
+		}
+		selects[name] = sel
+		form.Append(name, sel)
+	}
+	for _, name := range names {
+		addRow(name)
+	}
+
+	guest := widget.NewEntry()
+	guest.SetPlaceHolder("Add attendee")
+	add := widget.NewButton("Add", func() {
+		if name := strings.TrimSpace(guest.Text); name != "" {
+			addRow(name)
+			guest.SetText("")
+		}
+	})
+
+	title := booking.Space + " " + booking.StartTime.Format("2006-01-02 15:04")
+	content := container.NewBorder(nil, container.NewBorder(nil, nil, nil, add, guest), nil, nil,
+		container.NewVScroll(form))
+	d := dialog.NewCustomConfirm("Attendance: "+title, "Save", "Cancel", content, func(save bool) {
+		if !save {
+			return
+		}
+		after := make(map[string]string, len(selects))
+		for name, sel := range selects {
+			after[name] = attendanceValue(sel.Selected)
+		}
+		if err := bs.saveAttendance(booking.ID, before, after); err != nil {
+			dialog.ShowError(err, bs.window)
+		}
+	}, bs.window)
+	d.Resize(fyne.NewSize(420, 360))
+	d.Show()
+}
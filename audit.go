@@ -0,0 +1,65 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os/user"
+	"time"
+)
+
+// fieldChange is the value of one field before and after an edit
+type fieldChange struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+// auditActor names whoever is using the app. The desktop app has no
+// accounts of its own, so this is the operating system user.
+func auditActor() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return "unknown"
+}
+
+// recordAudit appends an entry to the audit log. Pass the transaction the
+// change ran in, so the entry is only kept if the change is.
+func recordAudit(tx *sql.Tx, action, entityType string, entityID int64, changes map[string]fieldChange) error {
+	raw, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO audit_log (created_at, actor, action, entity_type, entity_id, changes)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, time.Now(), auditActor(), action, entityType, entityID, string(raw))
+	return err
+}
+
+// updateBookingField sets one text column of a booking and audits the
+// change in a single transaction. column must be a literal from the
+// caller, never user input.
+func (bs *BookingSystem) updateBookingField(id int64, action, column, value string) error {
+	tx, err := bs.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var before sql.NullString
+	if err := tx.QueryRow(fmt.Sprintf("SELECT %s FROM bookings WHERE id = ?", column), id).Scan(&before); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(fmt.Sprintf("UPDATE bookings SET %s = ? WHERE id = ?", column), value, id); err != nil {
+		return err
+	}
+	change := fieldChange{After: value}
+	if before.Valid {
+		change.Before = before.String
+	}
+	if err := recordAudit(tx, action, "booking", id, map[string]fieldChange{column: change}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+// checkInOpens is how long before a booking starts it can be checked in
+// to, as on the server
+const checkInOpens = 15 * time.Minute
+
+// spaceCheckInMinutes returns how long after a booking starts the named
+// space expects a check-in, 0 if it doesn't
+func (bs *BookingSystem) spaceCheckInMinutes(name string) int {
+	var minutes int
+	err := bs.db.QueryRow("SELECT check_in_minutes FROM spaces WHERE name = ?", name).Scan(&minutes)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Error loading check-in window for %s: %v", name, err)
+	}
+	return minutes
+}
+
+// checkIn claims a booking that is open for check-in
+func (bs *BookingSystem) checkIn(booking Booking) error {
+	if booking.Status != "Confirmed" {
+		return errors.New("only confirmed bookings can be checked in to")
+	}
+	now := time.Now()
+	window := bs.spaceCheckInMinutes(booking.Space)
+	if now.Before(booking.StartTime.Add(-checkInOpens)) || !now.Before(booking.EndTime) ||
+		(window > 0 && now.After(booking.StartTime.Add(time.Duration(window)*time.Minute))) {
+		return errors.New("check-in is only open from shortly before the booking starts until its check-in window ends")
+	}
+
+	tx, err := bs.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Checking in twice is harmless and keeps the first time
+	res, err := tx.Exec("UPDATE bookings SET checked_in_at = ? WHERE id = ? AND checked_in_at IS NULL", now, booking.ID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+	change := map[string]fieldChange{"checked_in_at": {After: now}}
+	if err := recordAudit(tx, "booking.check_in", "booking", booking.ID, change); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// releaseUnclaimed marks confirmed bookings that nobody checked in to
+// before their space's window closed as no-shows, freeing the space. It
+// returns how many were released.
+func (bs *BookingSystem) releaseUnclaimed() (int, error) {
+	rows, err := bs.db.Query(`
+		SELECT id, space_id, start_time
+		FROM bookings
+		WHERE status = 'Confirmed' AND checked_in_at IS NULL
+		AND end_time >= datetime('now', '-1 day')
+	`)
+	if err != nil {
+		return 0, err
+	}
+
+	type candidate struct {
+		id    int64
+		space int
+		start time.Time
+	}
+	var candidates []candidate
+	for rows.Next() {
+		var c candidate
+		if err := rows.Scan(&c.id, &c.space, &c.start); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		candidates = append(candidates, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	windows := make(map[int]int)
+	released := 0
+	now := time.Now()
+	for _, c := range candidates {
+		if c.space < 0 || c.space >= len(bs.spaces) {
+			continue
+		}
+		window, ok := windows[c.space]
+		if !ok {
+			window = bs.spaceCheckInMinutes(bs.spaces[c.space])
+			windows[c.space] = window
+		}
+		if window == 0 || !now.After(c.start.Add(time.Duration(window)*time.Minute)) {
+			continue
+		}
+		if err := bs.updateBookingField(c.id, "booking.no_show", "status", "NoShow"); err != nil {
+			return released, err
+		}
+		released++
+	}
+	return released, nil
+}
@@ -0,0 +1,223 @@
+// cmd/server/admin.go
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"skedda-goclone/internal/config"
+	"skedda-goclone/internal/database"
+	"skedda-goclone/internal/models"
+
+	"github.com/BurntSushi/toml"
+	"gorm.io/gorm"
+)
+
+// runMigrate applies schema migrations without starting the server
+func runMigrate(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	cfg, err := loadConfig(flags, args)
+	if err != nil {
+		return err
+	}
+
+	db, err := openDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	if err := db.Migrate(); err != nil {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+	fmt.Println("Migrations applied")
+	return nil
+}
+
+// runSeed loads a fixtures file. Fixtures use the export format, except
+// that teachers may be given a plaintext "password".
+func runSeed(args []string) error {
+	flags := flag.NewFlagSet("seed", flag.ExitOnError)
+	fixtures := flags.String("fixtures", "", "path to a JSON fixtures file")
+	cfg, err := loadConfig(flags, args)
+	if err != nil {
+		return err
+	}
+
+	if *fixtures == "" {
+		return errors.New("--fixtures is required")
+	}
+	return importFile(cfg, *fixtures)
+}
+
+// runImport loads a file written by export, or stdin when --in is "-"
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	in := flags.String("in", "-", "file to read, or - for stdin")
+	cfg, err := loadConfig(flags, args)
+	if err != nil {
+		return err
+	}
+
+	return importFile(cfg, *in)
+}
+
+func importFile(cfg *config.Config, path string) error {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var dump database.Dump
+	if err := json.NewDecoder(r).Decode(&dump); err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	db, err := openDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	if err := db.Migrate(); err != nil {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+	if err := db.Import(&dump); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Imported %d teachers, %d students, %d subjects, %d bookings\n",
+		len(dump.Teachers), len(dump.Students), len(dump.Subjects), len(dump.Bookings))
+	return nil
+}
+
+// runExport writes every record as JSON to --out, or stdout by default
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	out := flags.String("out", "-", "file to write, or - for stdout")
+	cfg, err := loadConfig(flags, args)
+	if err != nil {
+		return err
+	}
+
+	db, err := openDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	dump, err := db.Export()
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		// Exports contain password hashes and secrets, so keep them private to
+		// the operator
+		f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(dump)
+}
+
+// runCreateAdmin creates an admin account, or promotes and resets the
+// password of an existing teacher with the same email. The password is
+// read from stdin unless --password is given, keeping it out of shell history.
+func runCreateAdmin(args []string) error {
+	flags := flag.NewFlagSet("create-admin", flag.ExitOnError)
+	email := flags.String("email", "", "admin email address")
+	name := flags.String("name", "", "admin display name")
+	password := flags.String("password", "", "admin password (prompted for when omitted)")
+	cfg, err := loadConfig(flags, args)
+	if err != nil {
+		return err
+	}
+
+	if *email == "" {
+		return errors.New("--email is required")
+	}
+	if *password == "" {
+		fmt.Fprint(os.Stderr, "Password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		*password = strings.TrimRight(line, "\r\n")
+	}
+	if *password == "" {
+		return errors.New("password must not be empty")
+	}
+
+	db, err := openDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	if err := db.Migrate(); err != nil {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+
+	var teacher models.Teacher
+	err = db.Where("email = ?", *email).First(&teacher).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	teacher.Email = *email
+	teacher.Role = models.RoleAdmin
+	if *name != "" {
+		teacher.Name = *name
+	}
+	if err := teacher.SetPassword(*password); err != nil {
+		return err
+	}
+	if err := db.Save(&teacher).Error; err != nil {
+		return err
+	}
+
+	fmt.Printf("Admin %s (id %d) is ready\n", teacher.Email, teacher.ID)
+	return nil
+}
+
+// runConfig implements "config print", which shows the effective
+// configuration as TOML with secrets redacted
+func runConfig(args []string) error {
+	if len(args) == 0 || args[0] != "print" {
+		return errors.New(`usage: config print [flags]`)
+	}
+
+	flags := flag.NewFlagSet("config print", flag.ExitOnError)
+	path := flags.String("config", os.Getenv("SKEDDA_CONFIG"), "path to a TOML config file")
+	overrides := config.RegisterFlags(flags)
+	flags.Parse(args[1:])
+
+	cfg, err := config.Load(*path)
+	if err != nil {
+		return err
+	}
+	if err := overrides.Apply(cfg); err != nil {
+		return err
+	}
+
+	if err := toml.NewEncoder(os.Stdout).Encode(cfg.Redacted()); err != nil {
+		return err
+	}
+
+	// Still print an invalid configuration, since that is when it's most useful
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+// cmd/server/jobs.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"skedda-goclone/internal/approvals"
+	"skedda-goclone/internal/checkin"
+	"skedda-goclone/internal/config"
+	"skedda-goclone/internal/jobs"
+	"skedda-goclone/internal/mail"
+	"skedda-goclone/internal/models"
+	"skedda-goclone/internal/notify"
+	"skedda-goclone/internal/reminders"
+	"skedda-goclone/internal/retention"
+
+	"gorm.io/gorm"
+)
+
+// newJobRunner returns a runner with the server's background jobs
+// registered: releasing bookings nobody checked in to, expiring bookings
+// nobody approved, booking reminders, email notifications and digests, and
+// purging old records
+func newJobRunner(db *gorm.DB, cfg *config.Config) (*jobs.Runner, error) {
+	notifier := &notify.Notifier{
+		DB:        db,
+		Sender:    mailSender(cfg.Mail),
+		Templates: &notify.Templates{Dir: cfg.Mail.TemplateDir, DefaultLocale: cfg.Mail.DefaultLocale},
+		From:      cfg.Mail.From,
+		Location:  cfg.Booking.Location(),
+		Host:      notify.UIDHost(cfg.Server.PublicURL),
+	}
+	if notifier.Sender != nil {
+		if err := notifier.Templates.Check(); err != nil {
+			return nil, fmt.Errorf("email templates: %w", err)
+		}
+	}
+
+	runner := &jobs.Runner{DB: db, Config: cfg.Jobs}
+	runner.Every("checkin.release", cfg.Booking.ReleaseInterval, func(ctx context.Context, _ *models.Job) error {
+		return checkin.ReleaseOverdue(ctx, db)
+	})
+	if cfg.Booking.ReminderLead > 0 {
+		runner.Every(reminders.KindScan, reminders.ScanInterval, func(ctx context.Context, _ *models.Job) error {
+			return reminders.Scan(ctx, db, cfg.Booking.ReminderLead, time.Now())
+		})
+	}
+	runner.Every(approvals.Kind, approvals.Interval, func(ctx context.Context, _ *models.Job) error {
+		return approvals.ExpireOverdue(ctx, db)
+	})
+	runner.Handle(reminders.KindSend, func(ctx context.Context, job *models.Job) error {
+		return reminders.Send(ctx, db, job)
+	})
+	runner.Handle(notify.KindBooking, notifier.Prepare)
+	runner.Handle(notify.KindSend, notifier.Send)
+	runner.Every(notify.KindDigest, notify.DigestInterval, notifier.Digest)
+	runner.Every(retention.Kind, retention.Interval, func(ctx context.Context, _ *models.Job) error {
+		return retention.Purge(ctx, db, cfg.Retention, time.Now())
+	})
+	return runner, nil
+}
+
+// mailSender returns where email goes, or nil if it is turned off
+func mailSender(cfg config.MailConfig) mail.Sender {
+	switch {
+	case cfg.Maildir != "":
+		return &mail.MaildirSender{Dir: cfg.Maildir}
+	case cfg.Host != "":
+		return &mail.SMTPSender{Host: cfg.Host, Port: cfg.Port, Username: cfg.Username, Password: cfg.Password}
+	}
+	return nil
+}
@@ -1,49 +1,97 @@
-// cmd/server/main.go
-package main
-
-import (
-	"fmt"
-	"log"
-	"net/http"
-	"os"
-
-	"skedda-goclone/internal/database"
-	"skedda-goclone/internal/handlers"
-
-	"github.com/gorilla/mux"
-)
-
-func main() {
-	// Initialize the database
-	db, err := database.NewDatabase()
-	if err != nil {
-		log.Fatalf("Could not connect to the database: %v", err)
-	}
-
-	// Run migrations for all models
-	db.Migrate()
-
-	// Initialize router
-	router := mux.NewRouter()
-
-	// Register handlers
-	teacherHandler := handlers.TeacherHandler{DB: db.DB}
-	studentHandler := handlers.StudentHandler{DB: db.DB}
-	subjectHandler := handlers.SubjectHandler{DB: db.DB}
-
-	// Define API endpoints
-	router.HandleFunc("/api/teachers/register", teacherHandler.RegisterTeacher).Methods("POST")
-	router.HandleFunc("/api/students", studentHandler.AddStudent).Methods("POST")
-	router.HandleFunc("/api/students", studentHandler.ListStudents).Methods("GET")
-	router.HandleFunc("/api/subjects", subjectHandler.CreateSubject).Methods("POST")
-	router.HandleFunc("/api/subjects/assign", subjectHandler.AssignSubjectToStudent).Methods("POST")
-
-	// Start the server
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080" // Default to port 8080 if PORT is not set
-	}
-
-	fmt.Printf("Starting server on port %s...\n", port)
-	log.Fatal(http.ListenAndServe(":"+port, router))
-}
+// cmd/server/main.go
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+
+	"skedda-goclone/internal/config"
+	"skedda-goclone/internal/database"
+	"skedda-goclone/internal/logging"
+)
+
+// command is a management subcommand of the server binary
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands = map[string]command{
+	"serve":        {"serve                         start the HTTP API (default)", runServe},
+	"migrate":      {"migrate                       apply database schema migrations", runMigrate},
+	"seed":         {"seed --fixtures FILE          load fixture records from a JSON file", runSeed},
+	"create-admin": {"create-admin --email EMAIL    create an admin or promote an existing teacher", runCreateAdmin},
+	"export":       {"export [--out FILE]           write every record as JSON", runExport},
+	"import":       {"import [--in FILE]            load records written by export", runImport},
+	"config":       {"config print                  show the effective config with secrets redacted", runConfig},
+	"mock-idp":     {"mock-idp [--addr :9999]       run a local OpenID provider for trying out single sign-on", runMockIDP},
+}
+
+func main() {
+	// With no subcommand the binary behaves as it always has and serves HTTP
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		usage()
+		os.Exit(2)
+	}
+
+	if err := cmd.run(args); err != nil {
+		log.Fatalf("%s: %v", name, err)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", os.Args[0])
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s\n", commands[name].usage)
+	}
+}
+
+// loadConfig parses args into flags, which gains --config and the config
+// overrides, and returns the validated effective configuration
+func loadConfig(flags *flag.FlagSet, args []string) (*config.Config, error) {
+	path := flags.String("config", os.Getenv("SKEDDA_CONFIG"), "path to a TOML config file")
+	overrides := config.RegisterFlags(flags)
+	flags.Parse(args)
+
+	cfg, err := config.Load(*path)
+	if err != nil {
+		return nil, err
+	}
+	if err := overrides.Apply(cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+
+	// Log structured JSON from here on, including via the log package
+	level, _ := cfg.Log.SlogLevel()
+	slog.SetDefault(logging.New(os.Stderr, level))
+	return cfg, nil
+}
+
+// openDatabase connects to the database every command shares
+func openDatabase(cfg *config.Config) (*database.Database, error) {
+	db, err := database.NewDatabase(cfg.Database)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to the database: %w", err)
+	}
+	return db, nil
+}
@@ -0,0 +1,51 @@
+// cmd/server/mockidp.go
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"skedda-goclone/internal/mockidp"
+)
+
+// runMockIDP serves the mock OpenID provider, so single sign-on can be
+// tried without a real identity provider. Never point production at it.
+func runMockIDP(args []string) error {
+	flags := flag.NewFlagSet("mock-idp", flag.ExitOnError)
+	addr := flags.String("addr", "localhost:9999", "address to listen on")
+	issuer := flags.String("issuer", "", "issuer URL (default http://ADDR)")
+	clientID := flags.String("client-id", "skedda", "client ID the server is configured with")
+	clientSecret := flags.String("client-secret", "skedda-secret", "client secret the server is configured with")
+	users := flags.String("users", "admin@example.com,teacher@example.com", "comma separated emails that can sign in")
+	flags.Parse(args)
+
+	if *issuer == "" {
+		*issuer = "http://" + *addr
+	}
+
+	var accounts []mockidp.User
+	for i, email := range strings.Split(*users, ",") {
+		email = strings.TrimSpace(email)
+		name, _, _ := strings.Cut(email, "@")
+		accounts = append(accounts, mockidp.User{
+			Subject:       fmt.Sprintf("mock-%d", i+1),
+			Email:         email,
+			Name:          name,
+			EmailVerified: true,
+		})
+	}
+
+	idp, err := mockidp.New(*issuer, *clientID, *clientSecret, accounts...)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Mock OpenID provider for local use only. Configure the server with:\n\n"+
+		"[oidc]\nissuer_url = %q\nclient_id = %q\nclient_secret = %q\nredirect_url = \"http://localhost:8080/api/auth/oidc/callback\"\n\n",
+		*issuer, *clientID, *clientSecret)
+	slog.Info("starting mock identity provider", "addr", *addr, "issuer", *issuer)
+	return http.ListenAndServe(*addr, idp)
+}
@@ -0,0 +1,302 @@
+// cmd/server/serve.go
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"skedda-goclone/internal/auth"
+	"skedda-goclone/internal/config"
+	"skedda-goclone/internal/database"
+	"skedda-goclone/internal/events"
+	"skedda-goclone/internal/handlers"
+	"skedda-goclone/internal/health"
+	"skedda-goclone/internal/metrics"
+	"skedda-goclone/internal/middleware"
+	"skedda-goclone/internal/webhook"
+
+	"github.com/gorilla/mux"
+)
+
+// runServe migrates the database and starts the HTTP API
+func runServe(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	cfg, err := loadConfig(flags, args)
+	if err != nil {
+		return err
+	}
+	if cfg.Auth.TokenSecret == "" {
+		return fmt.Errorf("auth.token_secret (SKEDDA_TOKEN_SECRET) must be set to serve the API")
+	}
+	// Calendar event UIDs are built from the public URL, and must not change
+	// with the address a client happened to use
+	if cfg.Server.PublicURL == "" {
+		return fmt.Errorf("server.public_url (SKEDDA_PUBLIC_URL) must be set to serve the API")
+	}
+
+	// Initialize the database
+	db, err := openDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			slog.Error("closing database", "error", err)
+		}
+	}()
+
+	// Run migrations for all models
+	if err := db.Migrate(); err != nil {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+
+	// Expose connection pool statistics
+	sqlDB, err := db.DB.DB()
+	if err != nil {
+		return err
+	}
+	if err := metrics.RegisterDB(sqlDB, "postgres"); err != nil {
+		return err
+	}
+
+	// Readiness depends on a reachable database migrated at least as far as
+	// this build needs. A newer schema is fine: during a rolling deploy the
+	// new servers migrate while the old ones are still serving.
+	checker := health.NewChecker()
+	checker.Add("database", db.Ping)
+	checker.Add("migrations", func(ctx context.Context) error {
+		version, err := db.AppliedSchemaVersion(ctx)
+		if err != nil {
+			return err
+		}
+		return schemaReady(version)
+	})
+
+	// Background jobs, including email notifications
+	runner, err := newJobRunner(db.DB, cfg)
+	if err != nil {
+		return err
+	}
+
+	// Initialize router
+	router := mux.NewRouter()
+	router.Use(metrics.Instrument)
+
+	// Register handlers
+	teacherHandler := handlers.TeacherHandler{
+		DB:             db.DB,
+		Auth:           cfg.Auth,
+		IPLimiter:      auth.NewLimiter(cfg.Auth.LoginIPLimit, cfg.Auth.LoginWindow),
+		AccountLimiter: auth.NewLimiter(cfg.Auth.LoginAccountLimit, cfg.Auth.LoginWindow),
+		SSO:            cfg.OIDC,
+	}
+	if cfg.OIDC.Enabled() {
+		teacherHandler.OIDC = &auth.OIDCProvider{
+			Issuer:       cfg.OIDC.IssuerURL,
+			ClientID:     cfg.OIDC.ClientID,
+			ClientSecret: cfg.OIDC.ClientSecret,
+			RedirectURL:  cfg.OIDC.RedirectURL,
+			Scopes:       cfg.OIDC.Scopes,
+		}
+	}
+	studentHandler := handlers.StudentHandler{DB: db.DB}
+	subjectHandler := handlers.SubjectHandler{DB: db.DB}
+	bookingHandler := handlers.BookingHandler{DB: db.DB, Rules: cfg.Booking}
+	apiKeyHandler := handlers.APIKeyHandler{DB: db.DB, MaxTTL: cfg.Auth.APIKeyMaxTTL}
+	auditHandler := handlers.AuditHandler{DB: db.DB}
+	webhookHandler := handlers.WebhookHandler{DB: db.DB}
+	jobHandler := handlers.JobHandler{DB: db.DB}
+	spaceHandler := handlers.SpaceHandler{DB: db.DB, PublicURL: cfg.Server.PublicURL}
+	checkInHandler := handlers.CheckInHandler{DB: db.DB, Rules: cfg.Booking}
+	attendanceHandler := handlers.AttendanceHandler{DB: db.DB}
+	availabilityHandler := handlers.AvailabilityHandler{DB: db.DB}
+	notificationHandler := handlers.NotificationHandler{DB: db.DB}
+	hub := &events.Hub{DB: db.DB, DSN: cfg.Database.URL}
+	eventsHandler := handlers.EventsHandler{DB: db.DB, Hub: hub}
+	calendarHandler := handlers.CalendarHandler{DB: db.DB, Rules: cfg.Booking, PublicURL: cfg.Server.PublicURL}
+
+	// Define public API endpoints. These come before the authenticated
+	// subrouter, which would otherwise claim every /api path.
+	router.HandleFunc("/api/teachers/register", teacherHandler.RegisterTeacher).Methods("POST")
+	router.HandleFunc("/api/teachers/login", teacherHandler.LoginTeacher).Methods("POST")
+	router.HandleFunc("/api/teachers/login/totp", teacherHandler.VerifyTOTPLogin).Methods("POST")
+	router.HandleFunc("/api/auth/oidc/login", teacherHandler.OIDCLogin).Methods("GET")
+	router.HandleFunc("/api/auth/oidc/callback", teacherHandler.OIDCCallback).Methods("GET")
+	router.HandleFunc("/calendar/{token:[A-Za-z0-9_-]+}.ics", calendarHandler.ServeFeed).Methods("GET")
+	router.HandleFunc("/check-in/{token:[A-Za-z0-9_-]+}", checkInHandler.ShowCheckIn).Methods("GET")
+	router.HandleFunc("/check-in/{token:[A-Za-z0-9_-]+}", checkInHandler.ClaimCheckIn).Methods("POST")
+
+	// Two-factor enrollment is open to tokens issued only for enrolling
+	authenticator := &auth.Authenticator{Secret: []byte(cfg.Auth.TokenSecret), DB: db.DB}
+	enrollment := router.PathPrefix("/api/teachers/me/totp").Subrouter()
+	enrollment.Use(authenticator.RequireEnrollment)
+	enrollment.HandleFunc("/enroll", teacherHandler.EnrollTOTP).Methods("POST")
+	enrollment.HandleFunc("/confirm", teacherHandler.ConfirmTOTP).Methods("POST")
+
+	// Everything else under /api needs a full login token, sent as
+	// "Authorization: Bearer <token>" or an API key. Before logins issued
+	// tokens these endpoints were open, so clients written then must now
+	// log in first.
+	api := router.PathPrefix("/api").Subrouter()
+	api.Use(authenticator.Require)
+	api.HandleFunc("/students", studentHandler.AddStudent).Methods("POST")
+	api.HandleFunc("/students", studentHandler.ListStudents).Methods("GET")
+	api.HandleFunc("/students/{id:[0-9]+}/availability", availabilityHandler.GetStudentAvailability).Methods("GET")
+	api.HandleFunc("/students/{id:[0-9]+}/availability", availabilityHandler.SetStudentAvailability).Methods("PUT")
+	api.HandleFunc("/students/{id:[0-9]+}/notifications", notificationHandler.GetStudentPreferences).Methods("GET")
+	api.HandleFunc("/students/{id:[0-9]+}/notifications", notificationHandler.SetStudentPreferences).Methods("PUT")
+	api.HandleFunc("/students/{id:[0-9]+}/lessons", bookingHandler.StudentLessons).Methods("GET")
+	api.HandleFunc("/students/{id:[0-9]+}/attendance", attendanceHandler.StudentAttendance).Methods("GET")
+	api.HandleFunc("/subjects", subjectHandler.CreateSubject).Methods("POST")
+	api.HandleFunc("/subjects/assign", subjectHandler.AssignSubjectToStudent).Methods("POST")
+	api.HandleFunc("/spaces", spaceHandler.ListSpaces).Methods("GET")
+	api.Handle("/spaces", adminOnly(spaceHandler.CreateSpace)).Methods("POST")
+	api.Handle("/spaces/{id:[0-9]+}", adminOnly(spaceHandler.UpdateSpace)).Methods("PUT")
+	api.Handle("/spaces/{id:[0-9]+}/check-in-code", adminOnly(spaceHandler.RotateCheckInCode)).Methods("POST")
+	api.HandleFunc("/bookings/availability", bookingHandler.FindSlots).Methods("GET")
+	api.HandleFunc("/bookings/schedule", bookingHandler.FindLessonSlots).Methods("GET")
+	api.HandleFunc("/bookings/schedule", bookingHandler.ScheduleLesson).Methods("POST")
+	api.HandleFunc("/bookings", bookingHandler.ListBookings).Methods("GET")
+	api.HandleFunc("/bookings", bookingHandler.CreateBooking).Methods("POST")
+	api.HandleFunc("/bookings/{id:[0-9]+}", bookingHandler.UpdateBooking).Methods("PUT")
+	api.HandleFunc("/bookings/{id:[0-9]+}/cancel", bookingHandler.CancelBooking).Methods("POST")
+	api.Handle("/bookings/{id:[0-9]+}/approve", adminOnly(bookingHandler.ApproveBooking)).Methods("POST")
+	api.HandleFunc("/bookings/{id:[0-9]+}/attendees", bookingHandler.AddAttendee).Methods("POST")
+	api.HandleFunc("/bookings/{id:[0-9]+}/attendees/{attendee_id:[0-9]+}", bookingHandler.RemoveAttendee).Methods("DELETE")
+	api.HandleFunc("/bookings/{id:[0-9]+}/attendees/{attendee_id:[0-9]+}/rsvp", bookingHandler.SetRSVP).Methods("PUT")
+	api.HandleFunc("/bookings/{id:[0-9]+}/attendance", bookingHandler.MarkAttendance).Methods("PUT")
+	api.HandleFunc("/bookings/{id:[0-9]+}/check-in", bookingHandler.CheckIn).Methods("POST")
+	api.HandleFunc("/reports/no-shows", attendanceHandler.NoShowReport).Methods("GET")
+	api.HandleFunc("/events", eventsHandler.StreamEvents).Methods("GET")
+	api.HandleFunc("/calendar-feeds", calendarHandler.ListFeeds).Methods("GET")
+	api.HandleFunc("/calendar-feeds", calendarHandler.CreateFeed).Methods("POST")
+	api.HandleFunc("/calendar-feeds/{id:[0-9]+}/rotate", calendarHandler.RotateFeed).Methods("POST")
+	api.HandleFunc("/calendar-feeds/{id:[0-9]+}", calendarHandler.DeleteFeed).Methods("DELETE")
+	api.HandleFunc("/teachers/me/totp/disable", teacherHandler.DisableTOTP).Methods("POST")
+	api.HandleFunc("/teachers/me/locale", teacherHandler.SetLocale).Methods("PUT")
+	api.HandleFunc("/teachers/{id:[0-9]+}/availability", availabilityHandler.GetTeacherAvailability).Methods("GET")
+	api.HandleFunc("/teachers/{id:[0-9]+}/availability", availabilityHandler.SetTeacherAvailability).Methods("PUT")
+	api.HandleFunc("/teachers/{id:[0-9]+}/notifications", notificationHandler.GetTeacherPreferences).Methods("GET")
+	api.HandleFunc("/teachers/{id:[0-9]+}/notifications", notificationHandler.SetTeacherPreferences).Methods("PUT")
+	api.HandleFunc("/teachers/{id:[0-9]+}/schedule", bookingHandler.TeacherSchedule).Methods("GET")
+	api.HandleFunc("/api-keys", apiKeyHandler.ListAPIKeys).Methods("GET")
+	api.HandleFunc("/api-keys", apiKeyHandler.CreateAPIKey).Methods("POST")
+	api.HandleFunc("/api-keys/{id:[0-9]+}", apiKeyHandler.RevokeAPIKey).Methods("DELETE")
+	api.Handle("/teachers/{id:[0-9]+}/unlock", adminOnly(teacherHandler.UnlockTeacher)).Methods("POST")
+	api.Handle("/teachers/{id:[0-9]+}/totp/reset", adminOnly(teacherHandler.ResetTOTP)).Methods("POST")
+	api.Handle("/audit", adminOnly(auditHandler.ListAuditEntries)).Methods("GET")
+	api.Handle("/webhooks", adminOnly(webhookHandler.ListWebhooks)).Methods("GET")
+	api.Handle("/webhooks", adminOnly(webhookHandler.CreateWebhook)).Methods("POST")
+	api.Handle("/webhooks/{id:[0-9]+}", adminOnly(webhookHandler.DeleteWebhook)).Methods("DELETE")
+	api.Handle("/webhooks/{id:[0-9]+}/deliveries", adminOnly(webhookHandler.ListDeliveries)).Methods("GET")
+	api.Handle("/webhooks/deliveries/{id:[0-9]+}/retry", adminOnly(webhookHandler.RetryDelivery)).Methods("POST")
+	api.Handle("/jobs", adminOnly(jobHandler.ListJobs)).Methods("GET")
+	api.Handle("/jobs/{id:[0-9]+}/retry", adminOnly(jobHandler.RetryJob)).Methods("POST")
+
+	// Operational endpoints
+	router.Handle("/metrics", metrics.Handler()).Methods("GET")
+	router.HandleFunc("/healthz", checker.Liveness).Methods("GET")
+	router.HandleFunc("/readyz", checker.Readiness).Methods("GET")
+
+	// Allow configured browser origins, log every request, and see past
+	// trusted proxies to the client's address
+	trusted, err := cfg.Server.TrustedProxyPrefixes()
+	if err != nil {
+		return err
+	}
+	handler := middleware.CORS(cfg.CORS.AllowedOrigins)(router)
+	handler = middleware.RequestLogger(slog.Default())(handler)
+	handler = middleware.RealIP(trusted)(handler)
+
+	// Serve until SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Deliver webhooks in the background until shutdown
+	dispatcher := &webhook.Dispatcher{DB: db.DB, Config: cfg.Webhooks}
+	go dispatcher.Run(ctx)
+
+	// Run scheduled and queued background jobs
+	go runner.Run(ctx)
+
+	// Fan out live events published by any server
+	go hub.Run(ctx)
+
+	return listenAndServe(ctx, cfg.Server, handler, checker.Drain)
+}
+
+// adminOnly restricts an authenticated handler to admins
+func adminOnly(h http.HandlerFunc) http.Handler {
+	return auth.RequireAdmin(h)
+}
+
+// listenAndServe runs handler on a hardened http.Server until ctx is
+// cancelled. It then calls drain, keeps serving for the configured drain
+// delay, stops accepting connections and waits up to the shutdown timeout
+// for in-flight requests to finish.
+func listenAndServe(ctx context.Context, cfg config.ServerConfig, handler http.Handler, drain func()) error {
+	srv := &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           handler,
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+		TLSConfig:         &tls.Config{MinVersion: tls.VersionTLS12},
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		slog.Info("starting server", "addr", cfg.Addr, "tls", cfg.TLSCertFile != "")
+		if cfg.TLSCertFile != "" {
+			errc <- srv.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+		} else {
+			errc <- srv.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-errc:
+		// The server failed to start or stopped on its own
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("draining before shutdown", "delay", cfg.DrainDelay.String())
+	drain()
+	select {
+	case err := <-errc:
+		return err
+	case <-time.After(cfg.DrainDelay):
+	}
+
+	slog.Info("shutting down, draining requests", "timeout", cfg.ShutdownTimeout.String())
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("graceful shutdown: %w", err)
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	slog.Info("server stopped")
+	return nil
+}
+
+// schemaReady reports whether a database at the applied schema version can
+// serve this build: it must have every migration the build expects
+func schemaReady(applied int) error {
+	if applied < database.SchemaVersion {
+		return fmt.Errorf("schema version is %d, expected at least %d", applied, database.SchemaVersion)
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+// cmd/server/serve_test.go
+package main
+
+import (
+	"testing"
+
+	"skedda-goclone/internal/database"
+)
+
+func TestSchemaReady(t *testing.T) {
+	tests := []struct {
+		applied int
+		ready   bool
+	}{
+		{0, false},
+		{database.SchemaVersion - 1, false},
+		{database.SchemaVersion, true},
+		// A newer server has migrated ahead during a rolling deploy
+		{database.SchemaVersion + 1, true},
+	}
+	for _, tt := range tests {
+		if err := schemaReady(tt.applied); (err == nil) != tt.ready {
+			t.Errorf("schemaReady(%d) = %v, want ready %v", tt.applied, err, tt.ready)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// Opening hours and slot granularity used by the slot finder. These match
+// the server's default booking rules.
+const (
+	openMinute  = 7 * 60
+	closeMinute = 22 * 60
+	slotStep    = 15 * time.Minute
+	maxSlots    = 50
+)
+
+// showSlotFinder asks for a duration, date range and requirements, lists
+// the free slots across spaces, and books the one picked
+func (bs *BookingSystem) showSlotFinder() {
+	duration := widget.NewEntry()
+	duration.SetText("60")
+	from := widget.NewEntry()
+	from.SetText(time.Now().Format("2006-01-02"))
+	to := widget.NewEntry()
+	to.SetText(time.Now().AddDate(0, 0, 7).Format("2006-01-02"))
+	capacity := widget.NewEntry()
+	capacity.SetPlaceHolder("any")
+	amenities := widget.NewEntry()
+	amenities.SetPlaceHolder("projector, whiteboard")
+	buffer := widget.NewEntry()
+	buffer.SetText("0")
+	preferred := widget.NewCheckGroup(bs.spaces, nil)
+	preferred.Horizontal = true
+
+	var slots []freeSlot
+	var finder dialog.Dialog
+	status := widget.NewLabel("")
+	results := widget.NewList(
+		func() int { return len(slots) },
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(id widget.ListItemID, item fyne.CanvasObject) {
+			slot := slots[id]
+			text := fmt.Sprintf("%s  %s–%s  %s", slot.Start.Format("Mon 2006-01-02"),
+				slot.Start.Format("15:04"), slot.End.Format("15:04"), slot.SpaceName)
+			if slot.Preferred {
+				text += " ★"
+			}
+			item.(*widget.Label).SetText(text)
+		},
+	)
+	results.OnSelected = func(id widget.ListItemID) {
+		results.UnselectAll()
+		slot := slots[id]
+		bs.confirmSlot(slot, func() {
+			finder.Hide()
+			bs.window.Content().Refresh()
+		})
+	}
+
+	search := widget.NewButton("Find Slots", func() {
+		req, err := bs.slotQuery(duration.Text, from.Text, to.Text, capacity.Text, amenities.Text, buffer.Text, preferred.Selected)
+		if err != nil {
+			dialog.ShowError(err, bs.window)
+			return
+		}
+		slots = findSlots(req, bs.slotSpaces())
+		status.SetText(fmt.Sprintf("%d free slots, earliest first. Pick one to book it.", len(slots)))
+		results.Refresh()
+	})
+
+	form := widget.NewForm(
+		widget.NewFormItem("Duration (minutes)", duration),
+		widget.NewFormItem("From (YYYY-MM-DD)", from),
+		widget.NewFormItem("To (YYYY-MM-DD)", to),
+		widget.NewFormItem("Minimum capacity", capacity),
+		widget.NewFormItem("Amenities", amenities),
+		widget.NewFormItem("Buffer (minutes)", buffer),
+		widget.NewFormItem("Preferred spaces", preferred),
+	)
+	content := container.NewBorder(
+		container.NewVBox(form, search, status),
+		nil, nil, nil,
+		results,
+	)
+
+	finder = dialog.NewCustom("Find a Slot", "Close", content, bs.window)
+	finder.Resize(fyne.NewSize(600, 550))
+	finder.Show()
+}
+
+// slotQuery turns the finder's fields into a slot request
+func (bs *BookingSystem) slotQuery(duration, from, to, capacity, amenities, buffer string, preferred []string) (slotRequest, error) {
+	var req slotRequest
+
+	minutes, err := strconv.Atoi(strings.TrimSpace(duration))
+	if err != nil || minutes <= 0 {
+		return req, fmt.Errorf("duration must be a number of minutes")
+	}
+	start, err := time.ParseInLocation("2006-01-02", strings.TrimSpace(from), time.Local)
+	if err != nil {
+		return req, fmt.Errorf("invalid from date")
+	}
+	end, err := time.ParseInLocation("2006-01-02", strings.TrimSpace(to), time.Local)
+	if err != nil {
+		return req, fmt.Errorf("invalid to date")
+	}
+	seats, err := optionalInt(capacity)
+	if err != nil {
+		return req, fmt.Errorf("capacity must be a number")
+	}
+	gap, err := optionalInt(buffer)
+	if err != nil {
+		return req, fmt.Errorf("buffer must be a number of minutes")
+	}
+
+	req = slotRequest{
+		Duration: time.Duration(minutes) * time.Minute,
+		From:     start,
+		// The range includes the whole of the last day
+		To:        end.AddDate(0, 0, 1),
+		Capacity:  seats,
+		Amenities: splitList(amenities),
+		Limit:     maxSlots,
+		Buffer:    time.Duration(gap) * time.Minute,
+		NotBefore: time.Now(),
+	}
+	for _, name := range preferred {
+		req.Preferred = append(req.Preferred, bs.getSpaceID(name))
+	}
+	return req, nil
+}
+
+// slotSpaces returns every space with its details and current bookings
+func (bs *BookingSystem) slotSpaces() []slotSpace {
+	spaces := make([]slotSpace, len(bs.spaces))
+	for i, name := range bs.spaces {
+		spaces[i] = slotSpace{ID: i, Name: name}
+
+		var amenities string
+		err := bs.db.QueryRow("SELECT capacity, amenities FROM spaces WHERE name = ?", name).
+			Scan(&spaces[i].Capacity, &amenities)
+		if err == nil {
+			spaces[i].Amenities = splitList(amenities)
+		}
+	}
+
+	for _, b := range bs.snapshot() {
+		if !b.holdsSpace() {
+			continue
+		}
+		if i := bs.getSpaceID(b.Space); i >= 0 {
+			spaces[i].Busy = append(spaces[i].Busy, interval{Start: b.StartTime, End: b.EndTime})
+		}
+	}
+	return spaces
+}
+
+// confirmSlot asks who the booking is for and books slot, if it is still
+// clear of other bookings by the buffer it was found with
+func (bs *BookingSystem) confirmSlot(slot freeSlot, booked func()) {
+	user := widget.NewEntry()
+	notes := widget.NewMultiLineEntry()
+	title := fmt.Sprintf("Book %s, %s %s–%s", slot.SpaceName, slot.Start.Format("Mon Jan 2"),
+		slot.Start.Format("15:04"), slot.End.Format("15:04"))
+
+	dialog.ShowForm(title, "Book", "Cancel",
+		[]*widget.FormItem{
+			{Text: "User", Widget: user},
+			{Text: "Notes", Widget: notes},
+		},
+		func(submitted bool) {
+			if !submitted {
+				return
+			}
+			if bs.bookedWithin(slot.SpaceName, interval{Start: slot.Start, End: slot.End}, slot.Buffer) {
+				dialog.ShowError(fmt.Errorf("booking conflicts with existing reservation"), bs.window)
+				return
+			}
+			if err := bs.addBooking(slot.SpaceName, slot.Start, slot.End, user.Text, notes.Text); err != nil {
+				log.Printf("Error saving booking: %v", err)
+				dialog.ShowError(err, bs.window)
+				return
+			}
+			booked()
+		},
+		bs.window,
+	)
+}
+
+// bookedWithin reports whether space has a booking within buffer of span
+func (bs *BookingSystem) bookedWithin(space string, span interval, buffer time.Duration) bool {
+	for _, b := range bs.snapshot() {
+		if b.Space == space && b.holdsSpace() && span.overlaps(interval{Start: b.StartTime, End: b.EndTime}, buffer) {
+			return true
+		}
+	}
+	return false
+}
+
+// optionalInt parses a number field, treating blank as 0
+func optionalInt(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err == nil && n < 0 {
+		err = fmt.Errorf("%d is negative", n)
+	}
+	return n, err
+}
+
+// splitList splits a comma separated field, dropping blanks
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
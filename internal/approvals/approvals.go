@@ -0,0 +1,87 @@
+// internal/approvals/approvals.go
+package approvals
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"skedda-goclone/internal/audit"
+	"skedda-goclone/internal/events"
+	"skedda-goclone/internal/models"
+	"skedda-goclone/internal/notify"
+	"skedda-goclone/internal/webhook"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Kind is the recurring job that expires pending bookings
+const Kind = "approvals.expire"
+
+// Interval is how often pending bookings are checked, and so how long one
+// can hold its space after it started
+const Interval = time.Minute
+
+// batchSize is how many bookings one pass expires at most
+const batchSize = 100
+
+// ExpireOverdue cancels every booking still pending approval when it
+// starts, so it stops holding its space, and tells the people it was for.
+// It works a batch at a time, and several servers can run it at once:
+// each locks the bookings it expires and skips those another has locked.
+func ExpireOverdue(ctx context.Context, db *gorm.DB) error {
+	for {
+		n, err := Expire(ctx, db, time.Now())
+		if err != nil || n < batchSize {
+			return err
+		}
+	}
+}
+
+// Expire cancels up to batchSize bookings that were still pending at now,
+// and returns how many it cancelled
+func Expire(ctx context.Context, db *gorm.DB, now time.Time) (int, error) {
+	var expired []models.Booking
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND start_time <= ?", models.StatusPending, now).
+			Order("start_time").Limit(batchSize).
+			Find(&expired).Error
+		if err != nil {
+			return err
+		}
+
+		for i := range expired {
+			b := &expired[i]
+			before := *b
+			b.Status = models.StatusCancelled
+			if err := tx.Model(b).Update("status", b.Status).Error; err != nil {
+				return err
+			}
+			id := strconv.FormatUint(uint64(b.ID), 10)
+			if err := audit.RecordChange(tx, nil, "booking.expire", "booking", id, before, *b); err != nil {
+				return err
+			}
+			if err := notify.Enqueue(tx, models.EventBookingCancelled, b); err != nil {
+				return err
+			}
+			if err := webhook.Enqueue(tx, models.EventBookingCancelled, *b); err != nil {
+				return err
+			}
+			if err := events.Publish(tx, models.EventBookingCancelled, *b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if len(expired) > 0 {
+		slog.Info("expired bookings nobody approved", "count", len(expired))
+	}
+	return len(expired), nil
+}
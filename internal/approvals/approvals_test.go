@@ -0,0 +1,49 @@
+// internal/approvals/approvals_test.go
+package approvals
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"skedda-goclone/internal/dbtest"
+	"skedda-goclone/internal/models"
+)
+
+func TestExpire(t *testing.T) {
+	db := dbtest.Open(t)
+	now := time.Now()
+	space := models.Space{Name: "Room A", RequiresApproval: true}
+	if err := db.Create(&space).Error; err != nil {
+		t.Fatal(err)
+	}
+	book := func(status string, start time.Time) *models.Booking {
+		t.Helper()
+		b := &models.Booking{SpaceID: space.ID, StartTime: start, EndTime: start.Add(time.Hour), Status: status}
+		if err := db.Create(b).Error; err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	started := book(models.StatusPending, now.Add(-time.Minute))
+	upcoming := book(models.StatusPending, now.Add(time.Hour))
+	confirmed := book(models.StatusConfirmed, now.Add(-time.Minute))
+
+	n, err := Expire(context.Background(), db.DB, now)
+	if err != nil || n != 1 {
+		t.Fatalf("Expire = %d, %v, want 1 booking", n, err)
+	}
+	for b, want := range map[*models.Booking]string{
+		started:   models.StatusCancelled,
+		upcoming:  models.StatusPending,
+		confirmed: models.StatusConfirmed,
+	} {
+		var got models.Booking
+		if err := db.First(&got, b.ID).Error; err != nil {
+			t.Fatal(err)
+		}
+		if got.Status != want {
+			t.Errorf("booking starting %v is %s, want %s", b.StartTime, got.Status, want)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+// internal/audit/audit.go
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"skedda-goclone/internal/auth"
+	"skedda-goclone/internal/logging"
+	"skedda-goclone/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// Record appends an audit entry for an action taken by the caller of r.
+// details, if not nil, is stored as JSON. Pass the transaction the action
+// ran in, so the entry is only kept if the action is.
+func Record(tx *gorm.DB, r *http.Request, action, entityType, entityID string, details any) error {
+	return record(tx, r, action, entityType, entityID, details, nil)
+}
+
+// RecordChange is Record for a write to an entity, storing the fields
+// that differ between before and after. Pass nil before for a create and
+// nil after for a delete. r is nil for changes the server makes by itself,
+// such as background jobs, which are recorded without an actor.
+func RecordChange(tx *gorm.DB, r *http.Request, action, entityType, entityID string, before, after any) error {
+	changes, err := Diff(before, after)
+	if err != nil {
+		return err
+	}
+	return record(tx, r, action, entityType, entityID, nil, changes)
+}
+
+func record(tx *gorm.DB, r *http.Request, action, entityType, entityID string, details any, changes json.RawMessage) error {
+	entry := models.AuditEntry{
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   entityID,
+		Changes:    changes,
+	}
+	if r != nil {
+		entry.RequestID = logging.RequestID(r.Context())
+		entry.IP = ClientIP(r)
+		if p, ok := auth.FromContext(r.Context()); ok {
+			entry.ActorID = &p.TeacherID
+			if p.APIKeyID != 0 {
+				entry.APIKeyID = &p.APIKeyID
+			}
+		}
+		tx = tx.WithContext(r.Context())
+	}
+	if details != nil {
+		raw, err := json.Marshal(details)
+		if err != nil {
+			return err
+		}
+		entry.Details = raw
+	}
+	return tx.Create(&entry).Error
+}
+
+// ignoredFields change on every write and would only add noise to a diff
+var ignoredFields = map[string]bool{"UpdatedAt": true, "updated_at": true}
+
+// change is the value of one field before and after a write
+type change struct {
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+}
+
+// Diff compares the JSON forms of before and after, which should be the
+// same type, and returns the top-level fields that differ. Fields hidden
+// from JSON, such as password hashes, never appear.
+func Diff(before, after any) (json.RawMessage, error) {
+	old, err := fields(before)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := fields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make(map[string]change)
+	for name, value := range updated {
+		if !ignoredFields[name] && !bytes.Equal(old[name], value) {
+			changes[name] = change{Before: old[name], After: value}
+		}
+	}
+	for name, value := range old {
+		if _, ok := updated[name]; !ok && !ignoredFields[name] {
+			changes[name] = change{Before: value}
+		}
+	}
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(changes)
+}
+
+func fields(v any) (map[string]json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]json.RawMessage
+	err = json.Unmarshal(raw, &m)
+	return m, err
+}
+
+// ClientIP returns the address the request came from. Behind a trusted
+// proxy that is the client's address, which middleware.RealIP puts in
+// RemoteAddr.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,122 @@
+// internal/auth/apikey.go
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"skedda-goclone/internal/models"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// APIKeyPrefix starts every API key, which tells them apart from login
+// tokens and makes them easy to spot in leaked logs or repositories
+const APIKeyPrefix = "sk_"
+
+// Scopes an API key can be granted. A scope is a resource, the first path
+// segment after /api, and an access level: read for GET requests and
+// write for everything else.
+const (
+	ScopeStudentsRead  = "students:read"
+	ScopeStudentsWrite = "students:write"
+	ScopeSubjectsRead  = "subjects:read"
+	ScopeSubjectsWrite = "subjects:write"
+	ScopeBookingsRead  = "bookings:read"
+	ScopeBookingsWrite = "bookings:write"
+	ScopeSpacesRead    = "spaces:read"
+	ScopeSpacesWrite   = "spaces:write"
+	// ScopeEventsRead allows streaming live events, of the resources the
+	// key can read
+	ScopeEventsRead = "events:read"
+	// ScopeReportsRead allows fetching attendance reports
+	ScopeReportsRead = "reports:read"
+)
+
+// Scopes lists every scope an API key can be granted
+var Scopes = []string{
+	ScopeStudentsRead, ScopeStudentsWrite,
+	ScopeSubjectsRead, ScopeSubjectsWrite,
+	ScopeBookingsRead, ScopeBookingsWrite,
+	ScopeSpacesRead, ScopeSpacesWrite,
+	ScopeEventsRead, ScopeReportsRead,
+}
+
+// lastUsedResolution is how stale an API key's last use may be, so busy
+// keys don't cost a write on every request
+const lastUsedResolution = time.Minute
+
+// NewAPIKey returns a new random key and the hash to store for it
+func NewAPIKey() (key, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	key = APIKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return key, HashAPIKey(key), nil
+}
+
+// HashAPIKey returns the form an API key is stored in. Like recovery
+// codes, keys are random enough that a fast hash is safe.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// RequiredScope returns the scope an API key needs for r, or "" if API
+// keys can't be used for it at all
+func RequiredScope(r *http.Request) string {
+	resource, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/"), "/")
+	access := "write"
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		access = "read"
+	}
+	scope := resource + ":" + access
+	if !slices.Contains(Scopes, scope) {
+		return ""
+	}
+	return scope
+}
+
+var errAPIKeyRejected = errors.New("API key is unknown, revoked or expired")
+
+// apiKeyPrincipal looks up key and returns the caller it acts for. The
+// caller takes the owner's current role, so demoting a teacher also
+// limits their keys, and locking them out stops their keys too.
+func apiKeyPrincipal(ctx context.Context, db *gorm.DB, key string, now time.Time) (Principal, error) {
+	db = db.WithContext(ctx)
+	var apiKey models.APIKey
+	if err := db.Where("key_hash = ?", HashAPIKey(key)).First(&apiKey).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return Principal{}, errAPIKeyRejected
+		}
+		return Principal{}, err
+	}
+	if !apiKey.Usable(now) {
+		return Principal{}, errAPIKeyRejected
+	}
+
+	owner, err := account(ctx, db, apiKey.TeacherID, now)
+	if errors.Is(err, errAccountRejected) {
+		return Principal{}, errAPIKeyRejected
+	}
+	if err != nil {
+		return Principal{}, err
+	}
+
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) >= lastUsedResolution {
+		err := db.Model(&apiKey).UpdateColumn("last_used_at", now).Error
+		if err != nil {
+			return Principal{}, err
+		}
+	}
+
+	return Principal{TeacherID: owner.ID, Role: owner.Role, APIKeyID: apiKey.ID, Scopes: apiKey.Scopes}, nil
+}
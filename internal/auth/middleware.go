@@ -0,0 +1,177 @@
+// internal/auth/middleware.go
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"skedda-goclone/internal/logging"
+	"skedda-goclone/internal/models"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	TeacherID int64
+	Role      string
+	// Purpose is the purpose of the caller's token, empty for full access
+	Purpose string
+	// APIKeyID is set when the caller used an API key, which only allows
+	// the requests its Scopes cover
+	APIKeyID int64
+	Scopes   []string
+}
+
+// IsAdmin reports whether the caller holds the admin role
+func (p Principal) IsAdmin() bool {
+	return p.Role == models.RoleAdmin
+}
+
+type contextKey struct{}
+
+// WithPrincipal returns a context carrying p, and records p as the user
+// of the request for logging
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	logging.SetUserID(ctx, p.TeacherID)
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// FromContext returns the authenticated caller, if there is one
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(contextKey{}).(Principal)
+	return p, ok
+}
+
+// HasScope reports whether the caller may make requests needing scope.
+// Callers who logged in rather than using an API key have every scope.
+func (p Principal) HasScope(scope string) bool {
+	return p.APIKeyID == 0 || (scope != "" && slices.Contains(p.Scopes, scope))
+}
+
+// Authenticator verifies the bearer token or API key on each request
+type Authenticator struct {
+	Secret []byte
+	// DB is where API keys are looked up
+	DB *gorm.DB
+}
+
+// Require rejects requests without a valid, full access bearer token, or
+// an API key with the scope the request needs
+func (a *Authenticator) Require(next http.Handler) http.Handler {
+	return a.require(next, "")
+}
+
+// RequireEnrollment is Require, but also accepts tokens that only allow
+// enrolling a second factor
+func (a *Authenticator) RequireEnrollment(next http.Handler) http.Handler {
+	return a.require(next, "", PurposeEnroll)
+}
+
+func (a *Authenticator) require(next http.Handler, purposes ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := bearerToken(r)
+		if !ok {
+			unauthorized(w, "Authentication required")
+			return
+		}
+
+		if strings.HasPrefix(token, APIKeyPrefix) {
+			a.requireAPIKey(w, r, next, token)
+			return
+		}
+
+		claims, err := VerifyToken(a.Secret, token, time.Now())
+		if err != nil || !slices.Contains(purposes, claims.Purpose) {
+			unauthorized(w, "Invalid or expired token")
+			return
+		}
+
+		teacher, err := account(r.Context(), a.DB, claims.TeacherID, time.Now())
+		if errors.Is(err, errAccountRejected) {
+			unauthorized(w, "Account is locked or no longer exists")
+			return
+		}
+		if err != nil {
+			logging.FromContext(r.Context()).Error("looking up account", "error", err)
+			http.Error(w, "Error checking account", http.StatusInternalServerError)
+			return
+		}
+
+		ctx := WithPrincipal(r.Context(), Principal{TeacherID: teacher.ID, Role: teacher.Role, Purpose: claims.Purpose})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+var errAccountRejected = errors.New("account is locked or no longer exists")
+
+// account loads the caller's account as it is at now. Callers take its
+// current role rather than the one in their token, so demoting, deleting
+// or locking out a teacher takes effect at once, not when their tokens
+// expire.
+func account(ctx context.Context, db *gorm.DB, teacherID int64, now time.Time) (models.Teacher, error) {
+	var teacher models.Teacher
+	err := db.WithContext(ctx).Select("id", "role", "locked_until").First(&teacher, teacherID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return teacher, errAccountRejected
+	}
+	if err != nil {
+		return teacher, err
+	}
+	if teacher.IsLocked(now) {
+		return teacher, errAccountRejected
+	}
+	return teacher, nil
+}
+
+func (a *Authenticator) requireAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, key string) {
+	p, err := apiKeyPrincipal(r.Context(), a.DB, key, time.Now())
+	if errors.Is(err, errAPIKeyRejected) {
+		unauthorized(w, "Invalid, revoked or expired API key")
+		return
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("looking up API key", "error", err)
+		http.Error(w, "Error checking API key", http.StatusInternalServerError)
+		return
+	}
+
+	ctx := WithPrincipal(r.Context(), p)
+	if scope := RequiredScope(r); !p.HasScope(scope) {
+		if scope == "" {
+			http.Error(w, "API keys can't be used for this request", http.StatusForbidden)
+		} else {
+			http.Error(w, "API key lacks the "+scope+" scope", http.StatusForbidden)
+		}
+		return
+	}
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// RequireAdmin rejects requests whose caller is not an admin. It must run
+// after Require.
+func RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p, ok := FromContext(r.Context()); !ok || !p.IsAdmin() {
+			http.Error(w, "Admin access required", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return token, true
+}
+
+func unauthorized(w http.ResponseWriter, message string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="skedda"`)
+	http.Error(w, message, http.StatusUnauthorized)
+}
@@ -0,0 +1,51 @@
+// internal/auth/middleware_test.go
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"skedda-goclone/internal/dbtest"
+	"skedda-goclone/internal/models"
+)
+
+func TestRequireTakesCurrentRole(t *testing.T) {
+	db := dbtest.Open(t).DB
+	admin := models.Teacher{Name: "Ana", Email: "ana@example.com", Role: models.RoleAdmin}
+	if err := db.Create(&admin).Error; err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	token, err := IssueToken(testSecret, Claims{TeacherID: admin.ID, Role: models.RoleAdmin, IssuedAt: now, ExpiresAt: now.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := &Authenticator{Secret: testSecret, DB: db}
+	h := a.Require(RequireAdmin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	call := func() int {
+		r := httptest.NewRequest(http.MethodGet, "/api/jobs", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := call(); code != http.StatusOK {
+		t.Fatalf("admin got %d", code)
+	}
+	if err := db.Model(&admin).Update("role", models.RoleTeacher).Error; err != nil {
+		t.Fatal(err)
+	}
+	if code := call(); code != http.StatusForbidden {
+		t.Errorf("demoted admin got %d, want 403", code)
+	}
+	if err := db.Model(&admin).Update("locked_until", now.Add(time.Hour)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if code := call(); code != http.StatusUnauthorized {
+		t.Errorf("locked out teacher got %d, want 401", code)
+	}
+}
@@ -0,0 +1,136 @@
+// internal/auth/oidc_test.go
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"skedda-goclone/internal/auth"
+	"skedda-goclone/internal/mockidp"
+)
+
+const redirectURL = "http://app.test/api/auth/oidc/callback"
+
+// newProvider starts a mock identity provider and returns a provider
+// configured for it, with issuer written as configIssuer would be
+func newProvider(t *testing.T, configIssuer func(base string) string) *auth.OIDCProvider {
+	t.Helper()
+	srv := httptest.NewServer(nil)
+	t.Cleanup(srv.Close)
+	idp, err := mockidp.New(srv.URL, "client", "secret",
+		mockidp.User{Subject: "1", Email: "ada@example.com", Name: "Ada", EmailVerified: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Config.Handler = idp
+	return &auth.OIDCProvider{
+		Issuer:       configIssuer(srv.URL),
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"openid", "email"},
+	}
+}
+
+// signIn runs the browser's part of the flow and returns the code
+func signIn(t *testing.T, p *auth.OIDCProvider, state, nonce, verifier string) string {
+	t.Helper()
+	target, err := p.AuthCodeURL(context.Background(), state, nonce, verifier)
+	if err != nil {
+		t.Fatalf("AuthCodeURL() = %v", err)
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.StatusCode != http.StatusFound {
+		t.Fatalf("provider returned %s to %q", resp.Status, resp.Header.Get("Location"))
+	}
+	if got := callback.Scheme + "://" + callback.Host + callback.Path; got != redirectURL {
+		t.Errorf("redirected to %s, want %s", got, redirectURL)
+	}
+	if callback.Query().Get("state") != state {
+		t.Errorf("state = %q, want %q", callback.Query().Get("state"), state)
+	}
+	return callback.Query().Get("code")
+}
+
+func TestOIDCLoginFlow(t *testing.T) {
+	issuers := map[string]func(string) string{
+		"exact":          func(base string) string { return base },
+		"trailing slash": func(base string) string { return base + "/" },
+	}
+	for name, issuer := range issuers {
+		t.Run(name, func(t *testing.T) {
+			p := newProvider(t, issuer)
+			ctx := context.Background()
+			code := signIn(t, p, "state", "nonce", "verifier-verifier-verifier-verifier-verifier")
+
+			raw, err := p.Exchange(ctx, code, "verifier-verifier-verifier-verifier-verifier")
+			if err != nil {
+				t.Fatalf("Exchange() = %v", err)
+			}
+			claims, err := p.VerifyIDToken(ctx, raw, "nonce", time.Now())
+			if err != nil {
+				t.Fatalf("VerifyIDToken() = %v", err)
+			}
+			if claims.Email != "ada@example.com" || !claims.EmailVerified || claims.Subject != "1" {
+				t.Errorf("claims = %+v", claims)
+			}
+		})
+	}
+}
+
+func TestOIDCRejectsBadTokens(t *testing.T) {
+	p := newProvider(t, func(base string) string { return base })
+	ctx := context.Background()
+	verifier := "verifier-verifier-verifier-verifier-verifier"
+
+	raw, err := p.Exchange(ctx, signIn(t, p, "s", "nonce", verifier), verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.VerifyIDToken(ctx, raw, "other nonce", time.Now()); !errors.Is(err, auth.ErrInvalidToken) {
+		t.Errorf("wrong nonce: %v, want ErrInvalidToken", err)
+	}
+	if _, err := p.VerifyIDToken(ctx, raw, "nonce", time.Now().Add(time.Hour)); !errors.Is(err, auth.ErrExpiredToken) {
+		t.Errorf("expired: %v, want ErrExpiredToken", err)
+	}
+	if _, err := p.VerifyIDToken(ctx, raw[:len(raw)-4]+"AAAA", "nonce", time.Now()); !errors.Is(err, auth.ErrInvalidToken) {
+		t.Errorf("bad signature: %v, want ErrInvalidToken", err)
+	}
+
+	// A token for another client of the same provider is refused
+	other := &auth.OIDCProvider{Issuer: p.Issuer, ClientID: "someone-else", RedirectURL: redirectURL}
+	if _, err := other.VerifyIDToken(ctx, raw, "nonce", time.Now()); err == nil {
+		t.Error("token for another client accepted")
+	}
+
+	// A code can't be exchanged without the verifier it was issued for
+	if _, err := p.Exchange(ctx, signIn(t, p, "s", "nonce", verifier), "wrong"); err == nil {
+		t.Error("exchange with the wrong PKCE verifier succeeded")
+	}
+}
+
+func TestOIDCRejectsOtherIssuer(t *testing.T) {
+	// The provider is reached at the configured URL but names a different
+	// issuer, so it mustn't be trusted
+	idp, err := mockidp.New("https://other.example.com/", "client", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(idp)
+	defer srv.Close()
+	p := &auth.OIDCProvider{Issuer: srv.URL, ClientID: "client", RedirectURL: redirectURL}
+	if _, err := p.AuthCodeURL(context.Background(), "s", "n", "v"); err == nil {
+		t.Error("provider with a different issuer accepted")
+	}
+}
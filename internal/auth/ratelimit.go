@@ -0,0 +1,71 @@
+// internal/auth/ratelimit.go
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter is an in-memory token bucket per key. Each key may make burst
+// attempts at once, refilled at burst per window.
+type Limiter struct {
+	burst  float64
+	window time.Duration
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func NewLimiter(burst int, window time.Duration) *Limiter {
+	return &Limiter{
+		burst:   float64(burst),
+		window:  window,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow spends one attempt for key. When none are left it reports how
+// long until the next one is available.
+func (l *Limiter) Allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.prune(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	rate := l.burst / l.window.Seconds()
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// prune drops buckets that have refilled completely, bounding memory use
+// to the keys seen within roughly one window
+func (l *Limiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < l.window {
+		return
+	}
+	l.lastPrune = now
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= l.window {
+			delete(l.buckets, key)
+		}
+	}
+}
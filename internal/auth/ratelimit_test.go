@@ -0,0 +1,51 @@
+// internal/auth/ratelimit_test.go
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	l := NewLimiter(3, time.Minute)
+	now := time.Date(2026, 1, 1, 9, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("a", now); !ok {
+			t.Fatalf("attempt %d refused within the burst", i+1)
+		}
+	}
+	ok, wait := l.Allow("a", now)
+	if ok {
+		t.Fatal("attempt past the burst allowed")
+	}
+	if wait != 20*time.Second {
+		t.Errorf("wait = %v, want 20s for one attempt at 3 a minute", wait)
+	}
+
+	// Other keys have their own bucket
+	if ok, _ := l.Allow("b", now); !ok {
+		t.Error("a fresh key was refused")
+	}
+
+	// One attempt comes back after a third of the window
+	if ok, _ := l.Allow("a", now.Add(20*time.Second)); !ok {
+		t.Error("attempt refused after the bucket refilled")
+	}
+	if ok, _ := l.Allow("a", now.Add(20*time.Second)); ok {
+		t.Error("refill gave back more than one attempt")
+	}
+}
+
+func TestLimiterPrunesIdleKeys(t *testing.T) {
+	l := NewLimiter(1, time.Minute)
+	now := time.Date(2026, 1, 1, 9, 0, 0, 0, time.UTC)
+	l.Allow("a", now)
+	l.Allow("b", now.Add(2*time.Minute))
+	if _, ok := l.buckets["a"]; ok {
+		t.Error("bucket idle for a whole window was kept")
+	}
+	if len(l.buckets) != 1 {
+		t.Errorf("%d buckets, want 1", len(l.buckets))
+	}
+}
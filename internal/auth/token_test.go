@@ -0,0 +1,75 @@
+// internal/auth/token_test.go
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testSecret = []byte("0123456789abcdef0123456789abcdef")
+
+func TestTokenRoundTrip(t *testing.T) {
+	now := time.Date(2026, 1, 1, 9, 0, 0, 0, time.UTC)
+	claims := Claims{TeacherID: 7, Role: "admin", Purpose: PurposeMFA, IssuedAt: now, ExpiresAt: now.Add(time.Hour)}
+	token, err := IssueToken(testSecret, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := VerifyToken(testSecret, token, now.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("VerifyToken() = %v", err)
+	}
+	if got.TeacherID != 7 || got.Role != "admin" || got.Purpose != PurposeMFA || !got.ExpiresAt.Equal(claims.ExpiresAt) {
+		t.Errorf("claims = %+v, want %+v", got, claims)
+	}
+
+	if _, err := VerifyToken(testSecret, token, now.Add(time.Hour)); !errors.Is(err, ErrExpiredToken) {
+		t.Errorf("at expiry VerifyToken() = %v, want ErrExpiredToken", err)
+	}
+}
+
+func TestTokenRejectsTampering(t *testing.T) {
+	now := time.Date(2026, 1, 1, 9, 0, 0, 0, time.UTC)
+	token, err := IssueToken(testSecret, Claims{TeacherID: 7, Role: "teacher", ExpiresAt: now.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, err := IssueToken(testSecret, Claims{TeacherID: 7, Role: "admin", ExpiresAt: now.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, signature, _ := strings.Cut(token, ".")
+	adminPayload, _, _ := strings.Cut(admin, ".")
+
+	// A value sealed for another use must not pass as a login token
+	other, err := Seal(testSecret, "feed", Claims{TeacherID: 7, ExpiresAt: now.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, bad := range map[string]string{
+		"other secret":    mustIssue(t, []byte("another secret entirely, 32 chars"), now),
+		"swapped payload": adminPayload + "." + signature,
+		"no signature":    payload,
+		"empty":           "",
+		"other kind":      other,
+		"garbage":         "!!!.???",
+		"truncated":       token[:len(token)-2],
+	} {
+		if _, err := VerifyToken(testSecret, bad, now); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: VerifyToken() = %v, want ErrInvalidToken", name, err)
+		}
+	}
+}
+
+func mustIssue(t *testing.T, secret []byte, now time.Time) string {
+	t.Helper()
+	token, err := IssueToken(secret, Claims{TeacherID: 7, ExpiresAt: now.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
@@ -0,0 +1,126 @@
+// internal/auth/totp_test.go
+package auth
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key from the RFC 6238 test vectors, in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestValidateTOTPVectors(t *testing.T) {
+	// The RFC gives eight digit codes; these are their last six
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		now := time.Unix(tt.unix, 0)
+		step, ok := ValidateTOTP(rfcSecret, tt.code, now, 0)
+		if !ok || step != tt.unix/totpPeriod {
+			t.Errorf("ValidateTOTP at %d = %d, %v; want step %d", tt.unix, step, ok, tt.unix/totpPeriod)
+		}
+	}
+}
+
+func TestValidateTOTPSkewAndReplay(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	// One period late is still accepted
+	if _, ok := ValidateTOTP(rfcSecret, "081804", now.Add(totpPeriod*time.Second), 0); !ok {
+		t.Error("code from the previous period rejected")
+	}
+	if _, ok := ValidateTOTP(rfcSecret, "081804", now.Add(2*totpPeriod*time.Second), 0); ok {
+		t.Error("code from two periods ago accepted")
+	}
+	// A code whose step was already used is rejected
+	step, _ := ValidateTOTP(rfcSecret, "081804", now, 0)
+	if _, ok := ValidateTOTP(rfcSecret, "081804", now, step); ok {
+		t.Error("code accepted twice")
+	}
+	// Secrets may be stored in lower case
+	if _, ok := ValidateTOTP(strings.ToLower(rfcSecret), "081804", now, 0); !ok {
+		t.Error("lower case secret rejected")
+	}
+}
+
+func TestValidateTOTPRejects(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	// With an empty key the code for any step is public knowledge
+	emptyKeyCode := totpCode(nil, now.Unix()/totpPeriod)
+	tests := []struct {
+		name, secret, code string
+	}{
+		{"empty secret", "", emptyKeyCode},
+		{"wrong code", rfcSecret, "000000"},
+		{"short code", rfcSecret, "81804"},
+		{"bad secret", "not base32!", "081804"},
+	}
+	for _, tt := range tests {
+		if _, ok := ValidateTOTP(tt.secret, tt.code, now, 0); ok {
+			t.Errorf("%s: code accepted", tt.name)
+		}
+	}
+}
+
+func TestNewTOTPSecret(t *testing.T) {
+	secret, err := NewTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := base32NoPad.DecodeString(secret)
+	if err != nil || len(key) != 20 {
+		t.Errorf("secret %q decodes to %d bytes, %v", secret, len(key), err)
+	}
+	code := totpCode(key, time.Now().Unix()/totpPeriod)
+	if _, ok := ValidateTOTP(secret, code, time.Now(), 0); !ok {
+		t.Error("code for a new secret rejected")
+	}
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	raw := TOTPProvisioningURI("Skedda", "ada@example.com", rfcSecret)
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/Skedda:ada@example.com" {
+		t.Errorf("unexpected URI %s", raw)
+	}
+	q := u.Query()
+	if q.Get("secret") != rfcSecret || q.Get("issuer") != "Skedda" || q.Get("digits") != "6" || q.Get("period") != "30" {
+		t.Errorf("unexpected parameters in %s", raw)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := NewRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 9 || code[4] != '-' {
+			t.Errorf("code %q isn't formatted as xxxx-xxxx", code)
+		}
+		if seen[code] {
+			t.Errorf("code %q repeated", code)
+		}
+		seen[code] = true
+	}
+
+	// Codes are matched however they are typed back
+	want := HashRecoveryCode("k7m2-q9xd")
+	for _, typed := range []string{"K7M2-Q9XD", "k7m2q9xd", "k7m2 q9xd"} {
+		if HashRecoveryCode(typed) != want {
+			t.Errorf("%q hashes differently from k7m2-q9xd", typed)
+		}
+	}
+}
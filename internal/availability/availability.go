@@ -0,0 +1,218 @@
+// internal/availability/availability.go
+package availability
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// Interval is a span of time from Start up to End
+type Interval struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Overlaps reports whether i and o share any time once i is widened by
+// buffer on each side
+func (i Interval) Overlaps(o Interval, buffer time.Duration) bool {
+	return i.Start.Add(-buffer).Before(o.End) && i.End.Add(buffer).After(o.Start)
+}
+
+// Space is a bookable space and the times it is already booked
+type Space struct {
+	ID        int64
+	Name      string
+	Capacity  int
+	Amenities []string
+	Busy      []Interval
+}
+
+// Rules are the constraints every slot must meet
+type Rules struct {
+	// OpenMinute and CloseMinute are the opening hours, in minutes since
+	// midnight in Location
+	OpenMinute  int
+	CloseMinute int
+	Location    *time.Location
+	// Buffer is the gap left between a slot and neighbouring bookings
+	Buffer time.Duration
+	// Step is the granularity of slot start times, counted from opening
+	Step time.Duration
+	// NotBefore is the earliest a slot may start, usually now
+	NotBefore time.Time
+}
+
+// WeeklyWindow is a span of a weekday when someone is available, in
+// minutes since midnight
+type WeeklyWindow struct {
+	Weekday     time.Weekday
+	StartMinute int
+	EndMinute   int
+}
+
+// Participant is a person who must be free for a slot: not busy, and
+// within their weekly hours. A participant with no Hours is available
+// whenever spaces are open.
+type Participant struct {
+	Busy  []Interval
+	Hours []WeeklyWindow
+}
+
+// Available reports whether i falls within p's weekly hours in loc
+func (p *Participant) Available(i Interval, loc *time.Location) bool {
+	if len(p.Hours) == 0 {
+		return true
+	}
+	start, end := i.Start.In(loc), i.End.In(loc)
+	if start.YearDay() != end.YearDay() || start.Year() != end.Year() {
+		return false
+	}
+	from := start.Hour()*60 + start.Minute()
+	to := end.Hour()*60 + end.Minute()
+	return slices.ContainsFunc(p.Hours, func(w WeeklyWindow) bool {
+		return w.Weekday == start.Weekday() && w.StartMinute <= from && to <= w.EndMinute
+	})
+}
+
+// Request describes the slots wanted
+type Request struct {
+	Duration time.Duration
+	From     time.Time
+	To       time.Time
+	// Capacity is the fewest people a space must hold, 0 for any
+	Capacity  int
+	Amenities []string
+	// Preferred spaces rank ahead of others for the same start time
+	Preferred []int64
+	// Limit is the most slots returned
+	Limit int
+	// Participants must all be free for a slot as well as the space
+	Participants []Participant
+}
+
+// Slot is a free span in one space
+type Slot struct {
+	SpaceID   int64     `json:"space_id"`
+	SpaceName string    `json:"space_name"`
+	Start     time.Time `json:"start_time"`
+	End       time.Time `json:"end_time"`
+	Preferred bool      `json:"preferred"`
+	// spare is how much more the space holds than needed
+	spare int
+}
+
+// Suits reports whether space has the capacity and amenities req asks for.
+// Amenities match case-insensitively.
+func (req *Request) Suits(space *Space) bool {
+	if req.Capacity > 0 && space.Capacity < req.Capacity {
+		return false
+	}
+	for _, want := range req.Amenities {
+		if !slices.ContainsFunc(space.Amenities, func(have string) bool { return strings.EqualFold(have, want) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// Find returns free slots of req.Duration between req.From and req.To in
+// the spaces that suit req. Slots are ranked by start time, then
+// preferred spaces first, then the space that fits the group most tightly.
+func Find(req Request, rules Rules, spaces []Space) []Slot {
+	var slots []Slot
+	for i := range spaces {
+		space := &spaces[i]
+		if !req.Suits(space) {
+			continue
+		}
+		preferred := slices.Contains(req.Preferred, space.ID)
+		busy := space.Busy
+		for _, p := range req.Participants {
+			busy = append(slices.Clip(busy), p.Busy...)
+		}
+		for _, free := range FreeSpans(req, rules, busy) {
+			if !req.participantsAvailable(free, rules.Location) {
+				continue
+			}
+			slots = append(slots, Slot{
+				SpaceID:   space.ID,
+				SpaceName: space.Name,
+				Start:     free.Start,
+				End:       free.End,
+				Preferred: preferred,
+				spare:     space.Capacity - req.Capacity,
+			})
+		}
+	}
+
+	slices.SortStableFunc(slots, func(a, b Slot) int {
+		if c := a.Start.Compare(b.Start); c != 0 {
+			return c
+		}
+		if a.Preferred != b.Preferred {
+			if a.Preferred {
+				return -1
+			}
+			return 1
+		}
+		return a.spare - b.spare
+	})
+	if req.Limit > 0 && len(slots) > req.Limit {
+		slots = slots[:req.Limit]
+	}
+	return slots
+}
+
+func (req *Request) participantsAvailable(i Interval, loc *time.Location) bool {
+	if loc == nil {
+		loc = time.Local
+	}
+	for _, p := range req.Participants {
+		if !p.Available(i, loc) {
+			return false
+		}
+	}
+	return true
+}
+
+// FreeSpans returns every start, on rules.Step within opening hours, for
+// which a span of req.Duration between req.From and req.To stays clear of
+// busy by at least rules.Buffer
+func FreeSpans(req Request, rules Rules, busy []Interval) []Interval {
+	loc := rules.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	step := rules.Step
+	if step <= 0 {
+		step = 15 * time.Minute
+	}
+	from := req.From
+	if from.Before(rules.NotBefore) {
+		from = rules.NotBefore
+	}
+
+	var spans []Interval
+	first := from.In(loc)
+	for day := time.Date(first.Year(), first.Month(), first.Day(), 0, 0, 0, 0, loc); day.Before(req.To); day = day.AddDate(0, 0, 1) {
+		open := time.Date(day.Year(), day.Month(), day.Day(), 0, rules.OpenMinute, 0, 0, loc)
+		closing := time.Date(day.Year(), day.Month(), day.Day(), 0, rules.CloseMinute, 0, 0, loc)
+		if req.To.Before(closing) {
+			closing = req.To
+		}
+
+		start := open
+		if start.Before(from) {
+			// Round up onto the step grid
+			start = open.Add((from.Sub(open) + step - 1) / step * step)
+		}
+		for ; !start.Add(req.Duration).After(closing); start = start.Add(step) {
+			candidate := Interval{Start: start, End: start.Add(req.Duration)}
+			if !slices.ContainsFunc(busy, func(b Interval) bool { return candidate.Overlaps(b, rules.Buffer) }) {
+				spans = append(spans, candidate)
+			}
+		}
+	}
+	return spans
+}
@@ -0,0 +1,123 @@
+// internal/availability/availability_test.go
+package availability
+
+import (
+	"testing"
+	"time"
+)
+
+// at returns the time on Monday 3 June 2024 at hh:mm UTC
+func at(hh, mm int) time.Time {
+	return time.Date(2024, time.June, 3, hh, mm, 0, 0, time.UTC)
+}
+
+var rules = Rules{OpenMinute: 9 * 60, CloseMinute: 12 * 60, Location: time.UTC, Step: 30 * time.Minute}
+
+func TestOverlaps(t *testing.T) {
+	i := Interval{at(10, 0), at(11, 0)}
+	tests := []struct {
+		o      Interval
+		buffer time.Duration
+		want   bool
+	}{
+		{Interval{at(10, 30), at(11, 30)}, 0, true},
+		{Interval{at(11, 0), at(12, 0)}, 0, false},
+		{Interval{at(11, 0), at(12, 0)}, time.Minute, true},
+		{Interval{at(11, 15), at(12, 0)}, 15 * time.Minute, false},
+		{Interval{at(9, 0), at(10, 0)}, 0, false},
+	}
+	for _, tt := range tests {
+		if got := i.Overlaps(tt.o, tt.buffer); got != tt.want {
+			t.Errorf("Overlaps(%v–%v, %v) = %v, want %v", tt.o.Start.Format("15:04"), tt.o.End.Format("15:04"), tt.buffer, got, tt.want)
+		}
+	}
+}
+
+func TestFreeSpans(t *testing.T) {
+	req := Request{Duration: time.Hour, From: at(0, 0), To: at(23, 0)}
+	busy := []Interval{{at(10, 0), at(10, 30)}}
+	got := FreeSpans(req, rules, busy)
+	want := []time.Time{at(9, 0), at(10, 30), at(11, 0)}
+	if len(got) != len(want) {
+		t.Fatalf("FreeSpans = %v, want starts %v", got, want)
+	}
+	for i, span := range got {
+		if !span.Start.Equal(want[i]) || span.End.Sub(span.Start) != time.Hour {
+			t.Errorf("span %d = %v, want an hour from %v", i, span, want[i])
+		}
+	}
+
+	// Starting mid step rounds up onto the grid, and the buffer keeps slots
+	// clear of the booking
+	buffered := rules
+	buffered.Buffer = 30 * time.Minute
+	buffered.NotBefore = at(9, 10)
+	req.Duration = 30 * time.Minute
+	got = FreeSpans(req, buffered, busy)
+	if len(got) != 2 || !got[0].Start.Equal(at(11, 0)) || !got[1].Start.Equal(at(11, 30)) {
+		t.Errorf("FreeSpans with buffer = %v, want spans at 11:00 and 11:30", got)
+	}
+}
+
+func TestParticipantAvailable(t *testing.T) {
+	p := Participant{Hours: []WeeklyWindow{{Weekday: time.Monday, StartMinute: 9 * 60, EndMinute: 11 * 60}}}
+	if !p.Available(Interval{at(9, 0), at(11, 0)}, time.UTC) {
+		t.Error("span filling the window not available")
+	}
+	if p.Available(Interval{at(10, 30), at(11, 30)}, time.UTC) {
+		t.Error("span running past the window available")
+	}
+	if p.Available(Interval{at(9, 0), at(10, 0)}.shift(24*time.Hour), time.UTC) {
+		t.Error("span on another weekday available")
+	}
+	if !(&Participant{}).Available(Interval{at(3, 0), at(4, 0)}, time.UTC) {
+		t.Error("participant without hours not always available")
+	}
+}
+
+func (i Interval) shift(d time.Duration) Interval {
+	return Interval{i.Start.Add(d), i.End.Add(d)}
+}
+
+func TestFind(t *testing.T) {
+	spaces := []Space{
+		{ID: 1, Name: "Hall", Capacity: 30},
+		{ID: 2, Name: "Lab", Capacity: 10, Amenities: []string{"Projector"}},
+		{ID: 3, Name: "Booth", Capacity: 2},
+		{ID: 4, Name: "Busy", Capacity: 10, Busy: []Interval{{at(9, 0), at(12, 0)}}},
+	}
+	req := Request{
+		Duration:     time.Hour,
+		From:         at(0, 0),
+		To:           at(23, 0),
+		Capacity:     5,
+		Limit:        3,
+		Participants: []Participant{{Busy: []Interval{{at(9, 0), at(10, 0)}}}},
+	}
+	got := Find(req, rules, spaces)
+	want := []struct {
+		space int64
+		start time.Time
+	}{{2, at(10, 0)}, {1, at(10, 0)}, {2, at(10, 30)}}
+	if len(got) != len(want) {
+		t.Fatalf("Find = %v, want %d slots", got, len(want))
+	}
+	for i, w := range want {
+		if got[i].SpaceID != w.space || !got[i].Start.Equal(w.start) {
+			t.Errorf("slot %d = space %d at %v, want space %d at %v", i, got[i].SpaceID, got[i].Start, w.space, w.start)
+		}
+	}
+
+	// A preferred space ranks ahead of a tighter fit, and amenities match
+	// whatever their case
+	req.Preferred = []int64{1}
+	if got := Find(req, rules, spaces); got[0].SpaceID != 1 || !got[0].Preferred {
+		t.Errorf("preferred space not first: %v", got[0])
+	}
+	req.Amenities = []string{"projector"}
+	for _, slot := range Find(req, rules, spaces) {
+		if slot.SpaceID != 2 {
+			t.Errorf("slot in space %d, which has no projector", slot.SpaceID)
+		}
+	}
+}
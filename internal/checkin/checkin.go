@@ -0,0 +1,84 @@
+// internal/checkin/checkin.go
+package checkin
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"skedda-goclone/internal/audit"
+	"skedda-goclone/internal/events"
+	"skedda-goclone/internal/metrics"
+	"skedda-goclone/internal/models"
+	"skedda-goclone/internal/webhook"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// batchSize is how many bookings one pass releases at most
+const batchSize = 100
+
+// ReleaseOverdue releases every confirmed booking that nobody checked in
+// to before its space's check-in window closed, marking them as no-shows
+// so the space can be booked again. It works a batch at a time, and
+// several servers can run it at once: each locks the bookings it releases
+// and skips those another has locked.
+func ReleaseOverdue(ctx context.Context, db *gorm.DB) error {
+	for {
+		n, err := Release(ctx, db, time.Now())
+		if err != nil || n < batchSize {
+			return err
+		}
+	}
+}
+
+// Release marks up to batchSize bookings whose check-in window closed
+// before now as no-shows, and returns how many it released
+func Release(ctx context.Context, db *gorm.DB, now time.Time) (int, error) {
+	var released []models.Booking
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "bookings"}, Options: "SKIP LOCKED"}).
+			Select("bookings.*").
+			Joins("JOIN spaces ON spaces.id = bookings.space_id").
+			Where("bookings.status = ? AND bookings.checked_in_at IS NULL AND spaces.check_in_minutes > 0", models.StatusConfirmed).
+			Where("bookings.start_time + spaces.check_in_minutes * INTERVAL '1 minute' < ?", now).
+			Order("bookings.start_time").Limit(batchSize).
+			Find(&released).Error
+		if err != nil {
+			return err
+		}
+
+		for i := range released {
+			b := &released[i]
+			before := *b
+			b.Status = models.StatusNoShow
+			if err := tx.Model(b).Update("status", b.Status).Error; err != nil {
+				return err
+			}
+			id := strconv.FormatUint(uint64(b.ID), 10)
+			if err := audit.RecordChange(tx, nil, "booking.no_show", "booking", id, before, *b); err != nil {
+				return err
+			}
+			if err := webhook.Enqueue(tx, models.EventBookingNoShow, *b); err != nil {
+				return err
+			}
+			if err := events.Publish(tx, models.EventBookingNoShow, *b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, b := range released {
+		metrics.BookingsReleased.WithLabelValues(strconv.FormatInt(b.SpaceID, 10)).Inc()
+	}
+	if len(released) > 0 {
+		slog.Info("released unclaimed bookings", "count", len(released))
+	}
+	return len(released), nil
+}
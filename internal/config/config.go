@@ -0,0 +1,480 @@
+// internal/config/config.go
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/mail"
+	"net/netip"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Config is the server configuration. Values are layered: built-in
+// defaults, then the TOML file, then environment variables, then flags.
+type Config struct {
+	Server   ServerConfig   `toml:"server"`
+	Database DatabaseConfig `toml:"database"`
+	Auth     AuthConfig     `toml:"auth"`
+	CORS     CORSConfig     `toml:"cors"`
+	Booking  BookingConfig  `toml:"booking"`
+	Mail     MailConfig     `toml:"mail"`
+	Log      LogConfig      `toml:"log"`
+	OIDC     OIDCConfig     `toml:"oidc"`
+	Webhooks WebhookConfig  `toml:"webhooks"`
+	Jobs     JobConfig      `toml:"jobs"`
+	// Retention is how long old records are kept before they are purged
+	Retention RetentionConfig `toml:"retention"`
+}
+
+type ServerConfig struct {
+	Addr              string        `toml:"addr"`
+	TLSCertFile       string        `toml:"tls_cert_file"`
+	TLSKeyFile        string        `toml:"tls_key_file"`
+	ReadTimeout       time.Duration `toml:"read_timeout"`
+	ReadHeaderTimeout time.Duration `toml:"read_header_timeout"`
+	WriteTimeout      time.Duration `toml:"write_timeout"`
+	IdleTimeout       time.Duration `toml:"idle_timeout"`
+	MaxHeaderBytes    int           `toml:"max_header_bytes"`
+	// DrainDelay is how long the server keeps serving, while failing its
+	// readiness check, after a shutdown signal, giving load balancers time
+	// to stop routing to it
+	DrainDelay time.Duration `toml:"drain_delay"`
+	// ShutdownTimeout bounds how long in-flight requests may take to drain
+	ShutdownTimeout time.Duration `toml:"shutdown_timeout"`
+	// PublicURL is the address clients reach the server at, used in links
+	// the server hands out and to name it in calendar event UIDs. It must
+	// be set to serve the API.
+	PublicURL string `toml:"public_url"`
+	// TrustedProxies are the addresses or CIDR ranges of reverse proxies
+	// whose X-Forwarded-For header is believed. Requests from anywhere
+	// else are taken to come from their peer address.
+	TrustedProxies []string `toml:"trusted_proxies"`
+}
+
+type DatabaseConfig struct {
+	URL             string        `toml:"url"`
+	MaxOpenConns    int           `toml:"max_open_conns"`
+	MaxIdleConns    int           `toml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `toml:"conn_max_lifetime"`
+	// SlowQueryThreshold logs queries that take longer; zero disables it
+	SlowQueryThreshold time.Duration `toml:"slow_query_threshold"`
+}
+
+type AuthConfig struct {
+	TokenSecret string        `toml:"token_secret"`
+	TokenTTL    time.Duration `toml:"token_ttl"`
+	// Login attempts allowed per client IP and per account in each window
+	LoginIPLimit      int           `toml:"login_ip_limit"`
+	LoginAccountLimit int           `toml:"login_account_limit"`
+	LoginWindow       time.Duration `toml:"login_window"`
+	// After LockoutThreshold consecutive failures an account is locked for
+	// LockoutBase, doubling with each further failure up to LockoutMax
+	LockoutThreshold int           `toml:"lockout_threshold"`
+	LockoutBase      time.Duration `toml:"lockout_base"`
+	LockoutMax       time.Duration `toml:"lockout_max"`
+	// TOTPIssuer names the service in authenticator apps
+	TOTPIssuer string `toml:"totp_issuer"`
+	// TOTPRequiredRoles must enroll a second factor before using the API
+	TOTPRequiredRoles []string `toml:"totp_required_roles"`
+	// APIKeyMaxTTL is the longest an API key can be valid for, and how
+	// long keys created without an expiry last
+	APIKeyMaxTTL time.Duration `toml:"api_key_max_ttl"`
+}
+
+type CORSConfig struct {
+	AllowedOrigins []string `toml:"allowed_origins"`
+}
+
+// BookingConfig holds the rules every booking must satisfy
+type BookingConfig struct {
+	MinDuration time.Duration `toml:"min_duration"`
+	MaxDuration time.Duration `toml:"max_duration"`
+	MaxAdvance  time.Duration `toml:"max_advance"`
+	OpenTime    string        `toml:"open_time"`
+	CloseTime   string        `toml:"close_time"`
+	TimeZone    string        `toml:"time_zone"`
+	// Buffer is the gap kept clear between bookings of the same space
+	Buffer time.Duration `toml:"buffer"`
+	// SlotStep is the granularity of start times offered by the slot finder
+	SlotStep time.Duration `toml:"slot_step"`
+	// ReleaseInterval is how often bookings nobody checked in to are
+	// looked for and released
+	ReleaseInterval time.Duration `toml:"release_interval"`
+	// ReminderLead is how long before a booking starts a reminder is sent;
+	// zero turns reminders off
+	ReminderLead time.Duration `toml:"reminder_lead"`
+}
+
+// MailConfig controls email notifications. Mail goes through the SMTP
+// server at Host, or, when Maildir is set, is written there instead for
+// development and testing. With neither set no mail is sent.
+type MailConfig struct {
+	Host     string `toml:"host"`
+	Port     int    `toml:"port"`
+	Username string `toml:"username"`
+	Password string `toml:"password"`
+	From     string `toml:"from"`
+	Maildir  string `toml:"maildir"`
+	// TemplateDir holds edited copies of the email templates, which take
+	// the place of the built-in ones
+	TemplateDir string `toml:"template_dir"`
+	// DefaultLocale is the language of emails to people who haven't chosen one
+	DefaultLocale string `toml:"default_locale"`
+}
+
+// Enabled reports whether mail is sent, or written to a Maildir
+func (m MailConfig) Enabled() bool {
+	return m.Host != "" || m.Maildir != ""
+}
+
+// OIDCConfig enables single sign-on through an OpenID Connect provider.
+// It is off unless IssuerURL is set.
+type OIDCConfig struct {
+	IssuerURL    string   `toml:"issuer_url"`
+	ClientID     string   `toml:"client_id"`
+	ClientSecret string   `toml:"client_secret"`
+	RedirectURL  string   `toml:"redirect_url"`
+	Scopes       []string `toml:"scopes"`
+	// JITProvisioning creates a teacher account on first sign-on for
+	// verified emails that don't have one yet
+	JITProvisioning bool `toml:"jit_provisioning"`
+	// PostLoginRedirect, if set, is where browsers are sent after sign-on,
+	// with the login token in the URL fragment. Otherwise the callback
+	// responds with JSON like the password login.
+	PostLoginRedirect string `toml:"post_login_redirect"`
+}
+
+// Enabled reports whether single sign-on is configured
+func (o OIDCConfig) Enabled() bool {
+	return o.IssuerURL != ""
+}
+
+// WebhookConfig controls delivery of outgoing webhooks. A delivery that
+// fails is retried after RetryBase, doubling each time up to RetryMax, and
+// is dead-lettered after MaxAttempts.
+type WebhookConfig struct {
+	MaxAttempts  int           `toml:"max_attempts"`
+	RetryBase    time.Duration `toml:"retry_base"`
+	RetryMax     time.Duration `toml:"retry_max"`
+	Timeout      time.Duration `toml:"timeout"`
+	PollInterval time.Duration `toml:"poll_interval"`
+}
+
+// JobConfig controls the background job runner. A job that fails is
+// retried after RetryBase, doubling each time up to RetryMax, and a
+// one-off job is given up on after MaxAttempts.
+type JobConfig struct {
+	MaxAttempts  int           `toml:"max_attempts"`
+	RetryBase    time.Duration `toml:"retry_base"`
+	RetryMax     time.Duration `toml:"retry_max"`
+	Timeout      time.Duration `toml:"timeout"`
+	PollInterval time.Duration `toml:"poll_interval"`
+}
+
+// RetentionConfig sets how long records that only matter for a while are
+// kept. Zero keeps them forever. The audit log is never purged.
+type RetentionConfig struct {
+	// Events are kept for live clients that reconnect to catch up on
+	Events time.Duration `toml:"events"`
+	// WebhookDeliveries that succeeded or were dead-lettered
+	WebhookDeliveries time.Duration `toml:"webhook_deliveries"`
+	// Jobs that finished or were given up on
+	Jobs time.Duration `toml:"jobs"`
+}
+
+type LogConfig struct {
+	// Level is one of debug, info, warn or error
+	Level string `toml:"level"`
+}
+
+// Default returns the configuration used when nothing else is set
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Addr:              ":8080",
+			ReadTimeout:       15 * time.Second,
+			ReadHeaderTimeout: 5 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+			MaxHeaderBytes:    64 << 10,
+			DrainDelay:        5 * time.Second,
+			ShutdownTimeout:   25 * time.Second,
+		},
+		Database: DatabaseConfig{
+			MaxOpenConns:       20,
+			MaxIdleConns:       5,
+			ConnMaxLifetime:    30 * time.Minute,
+			SlowQueryThreshold: 200 * time.Millisecond,
+		},
+		Auth: AuthConfig{
+			TokenTTL:          12 * time.Hour,
+			LoginIPLimit:      20,
+			LoginAccountLimit: 5,
+			LoginWindow:       time.Minute,
+			LockoutThreshold:  5,
+			LockoutBase:       time.Minute,
+			LockoutMax:        24 * time.Hour,
+			TOTPIssuer:        "Skedda",
+			TOTPRequiredRoles: []string{"admin"},
+			APIKeyMaxTTL:      90 * 24 * time.Hour,
+		},
+		Booking: BookingConfig{
+			MinDuration:     15 * time.Minute,
+			MaxDuration:     8 * time.Hour,
+			MaxAdvance:      90 * 24 * time.Hour,
+			OpenTime:        "07:00",
+			CloseTime:       "22:00",
+			TimeZone:        "Local",
+			SlotStep:        15 * time.Minute,
+			ReleaseInterval: time.Minute,
+			ReminderLead:    time.Hour,
+		},
+		Mail: MailConfig{
+			Port:          587,
+			DefaultLocale: "en",
+		},
+		Log: LogConfig{
+			Level: "info",
+		},
+		OIDC: OIDCConfig{
+			Scopes: []string{"openid", "email", "profile"},
+		},
+		Webhooks: WebhookConfig{
+			MaxAttempts:  10,
+			RetryBase:    30 * time.Second,
+			RetryMax:     6 * time.Hour,
+			Timeout:      10 * time.Second,
+			PollInterval: 5 * time.Second,
+		},
+		Jobs: JobConfig{
+			MaxAttempts:  5,
+			RetryBase:    30 * time.Second,
+			RetryMax:     time.Hour,
+			Timeout:      time.Minute,
+			PollInterval: 5 * time.Second,
+		},
+		Retention: RetentionConfig{
+			Events:            7 * 24 * time.Hour,
+			WebhookDeliveries: 30 * 24 * time.Hour,
+			Jobs:              7 * 24 * time.Hour,
+		},
+	}
+}
+
+// Load builds the effective configuration from the defaults, the TOML file
+// at path (skipped when path is empty) and the environment
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	if path != "" {
+		md, err := toml.DecodeFile(path, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("reading config %s: %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return nil, fmt.Errorf("reading config %s: unknown key %q", path, undecoded[0].String())
+		}
+	}
+	if err := applyEnv(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Validate reports every problem with the configuration at once, so a bad
+// deploy can be fixed in one pass
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Server.Addr != "", "server.addr must be set")
+	if c.Server.PublicURL != "" {
+		u, err := url.Parse(c.Server.PublicURL)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "server.public_url %q must be an absolute http or https URL", c.Server.PublicURL)
+	}
+	_, err := c.Server.TrustedProxyPrefixes()
+	check(err == nil, "server.trusted_proxies: %v", err)
+	check((c.Server.TLSCertFile == "") == (c.Server.TLSKeyFile == ""), "server.tls_cert_file and server.tls_key_file must be set together")
+	for _, file := range []string{c.Server.TLSCertFile, c.Server.TLSKeyFile} {
+		if file != "" {
+			_, err := os.Stat(file)
+			check(err == nil, "TLS file %s: %v", file, err)
+		}
+	}
+	check(c.Server.ReadTimeout >= 0 && c.Server.ReadHeaderTimeout >= 0 && c.Server.WriteTimeout >= 0 && c.Server.IdleTimeout >= 0,
+		"server timeouts must not be negative")
+	check(c.Server.MaxHeaderBytes > 0, "server.max_header_bytes must be positive")
+	check(c.Server.DrainDelay >= 0, "server.drain_delay must not be negative")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout must be positive")
+
+	check(c.Database.URL != "", "database.url (DATABASE_URL) must be set")
+	check(c.Database.MaxOpenConns >= 0, "database.max_open_conns must not be negative")
+	check(c.Database.MaxIdleConns >= 0, "database.max_idle_conns must not be negative")
+	check(c.Database.SlowQueryThreshold >= 0, "database.slow_query_threshold must not be negative")
+	check(c.Database.MaxOpenConns == 0 || c.Database.MaxIdleConns <= c.Database.MaxOpenConns,
+		"database.max_idle_conns must not exceed database.max_open_conns")
+
+	check(c.Auth.TokenSecret == "" || len(c.Auth.TokenSecret) >= 32, "auth.token_secret must be at least 32 characters")
+	check(c.Auth.TokenTTL > 0, "auth.token_ttl must be positive")
+	check(c.Auth.LoginIPLimit > 0 && c.Auth.LoginAccountLimit > 0, "auth login limits must be positive")
+	check(c.Auth.LoginWindow > 0, "auth.login_window must be positive")
+	check(c.Auth.LockoutThreshold > 0, "auth.lockout_threshold must be positive")
+	check(c.Auth.LockoutBase > 0 && c.Auth.LockoutMax >= c.Auth.LockoutBase, "auth.lockout_max must not be shorter than auth.lockout_base")
+	check(c.Auth.TOTPIssuer != "", "auth.totp_issuer must be set")
+	check(c.Auth.APIKeyMaxTTL > 0, "auth.api_key_max_ttl must be positive")
+	for _, role := range c.Auth.TOTPRequiredRoles {
+		check(role == "teacher" || role == "admin", "auth.totp_required_roles: unknown role %q", role)
+	}
+
+	for _, origin := range c.CORS.AllowedOrigins {
+		u, err := url.Parse(origin)
+		check(origin == "*" || (err == nil && u.Scheme != "" && u.Host != ""), "cors.allowed_origins: %q is not an origin", origin)
+	}
+
+	check(c.Booking.MinDuration > 0, "booking.min_duration must be positive")
+	check(c.Booking.MaxDuration >= c.Booking.MinDuration, "booking.max_duration must not be shorter than booking.min_duration")
+	check(c.Booking.MaxAdvance > 0, "booking.max_advance must be positive")
+	check(c.Booking.Buffer >= 0, "booking.buffer must not be negative")
+	check(c.Booking.SlotStep > 0, "booking.slot_step must be positive")
+	check(c.Booking.ReleaseInterval > 0, "booking.release_interval must be positive")
+	check(c.Booking.ReminderLead >= 0, "booking.reminder_lead must not be negative")
+	open, openErr := time.Parse("15:04", c.Booking.OpenTime)
+	check(openErr == nil, "booking.open_time %q must be HH:MM", c.Booking.OpenTime)
+	closing, closeErr := time.Parse("15:04", c.Booking.CloseTime)
+	check(closeErr == nil, "booking.close_time %q must be HH:MM", c.Booking.CloseTime)
+	check(openErr != nil || closeErr != nil || open.Before(closing), "booking.open_time must be before booking.close_time")
+	_, err = time.LoadLocation(c.Booking.TimeZone)
+	check(err == nil, "booking.time_zone: %v", err)
+
+	if c.Mail.Host != "" {
+		check(c.Mail.Port > 0 && c.Mail.Port < 65536, "mail.port %d is out of range", c.Mail.Port)
+	}
+	if c.Mail.Enabled() {
+		_, err := mail.ParseAddress(c.Mail.From)
+		check(err == nil, "mail.from %q must be an email address when mail.host or mail.maildir is set", c.Mail.From)
+	}
+	check(c.Mail.DefaultLocale != "", "mail.default_locale must be set")
+	if c.Mail.TemplateDir != "" {
+		info, err := os.Stat(c.Mail.TemplateDir)
+		check(err == nil && info.IsDir(), "mail.template_dir %s must be a directory", c.Mail.TemplateDir)
+	}
+
+	check(c.Webhooks.MaxAttempts > 0, "webhooks.max_attempts must be positive")
+	check(c.Webhooks.RetryBase > 0 && c.Webhooks.RetryMax >= c.Webhooks.RetryBase, "webhooks.retry_max must not be shorter than webhooks.retry_base")
+	check(c.Webhooks.Timeout > 0, "webhooks.timeout must be positive")
+	check(c.Webhooks.PollInterval > 0, "webhooks.poll_interval must be positive")
+
+	check(c.Jobs.MaxAttempts > 0, "jobs.max_attempts must be positive")
+	check(c.Jobs.RetryBase > 0 && c.Jobs.RetryMax >= c.Jobs.RetryBase, "jobs.retry_max must not be shorter than jobs.retry_base")
+	check(c.Jobs.Timeout > 0, "jobs.timeout must be positive")
+	check(c.Jobs.PollInterval > 0, "jobs.poll_interval must be positive")
+	check(c.Retention.Events >= 0 && c.Retention.WebhookDeliveries >= 0 && c.Retention.Jobs >= 0,
+		"retention periods must not be negative")
+
+	if c.OIDC.Enabled() {
+		for name, value := range map[string]string{"oidc.issuer_url": c.OIDC.IssuerURL, "oidc.redirect_url": c.OIDC.RedirectURL} {
+			u, err := url.Parse(value)
+			check(err == nil && u.IsAbs(), "%s %q must be an absolute URL", name, value)
+		}
+		check(c.OIDC.ClientID != "", "oidc.client_id must be set when oidc.issuer_url is")
+		check(slices.Contains(c.OIDC.Scopes, "openid"), "oidc.scopes must include openid")
+	}
+
+	_, err = c.Log.SlogLevel()
+	check(err == nil, "log.level: %v", err)
+
+	return errors.Join(errs...)
+}
+
+// TrustedProxyPrefixes parses TrustedProxies, reading a plain address as
+// a range holding just that address
+func (s ServerConfig) TrustedProxyPrefixes() ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(s.TrustedProxies))
+	for _, proxy := range s.TrustedProxies {
+		if addr, err := netip.ParseAddr(proxy); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an address or CIDR range", proxy)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// SlogLevel parses the configured log level
+func (l LogConfig) SlogLevel() (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(l.Level))
+	return level, err
+}
+
+// LockoutDuration returns how long an account with the given number of
+// consecutive failed logins stays locked
+func (a AuthConfig) LockoutDuration(failures int) time.Duration {
+	if failures < a.LockoutThreshold {
+		return 0
+	}
+	d := a.LockoutBase
+	for i := a.LockoutThreshold; i < failures && d < a.LockoutMax; i++ {
+		d *= 2
+	}
+	return min(d, a.LockoutMax)
+}
+
+// RequiresTOTP reports whether accounts with role must use a second factor
+func (a AuthConfig) RequiresTOTP(role string) bool {
+	return slices.Contains(a.TOTPRequiredRoles, role)
+}
+
+// Location returns the time zone booking rules are evaluated in
+func (b BookingConfig) Location() *time.Location {
+	loc, err := time.LoadLocation(b.TimeZone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+const redacted = "REDACTED"
+
+var dsnPassword = regexp.MustCompile(`password=\S+`)
+
+// Redacted returns a copy of the configuration that is safe to print
+func (c *Config) Redacted() *Config {
+	out := *c
+	out.Server.TrustedProxies = append([]string(nil), c.Server.TrustedProxies...)
+	out.CORS.AllowedOrigins = append([]string(nil), c.CORS.AllowedOrigins...)
+	out.Auth.TOTPRequiredRoles = append([]string(nil), c.Auth.TOTPRequiredRoles...)
+	if out.Auth.TokenSecret != "" {
+		out.Auth.TokenSecret = redacted
+	}
+	if out.Mail.Password != "" {
+		out.Mail.Password = redacted
+	}
+	if out.OIDC.ClientSecret != "" {
+		out.OIDC.ClientSecret = redacted
+	}
+	out.OIDC.Scopes = append([]string(nil), c.OIDC.Scopes...)
+	if u, err := url.Parse(out.Database.URL); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), redacted)
+			out.Database.URL = u.String()
+		}
+	} else {
+		out.Database.URL = dsnPassword.ReplaceAllString(out.Database.URL, "password="+redacted)
+	}
+	return &out
+}
@@ -0,0 +1,49 @@
+// internal/config/config_test.go
+package config
+
+import (
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// valid returns the default configuration with the settings that have no
+// default filled in
+func valid() *Config {
+	c := Default()
+	c.Database.URL = "postgres://localhost/skedda"
+	return c
+}
+
+func TestDefaultIsValid(t *testing.T) {
+	if err := valid().Validate(); err != nil {
+		t.Fatalf("Validate() = %v", err)
+	}
+}
+
+func TestTrustedProxyPrefixes(t *testing.T) {
+	s := ServerConfig{TrustedProxies: []string{"10.0.0.0/8", "192.168.1.7", "::1", "172.16.5.9/12"}}
+	got, err := s.TrustedProxyPrefixes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.168.1.7/32"),
+		netip.MustParsePrefix("::1/128"),
+		netip.MustParsePrefix("172.16.0.0/12"),
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("TrustedProxyPrefixes() = %v, want %v", got, want)
+	}
+}
+
+func TestValidateRejectsBadTrustedProxy(t *testing.T) {
+	c := valid()
+	c.Server.TrustedProxies = []string{"proxy.internal"}
+	err := c.Validate()
+	if err == nil || !strings.Contains(err.Error(), "server.trusted_proxies") {
+		t.Errorf("Validate() = %v, want a server.trusted_proxies error", err)
+	}
+}
@@ -0,0 +1,213 @@
+// internal/config/overrides.go
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// override is a setting that can be changed from the environment or a flag.
+// An empty env or flag name means that source is not offered.
+type override struct {
+	env   string
+	flag  string
+	usage string
+	set   func(c *Config, value string) error
+}
+
+var overrides = []override{
+	{"PORT", "port", "port to listen on (shorthand for --addr :PORT)", func(c *Config, v string) error {
+		c.Server.Addr = ":" + v
+		return nil
+	}},
+	{"SKEDDA_ADDR", "addr", "address to listen on", func(c *Config, v string) error {
+		c.Server.Addr = v
+		return nil
+	}},
+	{"SKEDDA_PUBLIC_URL", "public-url", "address clients reach the server at, for links", func(c *Config, v string) error {
+		c.Server.PublicURL = v
+		return nil
+	}},
+	{"SKEDDA_TRUSTED_PROXIES", "trusted-proxies", "comma separated addresses or CIDR ranges of proxies whose X-Forwarded-For is believed", func(c *Config, v string) error {
+		c.Server.TrustedProxies = splitList(v)
+		return nil
+	}},
+	{"SKEDDA_TLS_CERT_FILE", "tls-cert", "TLS certificate file", func(c *Config, v string) error {
+		c.Server.TLSCertFile = v
+		return nil
+	}},
+	{"SKEDDA_TLS_KEY_FILE", "tls-key", "TLS private key file", func(c *Config, v string) error {
+		c.Server.TLSKeyFile = v
+		return nil
+	}},
+	{"SKEDDA_SHUTDOWN_TIMEOUT", "shutdown-timeout", "how long to wait for in-flight requests on shutdown", func(c *Config, v string) error {
+		return setDuration(&c.Server.ShutdownTimeout, v)
+	}},
+	{"SKEDDA_DRAIN_DELAY", "drain-delay", "how long to fail readiness before shutting down", func(c *Config, v string) error {
+		return setDuration(&c.Server.DrainDelay, v)
+	}},
+	{"DATABASE_URL", "database-url", "PostgreSQL connection string", func(c *Config, v string) error {
+		c.Database.URL = v
+		return nil
+	}},
+	{"SKEDDA_DB_MAX_OPEN_CONNS", "db-max-open-conns", "maximum open database connections", func(c *Config, v string) error {
+		return setInt(&c.Database.MaxOpenConns, v)
+	}},
+	{"SKEDDA_DB_MAX_IDLE_CONNS", "db-max-idle-conns", "maximum idle database connections", func(c *Config, v string) error {
+		return setInt(&c.Database.MaxIdleConns, v)
+	}},
+	{"SKEDDA_DB_SLOW_QUERY_THRESHOLD", "db-slow-query-threshold", "log queries slower than this (0 disables)", func(c *Config, v string) error {
+		return setDuration(&c.Database.SlowQueryThreshold, v)
+	}},
+	// Secrets are deliberately not offered as flags, where they would show up in ps
+	{"SKEDDA_TOKEN_SECRET", "", "", func(c *Config, v string) error {
+		c.Auth.TokenSecret = v
+		return nil
+	}},
+	{"SKEDDA_TOKEN_TTL", "token-ttl", "lifetime of login tokens", func(c *Config, v string) error {
+		return setDuration(&c.Auth.TokenTTL, v)
+	}},
+	{"SKEDDA_TOTP_REQUIRED_ROLES", "totp-required-roles", "comma separated roles that must use two-factor login", func(c *Config, v string) error {
+		c.Auth.TOTPRequiredRoles = splitList(v)
+		return nil
+	}},
+	{"SKEDDA_API_KEY_MAX_TTL", "api-key-max-ttl", "longest lifetime of an API key", func(c *Config, v string) error {
+		return setDuration(&c.Auth.APIKeyMaxTTL, v)
+	}},
+	{"SKEDDA_CORS_ORIGINS", "cors-origins", "comma separated origins allowed by CORS", func(c *Config, v string) error {
+		c.CORS.AllowedOrigins = splitList(v)
+		return nil
+	}},
+	{"SKEDDA_MAIL_HOST", "mail-host", "SMTP host", func(c *Config, v string) error {
+		c.Mail.Host = v
+		return nil
+	}},
+	{"SKEDDA_MAIL_PORT", "mail-port", "SMTP port", func(c *Config, v string) error {
+		return setInt(&c.Mail.Port, v)
+	}},
+	{"SKEDDA_MAIL_USERNAME", "mail-username", "SMTP username", func(c *Config, v string) error {
+		c.Mail.Username = v
+		return nil
+	}},
+	{"SKEDDA_MAIL_PASSWORD", "", "", func(c *Config, v string) error {
+		c.Mail.Password = v
+		return nil
+	}},
+	{"SKEDDA_MAIL_FROM", "mail-from", "sender address for outgoing mail", func(c *Config, v string) error {
+		c.Mail.From = v
+		return nil
+	}},
+	{"SKEDDA_MAILDIR", "maildir", "write mail to this Maildir instead of sending it", func(c *Config, v string) error {
+		c.Mail.Maildir = v
+		return nil
+	}},
+	{"SKEDDA_MAIL_TEMPLATE_DIR", "mail-template-dir", "directory of edited email templates", func(c *Config, v string) error {
+		c.Mail.TemplateDir = v
+		return nil
+	}},
+	{"SKEDDA_OIDC_ISSUER_URL", "oidc-issuer-url", "OpenID Connect issuer URL, enabling single sign-on", func(c *Config, v string) error {
+		c.OIDC.IssuerURL = v
+		return nil
+	}},
+	{"SKEDDA_OIDC_CLIENT_ID", "oidc-client-id", "OpenID Connect client ID", func(c *Config, v string) error {
+		c.OIDC.ClientID = v
+		return nil
+	}},
+	{"SKEDDA_OIDC_CLIENT_SECRET", "", "", func(c *Config, v string) error {
+		c.OIDC.ClientSecret = v
+		return nil
+	}},
+	{"SKEDDA_OIDC_REDIRECT_URL", "oidc-redirect-url", "URL of /api/auth/oidc/callback as the provider should call it", func(c *Config, v string) error {
+		c.OIDC.RedirectURL = v
+		return nil
+	}},
+	{"SKEDDA_OIDC_JIT_PROVISIONING", "oidc-jit-provisioning", "create accounts on first single sign-on (true or false)", func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		c.OIDC.JITProvisioning = b
+		return err
+	}},
+	{"SKEDDA_LOG_LEVEL", "log-level", "minimum log level: debug, info, warn or error", func(c *Config, v string) error {
+		c.Log.Level = v
+		return nil
+	}},
+}
+
+func applyEnv(c *Config) error {
+	for _, o := range overrides {
+		if o.env == "" {
+			continue
+		}
+		if v, ok := os.LookupEnv(o.env); ok {
+			if err := o.set(c, v); err != nil {
+				return fmt.Errorf("%s: %w", o.env, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Flags holds the command-line overrides registered on a flag set
+type Flags struct {
+	fs     *flag.FlagSet
+	values map[string]*string
+}
+
+// RegisterFlags adds a flag for every overridable setting to fs
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{fs: fs, values: make(map[string]*string)}
+	for _, o := range overrides {
+		if o.flag != "" {
+			f.values[o.flag] = fs.String(o.flag, "", o.usage)
+		}
+	}
+	return f
+}
+
+// Apply copies the flags that were given on the command line into c.
+// It must be called after the flag set has been parsed.
+func (f *Flags) Apply(c *Config) error {
+	set := make(map[string]bool)
+	f.fs.Visit(func(fl *flag.Flag) { set[fl.Name] = true })
+
+	for _, o := range overrides {
+		if o.flag == "" || !set[o.flag] {
+			continue
+		}
+		if err := o.set(c, *f.values[o.flag]); err != nil {
+			return fmt.Errorf("--%s: %w", o.flag, err)
+		}
+	}
+	return nil
+}
+
+func setInt(dst *int, v string) error {
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return err
+	}
+	*dst = n
+	return nil
+}
+
+func setDuration(dst *time.Duration, v string) error {
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return err
+	}
+	*dst = d
+	return nil
+}
+
+func splitList(v string) []string {
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
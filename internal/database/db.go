@@ -1,39 +1,139 @@
-// internal/database/db.go
-package database
-
-import (
-	"fmt"
-	"log"
-	"os"
-	"skedda-goclone/internal/models"
-
-	"gorm.io/driver/postgres"
-	"gorm.io/gorm"
-)
-
-type Database struct {
-	*gorm.DB
-}
-
-// NewDatabase initializes a PostgreSQL connection using GORM
-func NewDatabase() (*Database, error) {
-	dsn := os.Getenv("DATABASE_URL")
-	if dsn == "" {
-		return nil, fmt.Errorf("DATABASE_URL environment variable not set")
-	}
-
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
-	if err != nil {
-		return nil, err
-	}
-
-	return &Database{db}, nil
-}
-
-// Migrate applies schema migrations for all models
-func (db *Database) Migrate() {
-	// Register all models for migration here
-	if err := db.AutoMigrate(&models.Teacher{}, &models.Student{}, &models.Booking{}, &models.Subject{}); err != nil {
-		log.Fatalf("Migration failed: %v", err)
-	}
-}
+// internal/database/db.go
+package database
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"skedda-goclone/internal/config"
+	"skedda-goclone/internal/logging"
+	"skedda-goclone/internal/models"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Database struct {
+	*gorm.DB
+}
+
+// NewDatabase initializes a PostgreSQL connection using GORM
+func NewDatabase(cfg config.DatabaseConfig) (*Database, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("database URL not set")
+	}
+
+	db, err := gorm.Open(postgres.Open(cfg.URL), &gorm.Config{
+		Logger: logging.NewGormLogger(slog.Default(), cfg.SlowQueryThreshold),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Size the connection pool
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+
+	return &Database{db}, nil
+}
+
+// Close closes the underlying connection pool
+func (db *Database) Close() error {
+	sqlDB, err := db.DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+// SchemaVersion is the version Migrate brings the schema to. Bump it
+// whenever a model is added or changed, so that readiness checks can tell
+// when a server is running against a database that hasn't been migrated.
+const SchemaVersion = 17
+
+// schemaMigration records each schema version that has been applied
+type schemaMigration struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	AppliedAt time.Time
+}
+
+// Migrate applies schema migrations for all models
+func (db *Database) Migrate() error {
+	applied, err := db.AppliedSchemaVersion(context.Background())
+	if err != nil {
+		return fmt.Errorf("reading schema version: %w", err)
+	}
+
+	// Register all models for migration here
+	err = db.AutoMigrate(&schemaMigration{}, &models.Teacher{}, &models.Student{}, &models.Booking{}, &models.Subject{}, &models.StudentSubject{}, &models.AuditEntry{}, &models.RecoveryCode{}, &models.APIKey{}, &models.WebhookSubscription{}, &models.WebhookDelivery{}, &models.Space{}, &models.Event{}, &models.CalendarFeed{}, &models.AvailabilityWindow{}, &models.Attendee{}, &models.Job{}, &models.NotificationPreference{}, &models.DigestEntry{})
+	if err != nil {
+		return err
+	}
+	if err := db.Exec(appendOnlyAudit).Error; err != nil {
+		return fmt.Errorf("protecting audit entries: %w", err)
+	}
+	if err := db.migrateBookingStudents(applied); err != nil {
+		return fmt.Errorf("moving booking students to attendees: %w", err)
+	}
+
+	return db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&schemaMigration{Version: SchemaVersion, AppliedAt: time.Now()}).Error
+}
+
+// appendOnlyAudit makes the database refuse to change or remove audit
+// entries, whatever the application asks of it
+const appendOnlyAudit = `
+CREATE OR REPLACE FUNCTION audit_entries_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit entries are append-only';
+END
+$$ LANGUAGE plpgsql;
+DROP TRIGGER IF EXISTS audit_entries_append_only ON audit_entries;
+CREATE TRIGGER audit_entries_append_only BEFORE UPDATE OR DELETE ON audit_entries
+	FOR EACH ROW EXECUTE FUNCTION audit_entries_append_only();
+`
+
+// attendeesVersion is the schema version that moved lesson students from
+// booking_students to attendees
+const attendeesVersion = 11
+
+// migrateBookingStudents turns the students linked to lessons before
+// attendeesVersion into attendees, once, when migrating from an older
+// schema. The old join table is left in place, unused, so a server that
+// still reads it can run against the database until everything is
+// upgraded; a later release drops it.
+func (db *Database) migrateBookingStudents(applied int) error {
+	if applied >= attendeesVersion || !db.Migrator().HasTable("booking_students") {
+		return nil
+	}
+	return db.Exec(`INSERT INTO attendees (created_at, booking_id, kind, student_id, rsvp)
+		SELECT NOW(), booking_id, ?, student_id, ? FROM booking_students
+		ON CONFLICT DO NOTHING`, models.AttendeeStudent, models.RSVPAccepted).Error
+}
+
+// AppliedSchemaVersion returns the newest schema version recorded by
+// Migrate, or 0 if the database has never been migrated
+func (db *Database) AppliedSchemaVersion(ctx context.Context) (int, error) {
+	if !db.Migrator().HasTable(&schemaMigration{}) {
+		return 0, nil
+	}
+	var version int
+	err := db.WithContext(ctx).Model(&schemaMigration{}).Select("COALESCE(MAX(version), 0)").Scan(&version).Error
+	return version, err
+}
+
+// Ping checks that the database is reachable
+func (db *Database) Ping(ctx context.Context) error {
+	sqlDB, err := db.DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
@@ -0,0 +1,67 @@
+// internal/database/db_test.go
+package database_test
+
+import (
+	"testing"
+	"time"
+
+	"skedda-goclone/internal/database"
+	"skedda-goclone/internal/dbtest"
+	"skedda-goclone/internal/models"
+)
+
+func TestMigrateBookingStudentsOnce(t *testing.T) {
+	db := dbtest.Open(t)
+	if err := db.Exec(`CREATE TABLE IF NOT EXISTS booking_students (
+		booking_id bigint NOT NULL, student_id bigint NOT NULL, PRIMARY KEY (booking_id, student_id))`).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Exec(`DROP TABLE IF EXISTS booking_students`) })
+
+	space := models.Space{Name: "Room A", Capacity: 4}
+	student := models.Student{Name: "Ben"}
+	if err := db.Create(&space).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&student).Error; err != nil {
+		t.Fatal(err)
+	}
+	booking := models.Booking{SpaceID: space.ID, StartTime: time.Now(), EndTime: time.Now().Add(time.Hour), Status: models.StatusConfirmed}
+	if err := db.Create(&booking).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec(`INSERT INTO booking_students (booking_id, student_id) VALUES (?, ?)`, booking.ID, student.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	countAttendees := func() int64 {
+		t.Helper()
+		var n int64
+		if err := db.Model(&models.Attendee{}).Where("booking_id = ? AND student_id = ?", booking.ID, student.ID).Count(&n).Error; err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	// Upgrading from before attendees copies the students over
+	if err := database.MigrateBookingStudents(db, database.AttendeesVersion-1); err != nil {
+		t.Fatal(err)
+	}
+	if n := countAttendees(); n != 1 {
+		t.Fatalf("%d attendees after migrating, want 1", n)
+	}
+	if !db.Migrator().HasTable("booking_students") {
+		t.Error("booking_students dropped while older servers may still read it")
+	}
+
+	// Later migrations leave attendees alone, so removing one sticks
+	if err := db.Where("booking_id = ?", booking.ID).Delete(&models.Attendee{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := database.MigrateBookingStudents(db, database.SchemaVersion); err != nil {
+		t.Fatal(err)
+	}
+	if n := countAttendees(); n != 0 {
+		t.Errorf("removed attendee restored by a later migration")
+	}
+}
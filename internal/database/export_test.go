@@ -0,0 +1,8 @@
+// internal/database/export_test.go
+package database
+
+// Exposed for tests in database_test, which can't be in this package as
+// dbtest imports it
+var MigrateBookingStudents = (*Database).migrateBookingStudents
+
+const AttendeesVersion = attendeesVersion
@@ -0,0 +1,274 @@
+// internal/database/transfer.go
+package database
+
+import (
+	"fmt"
+	"skedda-goclone/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TeacherRecord is a teacher as it appears in an export or fixtures file.
+// Exports carry the password hash and second factor so accounts survive a
+// round trip; fixtures may give a plaintext Password instead, which is
+// hashed on import.
+type TeacherRecord struct {
+	models.Teacher
+	Password     string     `json:"password,omitempty"`
+	PasswordHash string     `json:"password_hash,omitempty"`
+	FailedLogins int        `json:"failed_logins,omitempty"`
+	LockedUntil  *time.Time `json:"locked_until,omitempty"`
+	TOTPSecret   string     `json:"totp_secret,omitempty"`
+	TOTPLastStep int64      `json:"totp_last_step,omitempty"`
+}
+
+// The records below carry the hashes and secrets the API never shows, so
+// exports keep working keys, feed URLs, check-in codes and webhook
+// signatures. Export files must be kept as safe as the database.
+
+// SpaceRecord is a space in an export
+type SpaceRecord struct {
+	models.Space
+	CheckInTokenHash string `json:"check_in_token_hash,omitempty"`
+}
+
+// RecoveryCodeRecord is a recovery code in an export
+type RecoveryCodeRecord struct {
+	models.RecoveryCode
+	CodeHash string `json:"code_hash"`
+}
+
+// APIKeyRecord is an API key in an export
+type APIKeyRecord struct {
+	models.APIKey
+	KeyHash string `json:"key_hash"`
+}
+
+// CalendarFeedRecord is a calendar feed in an export
+type CalendarFeedRecord struct {
+	models.CalendarFeed
+	TokenHash string `json:"token_hash"`
+}
+
+// WebhookRecord is a webhook subscription in an export
+type WebhookRecord struct {
+	models.WebhookSubscription
+	Secret string `json:"secret"`
+}
+
+// Dump is the portable JSON form of the database used by the export,
+// import and seed commands. It holds every table but the schema version.
+type Dump struct {
+	Teachers        []TeacherRecord         `json:"teachers"`
+	Students        []models.Student        `json:"students"`
+	Subjects        []models.Subject        `json:"subjects"`
+	StudentSubjects []models.StudentSubject `json:"student_subjects"`
+	Spaces          []SpaceRecord           `json:"spaces,omitempty"`
+	Bookings        []models.Booking        `json:"bookings"`
+	Attendees       []models.Attendee       `json:"attendees"`
+	// Availability holds the weekly hours of teachers and students
+	Availability            []models.AvailabilityWindow     `json:"availability"`
+	RecoveryCodes           []RecoveryCodeRecord            `json:"recovery_codes,omitempty"`
+	APIKeys                 []APIKeyRecord                  `json:"api_keys,omitempty"`
+	CalendarFeeds           []CalendarFeedRecord            `json:"calendar_feeds,omitempty"`
+	Webhooks                []WebhookRecord                 `json:"webhooks,omitempty"`
+	WebhookDeliveries       []models.WebhookDelivery        `json:"webhook_deliveries,omitempty"`
+	Jobs                    []models.Job                    `json:"jobs,omitempty"`
+	NotificationPreferences []models.NotificationPreference `json:"notification_preferences,omitempty"`
+	DigestEntries           []models.DigestEntry            `json:"digest_entries,omitempty"`
+	Events                  []models.Event                  `json:"events,omitempty"`
+	AuditEntries            []models.AuditEntry             `json:"audit_entries,omitempty"`
+}
+
+// Export reads every record, including soft-deleted bookings, into a Dump
+func (db *Database) Export() (*Dump, error) {
+	var dump Dump
+	var (
+		teachers []models.Teacher
+		spaces   []models.Space
+		codes    []models.RecoveryCode
+		keys     []models.APIKey
+		feeds    []models.CalendarFeed
+		webhooks []models.WebhookSubscription
+	)
+	tables := []struct {
+		name string
+		db   *gorm.DB
+		dest any
+	}{
+		{"teachers", db.DB, &teachers},
+		{"students", db.DB, &dump.Students},
+		{"subjects", db.DB, &dump.Subjects},
+		{"student subjects", db.Order("student_id, subject_id"), &dump.StudentSubjects},
+		{"spaces", db.DB, &spaces},
+		{"bookings", db.Unscoped(), &dump.Bookings},
+		{"attendees", db.DB, &dump.Attendees},
+		{"availability", db.DB, &dump.Availability},
+		{"recovery codes", db.DB, &codes},
+		{"API keys", db.DB, &keys},
+		{"calendar feeds", db.DB, &feeds},
+		{"webhooks", db.DB, &webhooks},
+		{"webhook deliveries", db.DB, &dump.WebhookDeliveries},
+		{"jobs", db.DB, &dump.Jobs},
+		{"notification preferences", db.DB, &dump.NotificationPreferences},
+		{"digest entries", db.DB, &dump.DigestEntries},
+		{"events", db.DB, &dump.Events},
+		{"audit entries", db.DB, &dump.AuditEntries},
+	}
+	for _, t := range tables {
+		query := t.db
+		if t.name != "student subjects" {
+			query = query.Order("id")
+		}
+		if err := query.Find(t.dest).Error; err != nil {
+			return nil, fmt.Errorf("exporting %s: %w", t.name, err)
+		}
+	}
+
+	for _, t := range teachers {
+		dump.Teachers = append(dump.Teachers, TeacherRecord{
+			Teacher: t, PasswordHash: t.PasswordHash, FailedLogins: t.FailedLogins, LockedUntil: t.LockedUntil,
+			TOTPSecret: t.TOTPSecret, TOTPLastStep: t.TOTPLastStep,
+		})
+	}
+	for _, s := range spaces {
+		dump.Spaces = append(dump.Spaces, SpaceRecord{Space: s, CheckInTokenHash: s.CheckInTokenHash})
+	}
+	for _, c := range codes {
+		dump.RecoveryCodes = append(dump.RecoveryCodes, RecoveryCodeRecord{RecoveryCode: c, CodeHash: c.CodeHash})
+	}
+	for _, k := range keys {
+		dump.APIKeys = append(dump.APIKeys, APIKeyRecord{APIKey: k, KeyHash: k.KeyHash})
+	}
+	for _, f := range feeds {
+		dump.CalendarFeeds = append(dump.CalendarFeeds, CalendarFeedRecord{CalendarFeed: f, TokenHash: f.TokenHash})
+	}
+	for _, w := range webhooks {
+		dump.Webhooks = append(dump.Webhooks, WebhookRecord{WebhookSubscription: w, Secret: w.Secret})
+	}
+	return &dump, nil
+}
+
+// Import writes every record in the dump in a single transaction. Records
+// whose primary key already exists are overwritten, so importing the same
+// file twice is harmless. Audit entries can't be overwritten, and jobs
+// clash with the recurring ones a running server queues, so those already
+// there are kept.
+func (db *Database) Import(dump *Dump) error {
+	teachers := make([]models.Teacher, 0, len(dump.Teachers))
+	for _, record := range dump.Teachers {
+		teacher := record.Teacher
+		teacher.PasswordHash = record.PasswordHash
+		teacher.FailedLogins = record.FailedLogins
+		teacher.LockedUntil = record.LockedUntil
+		teacher.TOTPSecret = record.TOTPSecret
+		teacher.TOTPLastStep = record.TOTPLastStep
+		if record.Password != "" {
+			if err := teacher.SetPassword(record.Password); err != nil {
+				return fmt.Errorf("hashing password for %s: %w", teacher.Email, err)
+			}
+		}
+		if teacher.Role == "" {
+			teacher.Role = models.RoleTeacher
+		}
+		// A second factor can't be enabled without a secret to check
+		// codes against
+		if teacher.TOTPSecret == "" {
+			teacher.TOTPEnabled = false
+		}
+		teachers = append(teachers, teacher)
+	}
+	spaces := convert(dump.Spaces, func(r SpaceRecord) models.Space {
+		r.Space.CheckInTokenHash = r.CheckInTokenHash
+		return r.Space
+	})
+	codes := convert(dump.RecoveryCodes, func(r RecoveryCodeRecord) models.RecoveryCode {
+		r.RecoveryCode.CodeHash = r.CodeHash
+		return r.RecoveryCode
+	})
+	keys := convert(dump.APIKeys, func(r APIKeyRecord) models.APIKey {
+		r.APIKey.KeyHash = r.KeyHash
+		return r.APIKey
+	})
+	feeds := convert(dump.CalendarFeeds, func(r CalendarFeedRecord) models.CalendarFeed {
+		r.CalendarFeed.TokenHash = r.TokenHash
+		return r.CalendarFeed
+	})
+	webhooks := convert(dump.Webhooks, func(r WebhookRecord) models.WebhookSubscription {
+		r.WebhookSubscription.Secret = r.Secret
+		return r.WebhookSubscription
+	})
+
+	overwrite := clause.OnConflict{UpdateAll: true}
+	keep := clause.OnConflict{DoNothing: true}
+	return db.Transaction(func(tx *gorm.DB) error {
+		tables := []struct {
+			name     string
+			records  any
+			count    int
+			conflict clause.OnConflict
+		}{
+			{"teachers", &teachers, len(teachers), overwrite},
+			{"students", &dump.Students, len(dump.Students), overwrite},
+			{"subjects", &dump.Subjects, len(dump.Subjects), overwrite},
+			{"student subjects", &dump.StudentSubjects, len(dump.StudentSubjects), keep},
+			{"spaces", &spaces, len(spaces), overwrite},
+			{"bookings", &dump.Bookings, len(dump.Bookings), overwrite},
+			{"attendees", &dump.Attendees, len(dump.Attendees), overwrite},
+			{"availability", &dump.Availability, len(dump.Availability), overwrite},
+			{"recovery codes", &codes, len(codes), overwrite},
+			{"API keys", &keys, len(keys), overwrite},
+			{"calendar feeds", &feeds, len(feeds), overwrite},
+			{"webhooks", &webhooks, len(webhooks), overwrite},
+			{"webhook deliveries", &dump.WebhookDeliveries, len(dump.WebhookDeliveries), overwrite},
+			{"jobs", &dump.Jobs, len(dump.Jobs), keep},
+			{"notification preferences", &dump.NotificationPreferences, len(dump.NotificationPreferences), overwrite},
+			{"digest entries", &dump.DigestEntries, len(dump.DigestEntries), overwrite},
+			{"events", &dump.Events, len(dump.Events), overwrite},
+			{"audit entries", &dump.AuditEntries, len(dump.AuditEntries), keep},
+		}
+		for _, t := range tables {
+			if t.count == 0 {
+				continue
+			}
+			if err := tx.Clauses(t.conflict).Create(t.records).Error; err != nil {
+				return fmt.Errorf("importing %s: %w", t.name, err)
+			}
+		}
+		return resetSequences(tx, &models.Teacher{}, &models.Student{}, &models.Subject{}, &models.Space{},
+			&models.Booking{}, &models.Attendee{}, &models.AvailabilityWindow{}, &models.RecoveryCode{},
+			&models.APIKey{}, &models.CalendarFeed{}, &models.WebhookSubscription{}, &models.WebhookDelivery{},
+			&models.Job{}, &models.NotificationPreference{}, &models.DigestEntry{}, &models.Event{}, &models.AuditEntry{})
+	})
+}
+
+// convert maps records read from a dump to the models they hold
+func convert[R, M any](records []R, model func(R) M) []M {
+	out := make([]M, len(records))
+	for i, r := range records {
+		out[i] = model(r)
+	}
+	return out
+}
+
+// resetSequences moves the id sequence of each model's table past the
+// highest imported id, so rows created afterwards don't collide with
+// imported ones
+func resetSequences(tx *gorm.DB, tables ...any) error {
+	for _, model := range tables {
+		stmt := &gorm.Statement{DB: tx}
+		if err := stmt.Parse(model); err != nil {
+			return err
+		}
+		err := tx.Exec(fmt.Sprintf(
+			"SELECT setval(pg_get_serial_sequence('%[1]s', 'id'), COALESCE((SELECT MAX(id) FROM %[1]s), 0) + 1, false)",
+			stmt.Schema.Table,
+		)).Error
+		if err != nil {
+			return fmt.Errorf("resetting %s id sequence: %w", stmt.Schema.Table, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+// internal/database/transfer_test.go
+package database_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"skedda-goclone/internal/database"
+	"skedda-goclone/internal/dbtest"
+	"skedda-goclone/internal/models"
+)
+
+// seed creates one of every model, with the fields the API hides set
+func seed(t *testing.T, db *database.Database) {
+	t.Helper()
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	later := now.Add(time.Hour)
+	step := func(what string, err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("seeding %s: %v", what, err)
+		}
+	}
+
+	teacher := models.Teacher{
+		Name: "Ada", Email: "ada@example.com", Role: models.RoleAdmin, PasswordHash: "hash",
+		FailedLogins: 2, LockedUntil: &later, TOTPSecret: "JBSWY3DPEHPK3PXP", TOTPEnabled: true, TOTPLastStep: 42, Locale: "es",
+	}
+	step("teacher", db.Create(&teacher).Error)
+	student := models.Student{Name: "Ben", Email: "ben@example.com"}
+	step("student", db.Create(&student).Error)
+	subject := models.Subject{Name: "Maths", Description: "Numbers"}
+	step("subject", db.Create(&subject).Error)
+	step("student subject", db.Create(&models.StudentSubject{StudentID: student.ID, SubjectID: subject.ID}).Error)
+	space := models.Space{Name: "Room A", Capacity: 4, Amenities: []string{"projector"}, CheckInMinutes: 10, CheckInTokenHash: "checkin"}
+	step("space", db.Create(&space).Error)
+	booking := models.Booking{
+		SpaceID: space.ID, StartTime: now, EndTime: later, User: "Ben", Notes: "Bring a calculator",
+		Status: models.StatusConfirmed, Priority: models.UnbaptizedContact, CheckedInAt: &now, TeacherID: &teacher.ID, SubjectID: &subject.ID,
+	}
+	step("booking", db.Create(&booking).Error)
+	step("attendee", db.Create(&models.Attendee{
+		BookingID: booking.ID, Kind: models.AttendeeStudent, StudentID: &student.ID, RSVP: models.RSVPAccepted,
+		Attendance: models.AttendancePresent, MarkedAt: &now, MarkedBy: &teacher.ID,
+	}).Error)
+	step("availability", db.Create(&models.AvailabilityWindow{OwnerType: models.OwnerTeacher, OwnerID: teacher.ID, Weekday: 1, StartTime: "09:00", EndTime: "12:00"}).Error)
+	step("recovery code", db.Create(&models.RecoveryCode{TeacherID: teacher.ID, CodeHash: "code"}).Error)
+	step("API key", db.Create(&models.APIKey{TeacherID: teacher.ID, Name: "script", Prefix: "sk_abc", KeyHash: "key", Scopes: []string{"bookings:read"}, ExpiresAt: later}).Error)
+	step("calendar feed", db.Create(&models.CalendarFeed{OwnerID: teacher.ID, Kind: models.FeedSpace, EntityID: space.ID, TokenHash: "feed"}).Error)
+	webhook := models.WebhookSubscription{CreatedBy: teacher.ID, URL: "https://example.com/hook", Secret: "whsec", Events: []string{models.EventBookingCreated}, Active: true}
+	step("webhook", db.Create(&webhook).Error)
+	step("webhook delivery", db.Create(&models.WebhookDelivery{
+		SubscriptionID: webhook.ID, EventID: "evt", Event: models.EventBookingCreated, Payload: json.RawMessage(`{"id":1}`),
+		Status: models.DeliveryPending, NextAttemptAt: now,
+	}).Error)
+	key := "job-key"
+	step("job", db.Create(&models.Job{Kind: "test", Key: &key, Payload: json.RawMessage(`{}`), Status: models.JobPending, RunAt: now}).Error)
+	step("notification preference", db.Create(&models.NotificationPreference{
+		OwnerType: models.OwnerStudent, OwnerID: student.ID, Channels: []string{models.ChannelEmail}, Events: []string{models.EventBookingCreated},
+		QuietStart: "22:00", QuietEnd: "07:00", Delivery: models.DeliveryDigest, DigestTime: "08:00",
+	}).Error)
+	step("digest entry", db.Create(&models.DigestEntry{
+		OwnerType: models.OwnerStudent, OwnerID: student.ID, Name: "Ben", Email: "ben@example.com",
+		Event: models.EventBookingCreated, BookingID: booking.ID, DueAt: later,
+	}).Error)
+	step("event", db.Create(&models.Event{Type: models.EventBookingCreated, Resource: "bookings", Data: json.RawMessage(`{"id":1}`)}).Error)
+	step("audit entry", db.Create(&models.AuditEntry{ActorID: &teacher.ID, Action: "booking.create", EntityType: "booking", EntityID: "1", Changes: json.RawMessage(`{}`)}).Error)
+}
+
+func exportJSON(t *testing.T, db *database.Database) []byte {
+	t.Helper()
+	dump, err := db.Export()
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	out, err := json.MarshalIndent(dump, "", "  ")
+	if err != nil {
+		t.Fatalf("encoding export: %v", err)
+	}
+	return out
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	db := dbtest.Open(t)
+	seed(t, db)
+
+	// Every table must have been seeded, so a model added later without
+	// being added to the dump fails here
+	var tables []string
+	err := db.Raw(`SELECT tablename FROM pg_tables
+		WHERE schemaname = current_schema() AND tablename <> 'schema_migrations' ORDER BY tablename`).Scan(&tables).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range tables {
+		var n int64
+		if err := db.Table(table).Count(&n).Error; err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			t.Fatalf("table %s has no seed data; add it to seed and to the dump", table)
+		}
+	}
+
+	before := exportJSON(t, db)
+	dbtest.Empty(t, db)
+	var dump database.Dump
+	if err := json.Unmarshal(before, &dump); err != nil {
+		t.Fatalf("decoding export: %v", err)
+	}
+	if err := db.Import(&dump); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+
+	for _, table := range tables {
+		var n int64
+		if err := db.Table(table).Count(&n).Error; err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			t.Errorf("table %s is empty after the round trip", table)
+		}
+	}
+	if after := exportJSON(t, db); !bytes.Equal(before, after) {
+		t.Errorf("export after import differs:\nbefore: %s\nafter: %s", before, after)
+	}
+
+	var teacher models.Teacher
+	if err := db.First(&teacher).Error; err != nil {
+		t.Fatal(err)
+	}
+	if teacher.TOTPSecret == "" || !teacher.TOTPEnabled || teacher.TOTPLastStep != 42 || teacher.LockedUntil == nil {
+		t.Errorf("teacher lost their second factor or lockout: %+v", teacher)
+	}
+
+	// Importing again overwrites rather than failing or duplicating
+	if err := db.Import(&dump); err != nil {
+		t.Fatalf("second import: %v", err)
+	}
+	if again := exportJSON(t, db); !bytes.Equal(before, again) {
+		t.Errorf("export after a second import differs:\nbefore: %s\nafter: %s", before, again)
+	}
+}
+
+func TestImportDisablesTOTPWithoutSecret(t *testing.T) {
+	db := dbtest.Open(t)
+	dump := database.Dump{Teachers: []database.TeacherRecord{{
+		Teacher:  models.Teacher{ID: 1, Name: "Ada", Email: "ada@example.com", TOTPEnabled: true},
+		Password: "correct horse",
+	}}}
+	if err := db.Import(&dump); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	var teacher models.Teacher
+	if err := db.First(&teacher, 1).Error; err != nil {
+		t.Fatal(err)
+	}
+	if teacher.TOTPEnabled {
+		t.Error("TOTP stayed enabled for a teacher imported without a secret")
+	}
+	if !teacher.CheckPassword("correct horse") {
+		t.Error("fixture password wasn't hashed")
+	}
+}
+
+func TestTeacherRecordCarriesHiddenFields(t *testing.T) {
+	locked := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	in := database.TeacherRecord{
+		Teacher:      models.Teacher{ID: 7, Name: "Ada"},
+		PasswordHash: "hash", FailedLogins: 3, LockedUntil: &locked, TOTPSecret: "SECRET", TOTPLastStep: 9,
+	}
+	raw, err := json.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out database.TeacherRecord
+	if err := json.Unmarshal(raw, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.PasswordHash != "hash" || out.FailedLogins != 3 || !out.LockedUntil.Equal(locked) || out.TOTPSecret != "SECRET" || out.TOTPLastStep != 9 {
+		t.Errorf("round trip through JSON lost fields: %s", raw)
+	}
+}
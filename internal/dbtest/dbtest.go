@@ -0,0 +1,52 @@
+// internal/dbtest/dbtest.go
+package dbtest
+
+import (
+	"os"
+	"testing"
+
+	"skedda-goclone/internal/config"
+	"skedda-goclone/internal/database"
+)
+
+// EnvURL names the variable holding the URL of a scratch Postgres database
+// for tests. Tests that need one are skipped when it isn't set. Every
+// table in it is emptied, so never point it at real data.
+const EnvURL = "SKEDDA_TEST_DATABASE_URL"
+
+// Open connects to the scratch database, migrates it and empties every
+// table, or skips the test if there is no scratch database
+func Open(t testing.TB) *database.Database {
+	t.Helper()
+	url := os.Getenv(EnvURL)
+	if url == "" {
+		t.Skipf("%s is not set", EnvURL)
+	}
+	db, err := database.NewDatabase(config.DatabaseConfig{URL: url, MaxOpenConns: 8, MaxIdleConns: 2})
+	if err != nil {
+		t.Fatalf("connecting to the test database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("migrating the test database: %v", err)
+	}
+	Empty(t, db)
+	return db
+}
+
+// Empty removes every row from every table but the schema versions,
+// restarting id sequences
+func Empty(t testing.TB, db *database.Database) {
+	t.Helper()
+	var tables []string
+	err := db.Raw(`SELECT tablename FROM pg_tables
+		WHERE schemaname = current_schema() AND tablename <> 'schema_migrations'`).Scan(&tables).Error
+	if err != nil {
+		t.Fatalf("listing tables: %v", err)
+	}
+	for _, table := range tables {
+		if err := db.Exec(`TRUNCATE TABLE "` + table + `" RESTART IDENTITY CASCADE`).Error; err != nil {
+			t.Fatalf("emptying %s: %v", table, err)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+// internal/events/events.go
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"skedda-goclone/internal/models"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"gorm.io/gorm"
+)
+
+// channel is the Postgres notification channel events are announced on
+const channel = "skedda_events"
+
+// Publish records an event of the given type, such as "booking.created",
+// and announces it to every server. Pass the transaction that made the
+// change: Postgres only delivers the notification if it commits.
+func Publish(tx *gorm.DB, eventType string, data any) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	event := models.Event{Type: eventType, Resource: Resource(eventType), Data: raw}
+	if err := tx.Create(&event).Error; err != nil {
+		return err
+	}
+	return tx.Exec("SELECT pg_notify(?, ?)", channel, strconv.FormatInt(event.ID, 10)).Error
+}
+
+// Resource returns the API resource an event type belongs to, for example
+// "bookings" for "booking.created"
+func Resource(eventType string) string {
+	entity, _, _ := strings.Cut(eventType, ".")
+	return entity + "s"
+}
+
+// subscriberBuffer is how many events a slow client may fall behind by
+// before it is disconnected, to catch up by reconnecting
+const subscriberBuffer = 64
+
+// Hub fans events out to the clients connected to this server. It listens
+// for the notifications Publish sends, so clients see events published
+// by any server sharing the database.
+type Hub struct {
+	DB *gorm.DB
+	// DSN is used to open the connection that listens for notifications
+	DSN string
+
+	mu     sync.Mutex
+	subs   map[chan models.Event]struct{}
+	lastID int64
+	// primed is set once lastID has been read from the database, so a
+	// reconnect knows where to catch up from even before any event
+	primed bool
+	closed bool
+}
+
+// Subscribe returns a channel of events published from now on, and a
+// function to stop receiving them. The channel is closed if the client
+// falls too far behind, or when the hub stops.
+func (h *Hub) Subscribe() (<-chan models.Event, func()) {
+	ch := make(chan models.Event, subscriberBuffer)
+	h.mu.Lock()
+	if h.subs == nil {
+		h.subs = make(map[chan models.Event]struct{})
+	}
+	if h.closed {
+		close(ch)
+	} else {
+		h.subs[ch] = struct{}{}
+	}
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.subs[ch]; ok {
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+func (h *Hub) broadcast(event models.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastID = max(h.lastID, event.ID)
+	for ch := range h.subs {
+		select {
+		case ch <- event:
+		default:
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+func (h *Hub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for ch := range h.subs {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+// Run listens for events until ctx is cancelled, reconnecting if the
+// connection drops. Events published while it was disconnected are sent
+// once it is back. When Run returns every subscription is closed, so
+// streams end rather than holding up shutdown.
+func (h *Hub) Run(ctx context.Context) {
+	defer h.close()
+	for {
+		err := h.listen(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		slog.Error("listening for events, reconnecting", "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func (h *Hub) listen(ctx context.Context) error {
+	conn, err := pgx.Connect(ctx, h.DSN)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+channel); err != nil {
+		return err
+	}
+	if err := h.catchUp(ctx); err != nil {
+		return err
+	}
+
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		id, err := strconv.ParseInt(n.Payload, 10, 64)
+		if err != nil {
+			continue
+		}
+		var event models.Event
+		if err := h.DB.WithContext(ctx).First(&event, id).Error; err != nil {
+			slog.Error("loading event", "event_id", id, "error", err)
+			continue
+		}
+		h.broadcast(event)
+	}
+}
+
+// catchUp sends the events published since the last one this hub saw,
+// which it may have missed while not listening. On the first connection
+// there is nothing to catch up on, but it notes where the events stand, so
+// that those published during a later reconnect aren't lost.
+func (h *Hub) catchUp(ctx context.Context) error {
+	h.mu.Lock()
+	lastID, primed := h.lastID, h.primed
+	h.mu.Unlock()
+	if !primed {
+		var latest int64
+		if err := h.DB.WithContext(ctx).Model(&models.Event{}).Select("COALESCE(MAX(id), 0)").Scan(&latest).Error; err != nil {
+			return err
+		}
+		h.mu.Lock()
+		h.lastID = max(h.lastID, latest)
+		h.primed = true
+		h.mu.Unlock()
+		return nil
+	}
+
+	from, err := ResumePoint(ctx, h.DB, lastID)
+	if err != nil {
+		return err
+	}
+	events, err := Since(ctx, h.DB, from, 0)
+	if err != nil {
+		return err
+	}
+	for _, event := range events {
+		h.broadcast(event)
+	}
+	return nil
+}
+
+// ResumeOverlap is how far back before the last event a client saw it is
+// sent events again when it resumes. IDs are handed out when an event is
+// written but the event only appears when its transaction commits, so an
+// event with a lower ID than one already seen can still turn up while its
+// transaction runs. Events in the overlap may be repeats; clients ignore
+// IDs they already have, as Recent does.
+const ResumeOverlap = time.Minute
+
+// ResumePoint returns the ID to read events after, with Since, to resume
+// from lastID. It goes back over the events written within ResumeOverlap
+// before lastID. If lastID is no longer kept it resumes from lastID.
+func ResumePoint(ctx context.Context, db *gorm.DB, lastID int64) (int64, error) {
+	var last models.Event
+	err := db.WithContext(ctx).Select("id", "created_at").Where("id = ?", lastID).Limit(1).Find(&last).Error
+	if err != nil || last.ID == 0 {
+		return lastID, err
+	}
+	var first int64
+	err = db.WithContext(ctx).Model(&models.Event{}).Select("COALESCE(MIN(id), ?)", lastID+1).
+		Where("id <= ? AND created_at >= ?", lastID, last.CreatedAt.Add(-ResumeOverlap)).Scan(&first).Error
+	return first - 1, err
+}
+
+// Since returns the events after lastID in order, at most limit of them
+// if limit is positive
+func Since(ctx context.Context, db *gorm.DB, lastID int64, limit int) ([]models.Event, error) {
+	query := db.WithContext(ctx).Where("id > ?", lastID).Order("id")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	var events []models.Event
+	err := query.Find(&events).Error
+	return events, err
+}
+
+// Recent remembers the events sent to a client within ResumeOverlap of the
+// newest, so an event that arrives twice, from catching up and live or
+// from a hub that reconnected, is only sent once. The zero value is ready
+// to use.
+type Recent struct {
+	sent   map[int64]time.Time
+	newest time.Time
+	limit  int
+}
+
+// minRecentLimit is how many events Recent holds before it first prunes
+const minRecentLimit = 256
+
+// Add records event, reporting whether it is new
+func (r *Recent) Add(event models.Event) bool {
+	if _, ok := r.sent[event.ID]; ok {
+		return false
+	}
+	if r.sent == nil {
+		r.sent = make(map[int64]time.Time)
+		r.limit = minRecentLimit
+	}
+	r.sent[event.ID] = event.CreatedAt
+	if event.CreatedAt.After(r.newest) {
+		r.newest = event.CreatedAt
+	}
+
+	// Events older than the overlap can't be repeated, so forget them.
+	// Pruning only when the map doubles keeps adding cheap.
+	if len(r.sent) >= r.limit {
+		cutoff := r.newest.Add(-2 * ResumeOverlap)
+		for id, created := range r.sent {
+			if created.Before(cutoff) {
+				delete(r.sent, id)
+			}
+		}
+		r.limit = max(minRecentLimit, 2*len(r.sent))
+	}
+	return true
+}
@@ -0,0 +1,181 @@
+// internal/events/events_test.go
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"skedda-goclone/internal/dbtest"
+	"skedda-goclone/internal/models"
+
+	"gorm.io/gorm"
+)
+
+func TestResource(t *testing.T) {
+	for eventType, want := range map[string]string{
+		"booking.created": "bookings",
+		"space.updated":   "spaces",
+		"attendee.rsvp":   "attendees",
+	} {
+		if got := Resource(eventType); got != want {
+			t.Errorf("Resource(%q) = %q, want %q", eventType, got, want)
+		}
+	}
+}
+
+func TestRecentDropsRepeats(t *testing.T) {
+	var recent Recent
+	now := time.Now()
+	// Events can arrive out of ID order when transactions commit out of
+	// order; only repeats are dropped
+	for _, id := range []int64{11, 10, 12} {
+		if !recent.Add(models.Event{ID: id, CreatedAt: now}) {
+			t.Errorf("event %d dropped on first sight", id)
+		}
+	}
+	for _, id := range []int64{10, 11, 12} {
+		if recent.Add(models.Event{ID: id, CreatedAt: now}) {
+			t.Errorf("event %d sent twice", id)
+		}
+	}
+}
+
+func TestRecentForgetsOldEvents(t *testing.T) {
+	var recent Recent
+	start := time.Now()
+	recent.Add(models.Event{ID: 1, CreatedAt: start})
+	for i := int64(2); i <= minRecentLimit; i++ {
+		recent.Add(models.Event{ID: i, CreatedAt: start.Add(3 * ResumeOverlap)})
+	}
+	if _, ok := recent.sent[1]; ok {
+		t.Error("event from well before the overlap was kept")
+	}
+	if _, ok := recent.sent[2]; !ok {
+		t.Error("event within the overlap was forgotten")
+	}
+}
+
+func TestHubBroadcast(t *testing.T) {
+	hub := &Hub{}
+	a, stopA := hub.Subscribe()
+	b, stopB := hub.Subscribe()
+	defer stopB()
+
+	hub.broadcast(models.Event{ID: 1})
+	if got := <-a; got.ID != 1 {
+		t.Errorf("subscriber a got %d", got.ID)
+	}
+	if got := <-b; got.ID != 1 {
+		t.Errorf("subscriber b got %d", got.ID)
+	}
+
+	// Unsubscribing closes the channel and stops delivery
+	stopA()
+	if _, ok := <-a; ok {
+		t.Error("channel still open after unsubscribing")
+	}
+	stopA()
+	hub.broadcast(models.Event{ID: 2})
+	if got := <-b; got.ID != 2 {
+		t.Errorf("subscriber b got %d", got.ID)
+	}
+	if hub.lastID != 2 {
+		t.Errorf("lastID = %d, want 2", hub.lastID)
+	}
+}
+
+func TestHubDropsSlowSubscribers(t *testing.T) {
+	hub := &Hub{}
+	slow, stop := hub.Subscribe()
+	defer stop()
+	for i := int64(1); i <= subscriberBuffer+1; i++ {
+		hub.broadcast(models.Event{ID: i})
+	}
+	n := 0
+	for range slow {
+		n++
+	}
+	if n != subscriberBuffer {
+		t.Errorf("slow subscriber got %d events before being closed, want %d", n, subscriberBuffer)
+	}
+}
+
+func TestHubClose(t *testing.T) {
+	hub := &Hub{}
+	ch, _ := hub.Subscribe()
+	hub.close()
+	if _, ok := <-ch; ok {
+		t.Error("subscription open after the hub stopped")
+	}
+	late, _ := hub.Subscribe()
+	if _, ok := <-late; ok {
+		t.Error("subscription after the hub stopped is open")
+	}
+}
+
+// publishAt writes an event as if its transaction started at created
+func publishAt(t *testing.T, db *gorm.DB, created time.Time) models.Event {
+	t.Helper()
+	event := models.Event{CreatedAt: created, Type: "booking.created", Resource: "bookings", Data: json.RawMessage(`{}`)}
+	if err := db.Create(&event).Error; err != nil {
+		t.Fatal(err)
+	}
+	return event
+}
+
+func TestResumePointOverlaps(t *testing.T) {
+	db := dbtest.Open(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	old := publishAt(t, db.DB, now.Add(-2*ResumeOverlap))
+	inWindow := publishAt(t, db.DB, now.Add(-ResumeOverlap/2))
+	last := publishAt(t, db.DB, now)
+
+	from, err := ResumePoint(ctx, db.DB, last.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != inWindow.ID-1 {
+		t.Errorf("ResumePoint(%d) = %d, want %d to go back over the overlap but not to %d", last.ID, from, inWindow.ID-1, old.ID)
+	}
+
+	// An ID that is no longer kept resumes from itself
+	if from, err := ResumePoint(ctx, db.DB, last.ID+100); err != nil || from != last.ID+100 {
+		t.Errorf("ResumePoint of an unknown ID = %d, %v", from, err)
+	}
+
+	events, err := Since(ctx, db.DB, from, 1)
+	if err != nil || len(events) != 1 || events[0].ID != inWindow.ID {
+		t.Errorf("Since(%d, limit 1) = %v, %v", from, events, err)
+	}
+}
+
+func TestHubCatchUpWithoutEarlierEvents(t *testing.T) {
+	db := dbtest.Open(t)
+	ctx := context.Background()
+	hub := &Hub{DB: db.DB}
+	ch, stop := hub.Subscribe()
+	defer stop()
+
+	// First connection: nothing seen yet, so nothing to send
+	if err := hub.catchUp(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// An event published while the hub was reconnecting is sent when it is
+	// back, even though the hub had never seen one before
+	missed := publishAt(t, db.DB, time.Now())
+	if err := hub.catchUp(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-ch:
+		if got.ID != missed.ID {
+			t.Errorf("caught up with event %d, want %d", got.ID, missed.ID)
+		}
+	default:
+		t.Error("event published during a reconnect was lost")
+	}
+}
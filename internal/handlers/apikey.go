@@ -0,0 +1,152 @@
+// internal/handlers/apikey.go
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"skedda-goclone/internal/audit"
+	"skedda-goclone/internal/auth"
+	"skedda-goclone/internal/models"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+type APIKeyHandler struct {
+	DB *gorm.DB
+	// MaxTTL is the longest a key can be valid for
+	MaxTTL time.Duration
+}
+
+// CreateAPIKey creates a key for the caller. The key itself is only ever
+// shown in this response.
+func (h *APIKeyHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		httpError(w, r, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	input.Name = strings.TrimSpace(input.Name)
+	if input.Name == "" {
+		httpError(w, r, "Name is required", http.StatusBadRequest)
+		return
+	}
+	if len(input.Scopes) == 0 {
+		httpError(w, r, "At least one scope is required, from: "+strings.Join(auth.Scopes, ", "), http.StatusBadRequest)
+		return
+	}
+	for _, scope := range input.Scopes {
+		if !slices.Contains(auth.Scopes, scope) {
+			httpError(w, r, "Unknown scope "+strconv.Quote(scope)+", expected one of: "+strings.Join(auth.Scopes, ", "), http.StatusBadRequest)
+			return
+		}
+	}
+
+	now := time.Now()
+	expires := now.Add(h.MaxTTL)
+	if input.ExpiresAt != nil {
+		if !input.ExpiresAt.After(now) || input.ExpiresAt.After(expires) {
+			httpError(w, r, "Expiry must be in the future and within "+h.MaxTTL.String(), http.StatusBadRequest)
+			return
+		}
+		expires = *input.ExpiresAt
+	}
+
+	key, hash, err := auth.NewAPIKey()
+	if err != nil {
+		serverError(w, r, "Error generating API key", err)
+		return
+	}
+	caller, _ := auth.FromContext(r.Context())
+	slices.Sort(input.Scopes)
+	apiKey := models.APIKey{
+		TeacherID: caller.TeacherID,
+		Name:      input.Name,
+		Prefix:    key[:len(auth.APIKeyPrefix)+6],
+		KeyHash:   hash,
+		Scopes:    slices.Compact(input.Scopes),
+		ExpiresAt: expires,
+	}
+	err = h.DB.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&apiKey).Error; err != nil {
+			return err
+		}
+		return audit.Record(tx, r, "api_key.create", "api_key", strconv.FormatInt(apiKey.ID, 10),
+			map[string]any{"name": apiKey.Name, "scopes": apiKey.Scopes, "expires_at": apiKey.ExpiresAt})
+	})
+	if err != nil {
+		serverError(w, r, "Error creating API key", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]any{
+		"key":     key,
+		"api_key": apiKey,
+	})
+}
+
+// ListAPIKeys lists the caller's keys, or every teacher's for admins
+// passing all=true
+func (h *APIKeyHandler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+	query := h.DB.WithContext(r.Context()).Order("id")
+	if !(caller.IsAdmin() && r.URL.Query().Get("all") == "true") {
+		query = query.Where("teacher_id = ?", caller.TeacherID)
+	}
+
+	var keys []models.APIKey
+	if err := query.Find(&keys).Error; err != nil {
+		serverError(w, r, "Error fetching API keys", err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(keys)
+}
+
+// RevokeAPIKey stops a key working. Teachers can revoke their own keys,
+// and admins anyone's.
+func (h *APIKeyHandler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+	id := mux.Vars(r)["id"]
+
+	var apiKey models.APIKey
+	err := h.DB.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&apiKey, id).Error; err != nil {
+			return err
+		}
+		if apiKey.TeacherID != caller.TeacherID && !caller.IsAdmin() {
+			return gorm.ErrRecordNotFound
+		}
+		if apiKey.RevokedAt != nil {
+			return nil
+		}
+
+		now := time.Now()
+		apiKey.RevokedAt = &now
+		if err := tx.Model(&apiKey).UpdateColumn("revoked_at", now).Error; err != nil {
+			return err
+		}
+		return audit.Record(tx, r, "api_key.revoke", "api_key", id, map[string]string{"name": apiKey.Name})
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		httpError(w, r, "API key not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		serverError(w, r, "Error revoking API key", err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(apiKey)
+}
@@ -0,0 +1,312 @@
+// internal/handlers/attendance.go
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"skedda-goclone/internal/audit"
+	"skedda-goclone/internal/auth"
+	"skedda-goclone/internal/models"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// attendanceInput marks one attendee. An empty mark clears it.
+type attendanceInput struct {
+	AttendeeID int64  `json:"attendee_id"`
+	Attendance string `json:"attendance"`
+}
+
+var errNotStarted = errors.New("attendance can't be marked before the booking starts")
+
+// MarkAttendance records who turned up to a booking. The body lists
+// attendees and their marks; attendees left out are unchanged.
+func (h *BookingHandler) MarkAttendance(w http.ResponseWriter, r *http.Request) {
+	var input []attendanceInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		httpError(w, r, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	for _, in := range input {
+		if in.Attendance != "" && !slices.Contains(models.AttendanceMarks, in.Attendance) {
+			httpError(w, r, "attendance must be one of "+strings.Join(models.AttendanceMarks, ", "), http.StatusBadRequest)
+			return
+		}
+	}
+
+	caller, _ := auth.FromContext(r.Context())
+	var booking models.Booking
+	err := h.DB.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Scopes(withLesson).First(&booking, pathID(r)).Error; err != nil {
+			return err
+		}
+		if booking.Status == models.StatusCancelled {
+			return errBookingCancelled
+		}
+		now := time.Now()
+		if now.Before(booking.StartTime) {
+			return errNotStarted
+		}
+		before := booking
+		before.Attendees = slices.Clone(booking.Attendees)
+
+		for _, in := range input {
+			i := slices.IndexFunc(booking.Attendees, func(a models.Attendee) bool { return a.ID == in.AttendeeID })
+			if i < 0 {
+				return errAttendeeNotFound
+			}
+			a := &booking.Attendees[i]
+			if a.Attendance == in.Attendance {
+				continue
+			}
+			a.Attendance, a.MarkedAt, a.MarkedBy = in.Attendance, &now, &caller.TeacherID
+			if in.Attendance == "" {
+				a.MarkedAt, a.MarkedBy = nil, nil
+			}
+			err := tx.Model(&models.Attendee{}).Where("id = ?", a.ID).
+				Updates(map[string]any{"attendance": a.Attendance, "marked_at": a.MarkedAt, "marked_by": a.MarkedBy}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := audit.RecordChange(tx, r, "booking.attendance", "booking", bookingID(&booking), before, booking); err != nil {
+			return err
+		}
+		return publish(tx, models.EventBookingUpdated, booking)
+	})
+
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		httpError(w, r, "Booking not found", http.StatusNotFound)
+	case errors.Is(err, errAttendeeNotFound):
+		httpError(w, r, "Attendee not found", http.StatusNotFound)
+	case errors.Is(err, errBookingCancelled):
+		httpError(w, r, "Booking has been cancelled", http.StatusConflict)
+	case errors.Is(err, errNotStarted):
+		httpError(w, r, errNotStarted.Error(), http.StatusConflict)
+	case err != nil:
+		serverError(w, r, "Error marking attendance", err)
+	default:
+		json.NewEncoder(w).Encode(booking)
+	}
+}
+
+// AttendanceHandler serves attendance history and reports
+type AttendanceHandler struct {
+	DB *gorm.DB
+}
+
+// attendanceFilter narrows attendance reports to bookings starting between
+// From and To, and optionally to one subject, teacher or priority level
+type attendanceFilter struct {
+	From, To  time.Time
+	SubjectID int64
+	TeacherID int64
+	Priority  models.PriorityLevel
+}
+
+// parseAttendanceFilter reads from, to, subject_id, teacher_id and
+// priority. Reports cover the last 90 days up to now by default.
+func parseAttendanceFilter(r *http.Request) (attendanceFilter, error) {
+	q := r.URL.Query()
+	f := attendanceFilter{To: time.Now()}
+	f.From = f.To.AddDate(0, 0, -90)
+	var err error
+	if v := q.Get("from"); v != "" {
+		if f.From, err = time.Parse(time.RFC3339, v); err != nil {
+			return f, errors.New("invalid from time")
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if f.To, err = time.Parse(time.RFC3339, v); err != nil {
+			return f, errors.New("invalid to time")
+		}
+	}
+	if f.SubjectID, err = optionalID(r, "subject_id"); err != nil {
+		return f, err
+	}
+	if f.TeacherID, err = optionalID(r, "teacher_id"); err != nil {
+		return f, err
+	}
+	if v := q.Get("priority"); v != "" {
+		p, err := strconv.Atoi(v)
+		if err != nil || !models.PriorityLevel(p).Valid() {
+			return f, fmt.Errorf("unknown priority level %q", v)
+		}
+		f.Priority = models.PriorityLevel(p)
+	}
+	return f, nil
+}
+
+// apply limits a query joining attendees to bookings to f
+func (f attendanceFilter) apply(db *gorm.DB) *gorm.DB {
+	db = db.Joins("JOIN bookings ON bookings.id = attendees.booking_id AND bookings.deleted_at IS NULL").
+		Where("bookings.status <> ?", models.StatusCancelled).
+		Where("bookings.start_time >= ? AND bookings.start_time < ?", f.From, f.To)
+	if f.SubjectID != 0 {
+		db = db.Where("bookings.subject_id = ?", f.SubjectID)
+	}
+	if f.TeacherID != 0 {
+		db = db.Where("bookings.teacher_id = ?", f.TeacherID)
+	}
+	if f.Priority != 0 {
+		db = db.Where("bookings.priority = ?", f.Priority)
+	}
+	return db
+}
+
+// attendanceSummary counts attendance marks. NoShowRate is the share of
+// marked sessions the student missed without an excuse.
+type attendanceSummary struct {
+	Present    int     `json:"present"`
+	Late       int     `json:"late"`
+	Absent     int     `json:"absent"`
+	Excused    int     `json:"excused"`
+	Unmarked   int     `json:"unmarked"`
+	NoShowRate float64 `json:"no_show_rate"`
+}
+
+func (s *attendanceSummary) add(mark string) {
+	switch mark {
+	case models.AttendancePresent:
+		s.Present++
+	case models.AttendanceLate:
+		s.Late++
+	case models.AttendanceAbsent:
+		s.Absent++
+	case models.AttendanceExcused:
+		s.Excused++
+	default:
+		s.Unmarked++
+	}
+}
+
+func (s *attendanceSummary) rate() {
+	if due := s.Present + s.Late + s.Absent; due > 0 {
+		s.NoShowRate = float64(s.Absent) / float64(due)
+	}
+}
+
+// attendanceRecord is one booking in a student's attendance history
+type attendanceRecord struct {
+	BookingID  uint                 `json:"booking_id"`
+	SpaceID    int64                `json:"space_id"`
+	StartTime  time.Time            `json:"start_time"`
+	EndTime    time.Time            `json:"end_time"`
+	Priority   models.PriorityLevel `json:"priority"`
+	SubjectID  *int64               `json:"subject_id"`
+	Subject    string               `json:"subject"`
+	TeacherID  *int64               `json:"teacher_id"`
+	Teacher    string               `json:"teacher"`
+	Attendance string               `json:"attendance"`
+	MarkedAt   *time.Time           `json:"marked_at"`
+}
+
+// StudentAttendance returns a student's attendance at the bookings they
+// were due at, newest first, with a summary
+func (h *AttendanceHandler) StudentAttendance(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseAttendanceFilter(r)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	db := h.DB.WithContext(r.Context())
+	var student models.Student
+	if err := db.First(&student, pathID(r)).Error; err != nil {
+		ownerError(w, r, models.OwnerStudent, err)
+		return
+	}
+
+	records := []attendanceRecord{}
+	err = db.Table("attendees").Scopes(filter.apply).
+		Select(`bookings.id AS booking_id, bookings.space_id, bookings.start_time, bookings.end_time, bookings.priority,
+			bookings.subject_id, COALESCE(subjects.name, '') AS subject,
+			bookings.teacher_id, COALESCE(teachers.name, '') AS teacher,
+			attendees.attendance, attendees.marked_at`).
+		Joins("LEFT JOIN subjects ON subjects.id = bookings.subject_id").
+		Joins("LEFT JOIN teachers ON teachers.id = bookings.teacher_id").
+		Where("attendees.student_id = ? AND attendees.rsvp <> ?", student.ID, models.RSVPDeclined).
+		Order("bookings.start_time DESC").
+		Scan(&records).Error
+	if err != nil {
+		serverError(w, r, "Error fetching attendance", err)
+		return
+	}
+
+	var summary attendanceSummary
+	for _, rec := range records {
+		summary.add(rec.Attendance)
+	}
+	summary.rate()
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"student": student,
+		"summary": summary,
+		"records": records,
+	})
+}
+
+// noShowRow is one student's line in the no-show report
+type noShowRow struct {
+	StudentID int64  `json:"student_id"`
+	Name      string `json:"name"`
+	attendanceSummary
+}
+
+// NoShowReport summarises attendance per student, highest no-show rate
+// first. It takes the filters of parseAttendanceFilter plus student_id.
+func (h *AttendanceHandler) NoShowReport(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseAttendanceFilter(r)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	studentID, err := optionalID(r, "student_id")
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := h.DB.WithContext(r.Context()).Table("attendees").Scopes(filter.apply).
+		Select(`attendees.student_id, students.name,
+			COUNT(*) FILTER (WHERE attendees.attendance = ?) AS present,
+			COUNT(*) FILTER (WHERE attendees.attendance = ?) AS late,
+			COUNT(*) FILTER (WHERE attendees.attendance = ?) AS absent,
+			COUNT(*) FILTER (WHERE attendees.attendance = ?) AS excused,
+			COUNT(*) FILTER (WHERE attendees.attendance = '') AS unmarked`,
+			models.AttendancePresent, models.AttendanceLate, models.AttendanceAbsent, models.AttendanceExcused).
+		Joins("JOIN students ON students.id = attendees.student_id").
+		Where("attendees.rsvp <> ?", models.RSVPDeclined).
+		Group("attendees.student_id, students.name")
+	if studentID != 0 {
+		query = query.Where("attendees.student_id = ?", studentID)
+	}
+
+	rows := []noShowRow{}
+	if err := query.Scan(&rows).Error; err != nil {
+		serverError(w, r, "Error building no-show report", err)
+		return
+	}
+	for i := range rows {
+		rows[i].rate()
+	}
+	slices.SortStableFunc(rows, func(a, b noShowRow) int {
+		if a.NoShowRate != b.NoShowRate {
+			if a.NoShowRate > b.NoShowRate {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	json.NewEncoder(w).Encode(rows)
+}
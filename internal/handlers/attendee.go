@@ -0,0 +1,342 @@
+// internal/handlers/attendee.go
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"skedda-goclone/internal/audit"
+	"skedda-goclone/internal/models"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// attendeeInput names one attendee: a student, a teacher, or a guest
+// given by name and email
+type attendeeInput struct {
+	StudentID *int64 `json:"student_id"`
+	TeacherID *int64 `json:"teacher_id"`
+	Name      string `json:"name"`
+	Email     string `json:"email"`
+	RSVP      string `json:"rsvp"`
+}
+
+// attendee validates in and returns the attendee it describes, without
+// checking that a linked student or teacher exists
+func (in attendeeInput) attendee() (models.Attendee, error) {
+	a := models.Attendee{StudentID: in.StudentID, TeacherID: in.TeacherID, RSVP: in.RSVP}
+	if a.RSVP == "" {
+		a.RSVP = models.RSVPPending
+	}
+	if !slices.Contains(models.RSVPs, a.RSVP) {
+		return a, fmt.Errorf("rsvp must be one of %s", strings.Join(models.RSVPs, ", "))
+	}
+
+	switch {
+	case in.StudentID != nil && in.TeacherID != nil:
+		return a, errors.New("an attendee is either a student or a teacher")
+	case in.StudentID != nil:
+		a.Kind = models.AttendeeStudent
+	case in.TeacherID != nil:
+		a.Kind = models.AttendeeTeacher
+	default:
+		a.Kind = models.AttendeeGuest
+		a.Name = strings.TrimSpace(in.Name)
+		if a.Name == "" {
+			return a, errors.New("guests need a name")
+		}
+		if in.Email != "" {
+			addr, err := mail.ParseAddress(in.Email)
+			if err != nil {
+				return a, fmt.Errorf("invalid guest email %q", in.Email)
+			}
+			a.Email = addr.Address
+		}
+	}
+	return a, nil
+}
+
+// sameAttendee reports whether a and b are the same person
+func sameAttendee(a, b *models.Attendee) bool {
+	switch {
+	case a.StudentID != nil && b.StudentID != nil:
+		return *a.StudentID == *b.StudentID
+	case a.TeacherID != nil && b.TeacherID != nil:
+		return *a.TeacherID == *b.TeacherID
+	case a.Kind == models.AttendeeGuest && b.Kind == models.AttendeeGuest:
+		return strings.EqualFold(a.Name, b.Name) && strings.EqualFold(a.Email, b.Email)
+	}
+	return false
+}
+
+// addAttendee appends a to list, or updates the RSVP of the matching
+// attendee if it is already there. An unset RSVP keeps the old answer.
+func addAttendee(list []models.Attendee, a models.Attendee, rsvpSet bool) []models.Attendee {
+	for i := range list {
+		if sameAttendee(&list[i], &a) {
+			if rsvpSet && list[i].RSVP != a.RSVP {
+				now := time.Now()
+				list[i].RSVP = a.RSVP
+				list[i].RespondedAt = &now
+			}
+			return list
+		}
+	}
+	return append(list, a)
+}
+
+// setAttendees makes b's attendee rows match b.Attendees. Attendees
+// carried over from before keep their IDs, and anyone missing is removed.
+func setAttendees(tx *gorm.DB, b *models.Booking) error {
+	keep := make([]int64, 0, len(b.Attendees))
+	for _, a := range b.Attendees {
+		if a.ID != 0 {
+			keep = append(keep, a.ID)
+		}
+	}
+	remove := tx.Where("booking_id = ?", b.ID)
+	if len(keep) > 0 {
+		remove = remove.Where("id NOT IN ?", keep)
+	}
+	if err := remove.Delete(&models.Attendee{}).Error; err != nil {
+		return err
+	}
+
+	for i := range b.Attendees {
+		a := &b.Attendees[i]
+		a.BookingID = b.ID
+		if err := tx.Omit(clause.Associations).Save(a).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// attendeeConflictError names an attendee who is already booked elsewhere
+type attendeeConflictError struct{ who string }
+
+func (e attendeeConflictError) Error() string {
+	return e.who + " is already booked at that time"
+}
+
+// checkAttendees enforces the space's capacity and makes sure no student
+// or teacher taking part in b is due somewhere else at the same time.
+// Each person is locked first, in a fixed order, so concurrent bookings
+// can't both claim them.
+func checkAttendees(tx *gorm.DB, b *models.Booking, buffer time.Duration) error {
+	var teachers, students []int64
+	if b.TeacherID != nil {
+		teachers = append(teachers, *b.TeacherID)
+	}
+	places := 0
+	for _, a := range b.Attendees {
+		if !a.Counts() {
+			continue
+		}
+		places++
+		switch {
+		case a.StudentID != nil:
+			students = append(students, *a.StudentID)
+		case a.TeacherID != nil:
+			teachers = append(teachers, *a.TeacherID)
+		}
+	}
+
+	var space models.Space
+	err := tx.Select("capacity").First(&space, b.SpaceID).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if space.Capacity > 0 && places > space.Capacity {
+		return ruleError{fmt.Errorf("the space holds %d people, not %d", space.Capacity, places)}
+	}
+
+	people := []struct {
+		lock  int32
+		ids   []int64
+		model any
+		where string
+	}{
+		{lockTeacher, teachers, &models.Teacher{}, "(teacher_id = @id OR bookings.id IN (SELECT booking_id FROM attendees WHERE teacher_id = @id AND rsvp <> 'declined'))"},
+		{lockStudent, students, &models.Student{}, "bookings.id IN (SELECT booking_id FROM attendees WHERE student_id = @id AND rsvp <> 'declined')"},
+	}
+	for _, p := range people {
+		ids := slices.Compact(slices.Sorted(slices.Values(p.ids)))
+		for _, id := range ids {
+			if err := tx.Exec("SELECT pg_advisory_xact_lock(?, ?)", p.lock, int32(id)).Error; err != nil {
+				return err
+			}
+		}
+		for _, id := range ids {
+			var count int64
+			err := tx.Model(&models.Booking{}).
+				Where("status NOT IN ? AND bookings.id <> ?", models.InactiveStatuses, b.ID).
+				Where("start_time < ? AND end_time > ?", b.EndTime.Add(buffer), b.StartTime.Add(-buffer)).
+				Where(p.where, sql.Named("id", id)).
+				Count(&count).Error
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				var name string
+				tx.Model(p.model).Where("id = ?", id).Pluck("name", &name)
+				return attendeeConflictError{name}
+			}
+		}
+	}
+	return nil
+}
+
+// AddAttendee adds a student, teacher or guest to a booking, or changes
+// their RSVP if they are already on it
+func (h *BookingHandler) AddAttendee(w http.ResponseWriter, r *http.Request) {
+	var input attendeeInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		httpError(w, r, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	a, err := input.attendee()
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	booking, err := h.changeAttendees(r, "booking.attendee.add", func(tx *gorm.DB, b *models.Booking) error {
+		if err := checkAttendeeLinks(tx, []models.Attendee{a}); err != nil {
+			return err
+		}
+		b.Attendees = addAttendee(b.Attendees, a, input.RSVP != "")
+		return nil
+	})
+	h.attendeeResponse(w, r, booking, err)
+}
+
+// RemoveAttendee takes an attendee off a booking
+func (h *BookingHandler) RemoveAttendee(w http.ResponseWriter, r *http.Request) {
+	attendeeID, _ := strconv.ParseInt(mux.Vars(r)["attendee_id"], 10, 64)
+	booking, err := h.changeAttendees(r, "booking.attendee.remove", func(tx *gorm.DB, b *models.Booking) error {
+		i := slices.IndexFunc(b.Attendees, func(a models.Attendee) bool { return a.ID == attendeeID })
+		if i < 0 {
+			return errAttendeeNotFound
+		}
+		b.Attendees = slices.Delete(b.Attendees, i, i+1)
+		return nil
+	})
+	h.attendeeResponse(w, r, booking, err)
+}
+
+// SetRSVP records an attendee's answer to a booking
+func (h *BookingHandler) SetRSVP(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RSVP string `json:"rsvp"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		httpError(w, r, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if !slices.Contains(models.RSVPs, input.RSVP) {
+		httpError(w, r, "rsvp must be one of "+strings.Join(models.RSVPs, ", "), http.StatusBadRequest)
+		return
+	}
+
+	attendeeID, _ := strconv.ParseInt(mux.Vars(r)["attendee_id"], 10, 64)
+	booking, err := h.changeAttendees(r, "booking.attendee.rsvp", func(tx *gorm.DB, b *models.Booking) error {
+		i := slices.IndexFunc(b.Attendees, func(a models.Attendee) bool { return a.ID == attendeeID })
+		if i < 0 {
+			return errAttendeeNotFound
+		}
+		now := time.Now()
+		b.Attendees[i].RSVP = input.RSVP
+		b.Attendees[i].RespondedAt = &now
+		return nil
+	})
+	h.attendeeResponse(w, r, booking, err)
+}
+
+var errAttendeeNotFound = errors.New("attendee not found")
+
+// changeAttendees loads the booking in the path, lets change edit its
+// attendees, then checks and saves them in one transaction
+func (h *BookingHandler) changeAttendees(r *http.Request, action string, change func(*gorm.DB, *models.Booking) error) (*models.Booking, error) {
+	var booking models.Booking
+	err := h.DB.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		// Lock the booking, so concurrent changes to its attendees queue up
+		// rather than each saving over the other's list
+		if err := tx.Clauses(forUpdate).Scopes(withLesson).First(&booking, pathID(r)).Error; err != nil {
+			return err
+		}
+		if slices.Contains(models.InactiveStatuses, booking.Status) {
+			return errBookingInactive
+		}
+		before := booking
+		before.Attendees = slices.Clone(booking.Attendees)
+
+		if err := change(tx, &booking); err != nil {
+			return err
+		}
+		if err := checkAttendees(tx, &booking, h.Rules.Buffer); err != nil {
+			return err
+		}
+		if err := setAttendees(tx, &booking); err != nil {
+			return err
+		}
+		if err := tx.Clauses(forUpdate).Scopes(withLesson).First(&booking, booking.ID).Error; err != nil {
+			return err
+		}
+		if err := audit.RecordChange(tx, r, action, "booking", bookingID(&booking), before, booking); err != nil {
+			return err
+		}
+		return publish(tx, models.EventBookingUpdated, booking)
+	})
+	return &booking, err
+}
+
+func (h *BookingHandler) attendeeResponse(w http.ResponseWriter, r *http.Request, booking *models.Booking, err error) {
+	var (
+		rule     ruleError
+		conflict attendeeConflictError
+	)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		httpError(w, r, "Booking not found", http.StatusNotFound)
+	case errors.Is(err, errAttendeeNotFound):
+		httpError(w, r, "Attendee not found", http.StatusNotFound)
+	case errors.Is(err, errBookingInactive):
+		httpError(w, r, "Booking has been cancelled or released", http.StatusConflict)
+	case errors.As(err, &rule):
+		httpError(w, r, rule.Error(), http.StatusBadRequest)
+	case errors.As(err, &conflict):
+		httpError(w, r, conflict.Error(), http.StatusConflict)
+	case err != nil:
+		serverError(w, r, "Error updating attendees", err)
+	default:
+		json.NewEncoder(w).Encode(booking)
+	}
+}
+
+// checkAttendeeLinks returns a ruleError if an attendee names a student or
+// teacher that doesn't exist
+func checkAttendeeLinks(tx *gorm.DB, attendees []models.Attendee) error {
+	for _, a := range attendees {
+		switch {
+		case a.StudentID != nil:
+			if err := mustExist(tx, &models.Student{}, *a.StudentID, "student"); err != nil {
+				return err
+			}
+		case a.TeacherID != nil:
+			if err := mustExist(tx, &models.Teacher{}, *a.TeacherID, "teacher"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+// internal/handlers/audit.go
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"skedda-goclone/internal/models"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type AuditHandler struct {
+	DB *gorm.DB
+}
+
+// Page sizes for ListAuditEntries
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// ListAuditEntries returns audit entries newest first, filtered by any of
+// actor_id, action, entity_type, entity_id, request_id, from and to. Pass
+// the smallest id of a page as before_id to get the next one.
+func (h *AuditHandler) ListAuditEntries(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	query := h.DB.WithContext(r.Context()).Order("id DESC")
+
+	for param, column := range map[string]string{
+		"actor_id":    "actor_id",
+		"action":      "action",
+		"entity_type": "entity_type",
+		"entity_id":   "entity_id",
+		"request_id":  "request_id",
+	} {
+		if v := q.Get(param); v != "" {
+			query = query.Where(column+" = ?", v)
+		}
+	}
+	for param, cond := range map[string]string{"from": "created_at >= ?", "to": "created_at < ?"} {
+		if v := q.Get(param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				httpError(w, r, "Invalid "+param+" time", http.StatusBadRequest)
+				return
+			}
+			query = query.Where(cond, t)
+		}
+	}
+	if v := q.Get("before_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			httpError(w, r, "Invalid before_id", http.StatusBadRequest)
+			return
+		}
+		query = query.Where("id < ?", id)
+	}
+
+	limit := defaultAuditLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxAuditLimit {
+			httpError(w, r, "limit must be between 1 and "+strconv.Itoa(maxAuditLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	var entries []models.AuditEntry
+	if err := query.Limit(limit).Find(&entries).Error; err != nil {
+		serverError(w, r, "Error fetching audit entries", err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(entries)
+}
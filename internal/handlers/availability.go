@@ -0,0 +1,155 @@
+// internal/handlers/availability.go
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"skedda-goclone/internal/availability"
+	"skedda-goclone/internal/models"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Limits on FindSlots
+const (
+	defaultSlotLimit = 20
+	maxSlotLimit     = 200
+	defaultSlotRange = 7 * 24 * time.Hour
+)
+
+// FindSlots returns ranked free slots of a given duration across spaces.
+// Parameters are duration (such as 1h30m), from and to (RFC 3339, default
+// the coming week), capacity, amenities and space_ids (comma separated,
+// the preferred spaces) and limit. Any slot returned can be booked as is
+// with CreateBooking.
+func (h *BookingHandler) FindSlots(w http.ResponseWriter, r *http.Request) {
+	req, err := h.slotRequest(r)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	slots, err := h.findSlots(h.DB.WithContext(r.Context()), req)
+	if err != nil {
+		serverError(w, r, "Error finding slots", err)
+		return
+	}
+	json.NewEncoder(w).Encode(slots)
+}
+
+// findSlots runs req against every space and its bookings
+func (h *BookingHandler) findSlots(db *gorm.DB, req availability.Request) ([]availability.Slot, error) {
+	var spaces []models.Space
+	if err := db.Order("id").Find(&spaces).Error; err != nil {
+		return nil, err
+	}
+	bookings, err := h.activeBookings(db, req.From, req.To)
+	if err != nil {
+		return nil, err
+	}
+
+	busy := make(map[int64][]availability.Interval)
+	for _, b := range bookings {
+		busy[b.SpaceID] = append(busy[b.SpaceID], availability.Interval{Start: b.StartTime, End: b.EndTime})
+	}
+	candidates := make([]availability.Space, len(spaces))
+	for i, s := range spaces {
+		candidates[i] = availability.Space{ID: s.ID, Name: s.Name, Capacity: s.Capacity, Amenities: s.Amenities, Busy: busy[s.ID]}
+	}
+
+	slots := availability.Find(req, h.slotRules(time.Now()), candidates)
+	if slots == nil {
+		slots = []availability.Slot{}
+	}
+	return slots, nil
+}
+
+// activeBookings returns the bookings that still hold their space and come
+// within the buffer of the span from from to to. Pass scopes to narrow it
+// further.
+func (h *BookingHandler) activeBookings(db *gorm.DB, from, to time.Time, scopes ...func(*gorm.DB) *gorm.DB) ([]models.Booking, error) {
+	var bookings []models.Booking
+	err := db.Scopes(scopes...).
+		Where("status NOT IN ? AND start_time < ? AND end_time > ?", models.InactiveStatuses, to.Add(h.Rules.Buffer), from.Add(-h.Rules.Buffer)).
+		Find(&bookings).Error
+	return bookings, err
+}
+
+// slotRules returns the booking rules as the slot finder takes them
+func (h *BookingHandler) slotRules(now time.Time) availability.Rules {
+	return availability.Rules{
+		OpenMinute:  clockString(h.Rules.OpenTime),
+		CloseMinute: clockString(h.Rules.CloseTime),
+		Location:    h.Rules.Location(),
+		Buffer:      h.Rules.Buffer,
+		Step:        h.Rules.SlotStep,
+		NotBefore:   now,
+	}
+}
+
+func (h *BookingHandler) slotRequest(r *http.Request) (availability.Request, error) {
+	q := r.URL.Query()
+	req := availability.Request{From: time.Now(), Limit: defaultSlotLimit}
+
+	d, err := time.ParseDuration(q.Get("duration"))
+	if err != nil {
+		return req, fmt.Errorf("duration is required, such as 1h or 45m")
+	}
+	if d < h.Rules.MinDuration || d > h.Rules.MaxDuration {
+		return req, fmt.Errorf("duration must be between %s and %s", h.Rules.MinDuration, h.Rules.MaxDuration)
+	}
+	req.Duration = d
+
+	if v := q.Get("from"); v != "" {
+		if req.From, err = time.Parse(time.RFC3339, v); err != nil {
+			return req, fmt.Errorf("invalid from time")
+		}
+	}
+	req.To = req.From.Add(defaultSlotRange)
+	if v := q.Get("to"); v != "" {
+		if req.To, err = time.Parse(time.RFC3339, v); err != nil {
+			return req, fmt.Errorf("invalid to time")
+		}
+	}
+	if !req.To.After(req.From) {
+		return req, fmt.Errorf("to must be after from")
+	}
+	if latest := time.Now().Add(h.Rules.MaxAdvance); req.To.After(latest) {
+		req.To = latest
+	}
+
+	if v := q.Get("capacity"); v != "" {
+		if req.Capacity, err = strconv.Atoi(v); err != nil || req.Capacity < 0 {
+			return req, fmt.Errorf("invalid capacity")
+		}
+	}
+	req.Amenities = splitParam(q.Get("amenities"))
+	for _, v := range splitParam(q.Get("space_ids")) {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return req, fmt.Errorf("invalid space ID %q", v)
+		}
+		req.Preferred = append(req.Preferred, id)
+	}
+	if v := q.Get("limit"); v != "" {
+		if req.Limit, err = strconv.Atoi(v); err != nil || req.Limit <= 0 || req.Limit > maxSlotLimit {
+			return req, fmt.Errorf("limit must be between 1 and %d", maxSlotLimit)
+		}
+	}
+	return req, nil
+}
+
+// splitParam splits a comma separated query parameter, dropping blanks
+func splitParam(v string) []string {
+	var out []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
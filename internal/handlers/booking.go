@@ -0,0 +1,482 @@
+// internal/handlers/booking.go
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"skedda-goclone/internal/audit"
+	"skedda-goclone/internal/auth"
+	"skedda-goclone/internal/config"
+	"skedda-goclone/internal/metrics"
+	"skedda-goclone/internal/models"
+	"skedda-goclone/internal/notify"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type BookingHandler struct {
+	DB    *gorm.DB
+	Rules config.BookingConfig
+}
+
+// bookingInput is the part of a booking a client may set
+type bookingInput struct {
+	SpaceID   int64                `json:"space_id"`
+	StartTime time.Time            `json:"start_time"`
+	EndTime   time.Time            `json:"end_time"`
+	User      string               `json:"user"`
+	Notes     string               `json:"notes"`
+	Priority  models.PriorityLevel `json:"priority"`
+	// Lessons name who and what they are for
+	TeacherID  *int64  `json:"teacher_id"`
+	SubjectID  *int64  `json:"subject_id"`
+	StudentIDs []int64 `json:"student_ids"`
+	// Attendees lists anyone else taking part, such as guests
+	Attendees []attendeeInput `json:"attendees"`
+}
+
+var errBookingConflict = errors.New("booking conflicts with existing reservation")
+
+// CreateBooking books a space, rejecting bookings that break the booking
+// rules or overlap an existing booking of the same space. A booking may
+// link a teacher, a subject and students to make it a lesson. A booking of
+// a space that requires approval is pending until an admin approves it.
+func (h *BookingHandler) CreateBooking(w http.ResponseWriter, r *http.Request) {
+	var input bookingInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		httpError(w, r, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	booking := models.Booking{
+		SpaceID:   input.SpaceID,
+		StartTime: input.StartTime,
+		EndTime:   input.EndTime,
+		User:      input.User,
+		Notes:     input.Notes,
+		Status:    models.StatusConfirmed,
+		Priority:  input.Priority,
+	}
+	if err := h.checkRules(&booking, time.Now()); err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := h.DB.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := linkLesson(tx, &booking, &input); err != nil {
+			return err
+		}
+		status, err := approvalStatus(tx, r, booking.SpaceID)
+		if err != nil {
+			return err
+		}
+		booking.Status = status
+		if err := checkConflict(tx, &booking, h.Rules.Buffer); err != nil {
+			return err
+		}
+		if err := checkAttendees(tx, &booking, h.Rules.Buffer); err != nil {
+			return err
+		}
+		if err := tx.Omit(clause.Associations).Create(&booking).Error; err != nil {
+			return err
+		}
+		if err := setAttendees(tx, &booking); err != nil {
+			return err
+		}
+		if err := tx.Scopes(withLesson).First(&booking, booking.ID).Error; err != nil {
+			return err
+		}
+		if err := audit.RecordChange(tx, r, "booking.create", "booking", bookingID(&booking), nil, booking); err != nil {
+			return err
+		}
+		if err := notify.Enqueue(tx, models.EventBookingCreated, &booking); err != nil {
+			return err
+		}
+		return publish(tx, models.EventBookingCreated, booking)
+	})
+	var (
+		rule     ruleError
+		attendee attendeeConflictError
+	)
+	if errors.As(err, &rule) {
+		httpError(w, r, rule.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.As(err, &attendee) {
+		httpError(w, r, attendee.Error(), http.StatusConflict)
+		return
+	}
+	if errors.Is(err, errBookingConflict) {
+		metrics.BookingConflicts.WithLabelValues(spaceLabel(booking.SpaceID)).Inc()
+		httpError(w, r, "Booking conflicts with existing reservation", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		serverError(w, r, "Error saving booking", err)
+		return
+	}
+	metrics.BookingsCreated.WithLabelValues(spaceLabel(booking.SpaceID), booking.Priority.String()).Inc()
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(booking)
+}
+
+// ListBookings returns bookings ending after `from` (default now) and
+// starting before `to`, optionally limited to one space, status, teacher,
+// student or subject
+func (h *BookingHandler) ListBookings(w http.ResponseWriter, r *http.Request) {
+	query := h.DB.WithContext(r.Context()).Scopes(withLesson).Order("start_time")
+
+	from := time.Now()
+	if v := r.URL.Query().Get("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			httpError(w, r, "Invalid from time", http.StatusBadRequest)
+			return
+		}
+		from = t
+	}
+	query = query.Where("end_time > ?", from)
+
+	if v := r.URL.Query().Get("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			httpError(w, r, "Invalid to time", http.StatusBadRequest)
+			return
+		}
+		query = query.Where("start_time < ?", t)
+	}
+	if v := r.URL.Query().Get("space_id"); v != "" {
+		query = query.Where("space_id = ?", v)
+	}
+	if v := r.URL.Query().Get("status"); v != "" {
+		query = query.Where("status = ?", v)
+	}
+	if v := r.URL.Query().Get("teacher_id"); v != "" {
+		query = query.Where("teacher_id = ?", v)
+	}
+	if v := r.URL.Query().Get("subject_id"); v != "" {
+		query = query.Where("subject_id = ?", v)
+	}
+	if v := r.URL.Query().Get("student_id"); v != "" {
+		query = query.Where("bookings.id IN (SELECT booking_id FROM attendees WHERE student_id = ?)", v)
+	}
+
+	var bookings []models.Booking
+	if err := query.Find(&bookings).Error; err != nil {
+		serverError(w, r, "Error fetching bookings", err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(bookings)
+}
+
+// UpdateBooking edits a booking that hasn't been cancelled. Time and space
+// changes are checked against the booking rules and other bookings.
+func (h *BookingHandler) UpdateBooking(w http.ResponseWriter, r *http.Request) {
+	var input bookingInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		httpError(w, r, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	var booking models.Booking
+	err := h.DB.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		// Lock the booking, as changeAttendees does, so attendee changes
+		// made meanwhile aren't lost when its attendees are rewritten
+		if err := tx.Clauses(forUpdate).Scopes(withLesson).First(&booking, pathID(r)).Error; err != nil {
+			return err
+		}
+		if slices.Contains(models.InactiveStatuses, booking.Status) {
+			return errBookingInactive
+		}
+		before := booking
+
+		moved := booking.SpaceID != input.SpaceID || !booking.StartTime.Equal(input.StartTime) || !booking.EndTime.Equal(input.EndTime)
+		booking.SpaceID = input.SpaceID
+		booking.StartTime = input.StartTime
+		booking.EndTime = input.EndTime
+		booking.User = input.User
+		booking.Notes = input.Notes
+		booking.Priority = input.Priority
+		if err := linkLesson(tx, &booking, &input); err != nil {
+			return err
+		}
+		if booking.SpaceID != before.SpaceID {
+			// The new space may need approval where the old one didn't, or
+			// the other way round
+			status, err := approvalStatus(tx, r, booking.SpaceID)
+			if err != nil {
+				return err
+			}
+			booking.Status = status
+		}
+
+		if moved {
+			if err := h.checkRules(&booking, time.Now()); err != nil {
+				return ruleError{err}
+			}
+			if err := checkConflict(tx, &booking, h.Rules.Buffer); err != nil {
+				return err
+			}
+		}
+		if err := checkAttendees(tx, &booking, h.Rules.Buffer); err != nil {
+			return err
+		}
+		if err := tx.Omit(clause.Associations).Save(&booking).Error; err != nil {
+			return err
+		}
+		if err := setAttendees(tx, &booking); err != nil {
+			return err
+		}
+		if err := tx.Scopes(withLesson).First(&booking, booking.ID).Error; err != nil {
+			return err
+		}
+		if err := audit.RecordChange(tx, r, "booking.update", "booking", bookingID(&booking), before, booking); err != nil {
+			return err
+		}
+		if err := notify.Enqueue(tx, updateNotice(&before, &booking), &booking); err != nil {
+			return err
+		}
+		return publish(tx, models.EventBookingUpdated, booking)
+	})
+
+	var (
+		rule     ruleError
+		attendee attendeeConflictError
+	)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		httpError(w, r, "Booking not found", http.StatusNotFound)
+	case errors.Is(err, errBookingInactive):
+		httpError(w, r, "Booking has been cancelled or released", http.StatusConflict)
+	case errors.As(err, &rule):
+		httpError(w, r, rule.Error(), http.StatusBadRequest)
+	case errors.As(err, &attendee):
+		httpError(w, r, attendee.Error(), http.StatusConflict)
+	case errors.Is(err, errBookingConflict):
+		metrics.BookingConflicts.WithLabelValues(spaceLabel(booking.SpaceID)).Inc()
+		httpError(w, r, "Booking conflicts with existing reservation", http.StatusConflict)
+	case err != nil:
+		serverError(w, r, "Error updating booking", err)
+	default:
+		json.NewEncoder(w).Encode(booking)
+	}
+}
+
+// updateNotice returns the event people are notified of when a booking
+// changes from before to after. A booking moved into a space that needs
+// approval is news to the admins, as a new one would be, and one moved
+// out of such a space is confirmed to the people it is for.
+func updateNotice(before, after *models.Booking) string {
+	switch {
+	case after.Status == models.StatusPending && before.Status != models.StatusPending:
+		return models.EventBookingCreated
+	case after.Status == models.StatusConfirmed && before.Status == models.StatusPending:
+		return models.EventBookingApproved
+	}
+	return models.EventBookingUpdated
+}
+
+// CancelBooking marks a booking as cancelled. Cancelling twice, or
+// cancelling a booking already released as a no-show, changes nothing and
+// notifies no one.
+func (h *BookingHandler) CancelBooking(w http.ResponseWriter, r *http.Request) {
+	var (
+		booking   models.Booking
+		cancelled bool
+	)
+	err := h.DB.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		// Lock the booking, so an approval, release or second cancel
+		// running meanwhile waits and then sees it cancelled
+		if err := tx.Clauses(forUpdate).Scopes(withLesson).First(&booking, pathID(r)).Error; err != nil {
+			return err
+		}
+		if slices.Contains(models.InactiveStatuses, booking.Status) {
+			return nil
+		}
+		before := booking
+		booking.Status = models.StatusCancelled
+		if err := tx.Model(&booking).Omit(clause.Associations).Update("status", booking.Status).Error; err != nil {
+			return err
+		}
+		if err := audit.RecordChange(tx, r, "booking.cancel", "booking", bookingID(&booking), before, booking); err != nil {
+			return err
+		}
+		if err := notify.Enqueue(tx, models.EventBookingCancelled, &booking); err != nil {
+			return err
+		}
+		cancelled = true
+		return publish(tx, models.EventBookingCancelled, booking)
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		httpError(w, r, "Booking not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		serverError(w, r, "Error cancelling booking", err)
+		return
+	}
+	if cancelled {
+		metrics.BookingsCancelled.WithLabelValues(spaceLabel(booking.SpaceID), booking.Priority.String()).Inc()
+	}
+
+	json.NewEncoder(w).Encode(booking)
+}
+
+// approvalStatus returns the status a new booking of spaceID starts in:
+// pending if the space requires approval and the caller isn't an admin
+func approvalStatus(tx *gorm.DB, r *http.Request, spaceID int64) (string, error) {
+	if caller, _ := auth.FromContext(r.Context()); caller.IsAdmin() {
+		return models.StatusConfirmed, nil
+	}
+	var space models.Space
+	if err := tx.Select("requires_approval").Find(&space, spaceID).Error; err != nil {
+		return "", err
+	}
+	if space.RequiresApproval {
+		return models.StatusPending, nil
+	}
+	return models.StatusConfirmed, nil
+}
+
+// ApproveBooking confirms a booking that was pending approval. Admins
+// decline one by cancelling it.
+func (h *BookingHandler) ApproveBooking(w http.ResponseWriter, r *http.Request) {
+	var booking models.Booking
+	err := h.DB.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(forUpdate).Scopes(withLesson).First(&booking, pathID(r)).Error; err != nil {
+			return err
+		}
+		if booking.Status != models.StatusPending {
+			return errNotPending
+		}
+		before := booking
+		booking.Status = models.StatusConfirmed
+		if err := tx.Model(&booking).Omit(clause.Associations).Update("status", booking.Status).Error; err != nil {
+			return err
+		}
+		if err := audit.RecordChange(tx, r, "booking.approve", "booking", bookingID(&booking), before, booking); err != nil {
+			return err
+		}
+		if err := notify.Enqueue(tx, models.EventBookingApproved, &booking); err != nil {
+			return err
+		}
+		return publish(tx, models.EventBookingApproved, booking)
+	})
+
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		httpError(w, r, "Booking not found", http.StatusNotFound)
+	case errors.Is(err, errNotPending):
+		httpError(w, r, "Booking isn't awaiting approval", http.StatusConflict)
+	case err != nil:
+		serverError(w, r, "Error approving booking", err)
+	default:
+		json.NewEncoder(w).Encode(booking)
+	}
+}
+
+var (
+	errNotPending       = errors.New("booking isn't awaiting approval")
+	errBookingCancelled = errors.New("booking has been cancelled")
+	errBookingInactive  = errors.New("booking has been cancelled or released")
+)
+
+// ruleError marks a booking rule violation inside a transaction
+type ruleError struct{ error }
+
+// checkRules applies the configured booking rules to b
+func (h *BookingHandler) checkRules(b *models.Booking, now time.Time) error {
+	if b.SpaceID <= 0 {
+		return errors.New("space_id is required")
+	}
+	if !b.EndTime.After(b.StartTime) {
+		return errors.New("end time must be after start time")
+	}
+	if b.Priority != 0 && !b.Priority.Valid() {
+		return fmt.Errorf("unknown priority level %d", b.Priority)
+	}
+
+	length := b.EndTime.Sub(b.StartTime)
+	if length < h.Rules.MinDuration {
+		return fmt.Errorf("bookings must last at least %s", h.Rules.MinDuration)
+	}
+	if length > h.Rules.MaxDuration {
+		return fmt.Errorf("bookings must not last longer than %s", h.Rules.MaxDuration)
+	}
+	if b.StartTime.Before(now) {
+		return errors.New("bookings cannot start in the past")
+	}
+	if b.StartTime.After(now.Add(h.Rules.MaxAdvance)) {
+		return fmt.Errorf("bookings cannot be made more than %s in advance", h.Rules.MaxAdvance)
+	}
+
+	// Opening hours are wall-clock times in the configured zone
+	loc := h.Rules.Location()
+	start, end := b.StartTime.In(loc), b.EndTime.In(loc)
+	if start.YearDay() != end.YearDay() || start.Year() != end.Year() {
+		return errors.New("bookings must start and end on the same day")
+	}
+	if clock(start) < clockString(h.Rules.OpenTime) || clock(end) > clockString(h.Rules.CloseTime) {
+		return fmt.Errorf("bookings must fall between %s and %s", h.Rules.OpenTime, h.Rules.CloseTime)
+	}
+	return nil
+}
+
+// checkConflict returns errBookingConflict if b overlaps another active
+// booking of the same space, or comes within buffer of one. It takes a
+// transaction-scoped advisory lock on the space first, so two requests
+// can't both pass the check and then insert overlapping bookings.
+func checkConflict(tx *gorm.DB, b *models.Booking, buffer time.Duration) error {
+	if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", b.SpaceID).Error; err != nil {
+		return err
+	}
+
+	var count int64
+	err := tx.Model(&models.Booking{}).
+		Where("space_id = ? AND status NOT IN ? AND id <> ?", b.SpaceID, models.InactiveStatuses, b.ID).
+		Where("start_time < ? AND end_time > ?", b.EndTime.Add(buffer), b.StartTime.Add(-buffer)).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errBookingConflict
+	}
+	return nil
+}
+
+// clock returns the minutes since midnight of t
+func clock(t time.Time) int {
+	return t.Hour()*60 + t.Minute()
+}
+
+// clockString returns the minutes since midnight of an HH:MM time, which
+// the config has already validated
+func clockString(hhmm string) int {
+	t, _ := time.Parse("15:04", hhmm)
+	return clock(t)
+}
+
+// pathID returns the {id} route variable
+func pathID(r *http.Request) uint64 {
+	id, _ := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	return id
+}
+
+func spaceLabel(id int64) string {
+	return strconv.FormatInt(id, 10)
+}
+
+// bookingID formats a booking's ID for audit entries
+func bookingID(b *models.Booking) string {
+	return strconv.FormatUint(uint64(b.ID), 10)
+}
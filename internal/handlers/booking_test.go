@@ -0,0 +1,100 @@
+// internal/handlers/booking_test.go
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"skedda-goclone/internal/auth"
+	"skedda-goclone/internal/config"
+	"skedda-goclone/internal/dbtest"
+	"skedda-goclone/internal/models"
+	"skedda-goclone/internal/notify"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// bookingRequest returns a request for the booking with id, made by a
+// teacher who isn't an admin
+func bookingRequest(method, target string, id uint, body string) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r = mux.SetURLVars(r, map[string]string{"id": strconv.FormatUint(uint64(id), 10)})
+	return r.WithContext(auth.WithPrincipal(r.Context(), auth.Principal{Role: models.RoleTeacher}))
+}
+
+// queuedNotices counts the notification jobs queued for event
+func queuedNotices(t *testing.T, db *gorm.DB, event string) int64 {
+	t.Helper()
+	var n int64
+	err := db.Model(&models.Job{}).Where("kind = ? AND payload->>'event' = ?", notify.KindBooking, event).Count(&n).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// openBooking books the first of two spaces, the second of which requires
+// approval, tomorrow morning
+func openBooking(t *testing.T, db *gorm.DB) (*BookingHandler, models.Booking, models.Space) {
+	t.Helper()
+	open := models.Space{Name: "Room A"}
+	approval := models.Space{Name: "Hall", RequiresApproval: true}
+	for _, s := range []*models.Space{&open, &approval} {
+		if err := db.Create(s).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := &BookingHandler{DB: db, Rules: config.Default().Booking}
+	tomorrow := time.Now().In(h.Rules.Location()).AddDate(0, 0, 1)
+	start := time.Date(tomorrow.Year(), tomorrow.Month(), tomorrow.Day(), 10, 0, 0, 0, h.Rules.Location())
+	b := models.Booking{SpaceID: open.ID, StartTime: start, EndTime: start.Add(time.Hour), User: "Ana", Status: models.StatusConfirmed}
+	if err := db.Create(&b).Error; err != nil {
+		t.Fatal(err)
+	}
+	return h, b, approval
+}
+
+func TestMovingIntoApprovalSpaceNeedsApproval(t *testing.T) {
+	db := dbtest.Open(t).DB
+	h, b, approval := openBooking(t, db)
+
+	input, _ := json.Marshal(bookingInput{SpaceID: approval.ID, StartTime: b.StartTime, EndTime: b.EndTime, User: b.User})
+	w := httptest.NewRecorder()
+	h.UpdateBooking(w, bookingRequest(http.MethodPut, "/api/bookings/1", b.ID, string(input)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("moving the booking returned %d: %s", w.Code, w.Body)
+	}
+
+	var got models.Booking
+	if err := db.First(&got, b.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != models.StatusPending {
+		t.Errorf("booking moved into a space needing approval is %s, want %s", got.Status, models.StatusPending)
+	}
+	if n := queuedNotices(t, db, models.EventBookingApprovalRequested); n != 1 {
+		t.Errorf("%d approval requests queued, want 1", n)
+	}
+}
+
+func TestCancelTwiceNotifiesOnce(t *testing.T) {
+	db := dbtest.Open(t).DB
+	h, b, _ := openBooking(t, db)
+
+	for range 2 {
+		w := httptest.NewRecorder()
+		h.CancelBooking(w, bookingRequest(http.MethodPost, "/api/bookings/1/cancel", b.ID, ""))
+		if w.Code != http.StatusOK {
+			t.Fatalf("cancelling returned %d: %s", w.Code, w.Body)
+		}
+	}
+	if n := queuedNotices(t, db, models.EventBookingCancelled); n != 1 {
+		t.Errorf("%d cancellations queued, want 1", n)
+	}
+}
@@ -0,0 +1,290 @@
+// internal/handlers/calendar.go
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"skedda-goclone/internal/audit"
+	"skedda-goclone/internal/auth"
+	"skedda-goclone/internal/config"
+	"skedda-goclone/internal/ical"
+	"skedda-goclone/internal/models"
+	"skedda-goclone/internal/notify"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+type CalendarHandler struct {
+	DB    *gorm.DB
+	Rules config.BookingConfig
+	// PublicURL is the configured base of feed URLs, and names the server
+	// in event UIDs
+	PublicURL string
+}
+
+// feedHistory is how far back feeds list bookings
+const feedHistory = 60 * 24 * time.Hour
+
+// CreateFeed creates a feed URL for the bookings of a space, teacher or
+// student. Teachers can only create feeds of their own bookings, unless
+// they are admins. The URL is only ever shown here and on rotation.
+func (h *CalendarHandler) CreateFeed(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Kind     string `json:"kind"`
+		EntityID int64  `json:"entity_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		httpError(w, r, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	caller, _ := auth.FromContext(r.Context())
+	var entity any
+	switch input.Kind {
+	case models.FeedSpace:
+		entity = &models.Space{}
+	case models.FeedStudent:
+		entity = &models.Student{}
+	case models.FeedTeacher:
+		if input.EntityID != caller.TeacherID && !caller.IsAdmin() {
+			httpError(w, r, "You can only create feeds of your own bookings", http.StatusForbidden)
+			return
+		}
+		entity = &models.Teacher{}
+	default:
+		httpError(w, r, "kind must be space, teacher or student", http.StatusBadRequest)
+		return
+	}
+
+	token, hash := newURLToken()
+	feed := models.CalendarFeed{OwnerID: caller.TeacherID, Kind: input.Kind, EntityID: input.EntityID, TokenHash: hash}
+	err := h.DB.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(entity, input.EntityID).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&feed).Error; err != nil {
+			return err
+		}
+		return audit.RecordChange(tx, r, "calendar_feed.create", "calendar_feed", strconv.FormatInt(feed.ID, 10), nil, feed)
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		httpError(w, r, "No "+input.Kind+" with that ID", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		serverError(w, r, "Error creating feed", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]any{
+		"url":  h.feedURL(r, token),
+		"feed": feed,
+	})
+}
+
+// ListFeeds lists the caller's feeds
+func (h *CalendarHandler) ListFeeds(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+	var feeds []models.CalendarFeed
+	if err := h.DB.WithContext(r.Context()).Where("owner_id = ?", caller.TeacherID).Order("id").Find(&feeds).Error; err != nil {
+		serverError(w, r, "Error fetching feeds", err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(feeds)
+}
+
+// RotateFeed replaces a feed's token, so the old URL stops working, and
+// returns the new URL
+func (h *CalendarHandler) RotateFeed(w http.ResponseWriter, r *http.Request) {
+	token, hash := newURLToken()
+	feed, err := h.changeFeed(r, "calendar_feed.rotate", func(tx *gorm.DB, feed *models.CalendarFeed) error {
+		now := time.Now()
+		feed.TokenHash = hash
+		feed.RotatedAt = &now
+		return tx.Model(feed).Updates(map[string]any{"token_hash": hash, "rotated_at": now}).Error
+	})
+	if h.feedError(w, r, err) {
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"url":  h.feedURL(r, token),
+		"feed": feed,
+	})
+}
+
+// DeleteFeed removes a feed, so its URL stops working
+func (h *CalendarHandler) DeleteFeed(w http.ResponseWriter, r *http.Request) {
+	_, err := h.changeFeed(r, "calendar_feed.delete", func(tx *gorm.DB, feed *models.CalendarFeed) error {
+		return tx.Delete(feed).Error
+	})
+	if h.feedError(w, r, err) {
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "Feed deleted"})
+}
+
+// changeFeed applies change to the caller's feed named in the path, or
+// any feed for admins, and audits it
+func (h *CalendarHandler) changeFeed(r *http.Request, action string, change func(*gorm.DB, *models.CalendarFeed) error) (*models.CalendarFeed, error) {
+	caller, _ := auth.FromContext(r.Context())
+	var feed models.CalendarFeed
+	err := h.DB.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&feed, pathID(r)).Error; err != nil {
+			return err
+		}
+		if feed.OwnerID != caller.TeacherID && !caller.IsAdmin() {
+			return gorm.ErrRecordNotFound
+		}
+		if err := change(tx, &feed); err != nil {
+			return err
+		}
+		return audit.Record(tx, r, action, "calendar_feed", strconv.FormatInt(feed.ID, 10), nil)
+	})
+	return &feed, err
+}
+
+// feedError responds to an error from changeFeed, reporting whether there was one
+func (h *CalendarHandler) feedError(w http.ResponseWriter, r *http.Request, err error) bool {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		httpError(w, r, "Feed not found", http.StatusNotFound)
+	case err != nil:
+		serverError(w, r, "Error updating feed", err)
+	default:
+		return false
+	}
+	return true
+}
+
+// ServeFeed serves a feed as iCalendar. It is public: the token in the
+// URL is the credential. Feeds leave out notes and priority levels, since
+// calendar services fetching the URL keep copies of what they get.
+func (h *CalendarHandler) ServeFeed(w http.ResponseWriter, r *http.Request) {
+	var feed models.CalendarFeed
+	err := h.DB.WithContext(r.Context()).Where("token_hash = ?", hashURLToken(mux.Vars(r)["token"])).First(&feed).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		serverError(w, r, "Error fetching feed", err)
+		return
+	}
+
+	cal, err := h.feedCalendar(r, &feed)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		serverError(w, r, "Error building feed", err)
+		return
+	}
+
+	body := cal.Marshal()
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	if match := r.Header.Get("If-None-Match"); match == "*" || strings.Contains(match, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Write(body)
+}
+
+// feedCalendar builds the calendar for feed from the bookings it covers
+func (h *CalendarHandler) feedCalendar(r *http.Request, feed *models.CalendarFeed) (*ical.Calendar, error) {
+	db := h.DB.WithContext(r.Context())
+	now := time.Now()
+	query := db.Scopes(withLesson).Where("end_time > ? AND start_time < ?", now.Add(-feedHistory), now.Add(h.Rules.MaxAdvance)).Order("start_time")
+
+	var name string
+	switch feed.Kind {
+	case models.FeedSpace:
+		var space models.Space
+		if err := db.First(&space, feed.EntityID).Error; err != nil {
+			return nil, err
+		}
+		name = space.Name
+		query = query.Where("space_id = ?", space.ID)
+	case models.FeedTeacher:
+		var teacher models.Teacher
+		if err := db.First(&teacher, feed.EntityID).Error; err != nil {
+			return nil, err
+		}
+		name = teacher.Name
+		query = query.Scopes(teacherBookings(&teacher))
+	case models.FeedStudent:
+		var student models.Student
+		if err := db.First(&student, feed.EntityID).Error; err != nil {
+			return nil, err
+		}
+		name = student.Name
+		query = query.Scopes(studentBookings(&student))
+	default:
+		return nil, fmt.Errorf("unknown feed kind %q", feed.Kind)
+	}
+
+	var bookings []models.Booking
+	if err := query.Find(&bookings).Error; err != nil {
+		return nil, err
+	}
+	var spaces []models.Space
+	if err := db.Find(&spaces).Error; err != nil {
+		return nil, err
+	}
+	spaceNames := make(map[int64]string, len(spaces))
+	for _, s := range spaces {
+		spaceNames[s.ID] = s.Name
+	}
+
+	cal := &ical.Calendar{Name: name + " bookings", TimeZone: h.Rules.Location().String()}
+	for _, b := range bookings {
+		cal.Events = append(cal.Events, notify.BookingEvent(notify.UIDHost(h.PublicURL), &b, spaceNames[b.SpaceID]))
+	}
+	return cal, nil
+}
+
+func (h *CalendarHandler) feedURL(r *http.Request, token string) string {
+	return baseURL(r, h.PublicURL) + "/calendar/" + token + ".ics"
+}
+
+// newURLToken returns a token for use in a URL, such as a calendar feed
+// or a check-in code, and the hash it is stored as
+func newURLToken() (token, hash string) {
+	token = randomToken()
+	return token, hashURLToken(token)
+}
+
+func hashURLToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// baseURL returns the configured public URL of the server, or the one
+// the client used to reach it
+func baseURL(r *http.Request, configured string) string {
+	if configured != "" {
+		return strings.TrimSuffix(configured, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
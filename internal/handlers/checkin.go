@@ -0,0 +1,216 @@
+// internal/handlers/checkin.go
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"skedda-goclone/internal/audit"
+	"skedda-goclone/internal/config"
+	"skedda-goclone/internal/models"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// checkInOpens is how long before a booking starts it can be checked in to
+const checkInOpens = 15 * time.Minute
+
+var (
+	errCheckInClosed  = errors.New("check-in is only open from shortly before the booking starts until its check-in window ends")
+	errNothingToClaim = errors.New("no booking in this space is open for check-in")
+)
+
+// checkInOpen reports whether b can be checked in to at now, given the
+// check-in window of its space in minutes
+func checkInOpen(b *models.Booking, window int, now time.Time) bool {
+	if now.Before(b.StartTime.Add(-checkInOpens)) || !now.Before(b.EndTime) {
+		return false
+	}
+	return window == 0 || !now.After(b.StartTime.Add(time.Duration(window)*time.Minute))
+}
+
+// checkIn claims b. Checking in twice is harmless.
+func checkIn(tx *gorm.DB, r *http.Request, b *models.Booking, now time.Time) error {
+	if b.CheckedInAt != nil {
+		return nil
+	}
+	before := *b
+	b.CheckedInAt = &now
+	if err := tx.Model(b).UpdateColumn("checked_in_at", now).Error; err != nil {
+		return err
+	}
+	if err := audit.RecordChange(tx, r, "booking.check_in", "booking", bookingID(b), before, *b); err != nil {
+		return err
+	}
+	return publish(tx, models.EventBookingUpdated, *b)
+}
+
+// CheckIn claims a booking on behalf of whoever is at the space, so it
+// isn't released as a no-show
+func (h *BookingHandler) CheckIn(w http.ResponseWriter, r *http.Request) {
+	var booking models.Booking
+	err := h.DB.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		// Lock the booking, so it can't be released or cancelled between
+		// checking its status and checking in
+		if err := tx.Clauses(forUpdate).First(&booking, pathID(r)).Error; err != nil {
+			return err
+		}
+		if booking.Status != models.StatusConfirmed {
+			return errBookingInactive
+		}
+		var space models.Space
+		if err := tx.Select("check_in_minutes").Find(&space, booking.SpaceID).Error; err != nil {
+			return err
+		}
+		now := time.Now()
+		if !checkInOpen(&booking, space.CheckInMinutes, now) {
+			return errCheckInClosed
+		}
+		return checkIn(tx, r, &booking, now)
+	})
+
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		httpError(w, r, "Booking not found", http.StatusNotFound)
+	case errors.Is(err, errBookingInactive):
+		httpError(w, r, "Booking has been cancelled or released", http.StatusConflict)
+	case errors.Is(err, errCheckInClosed):
+		httpError(w, r, errCheckInClosed.Error(), http.StatusConflict)
+	case err != nil:
+		serverError(w, r, "Error checking in", err)
+	default:
+		json.NewEncoder(w).Encode(booking)
+	}
+}
+
+// CheckInHandler serves the check-in page a space's QR code links to.
+// Holding the code is the only credential, so it can only claim the
+// booking currently due in that space.
+type CheckInHandler struct {
+	DB    *gorm.DB
+	Rules config.BookingConfig
+}
+
+var checkInPage = template.Must(template.New("check-in").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Check in: {{.Space}}</title></head>
+<body>
+<h1>{{.Space}}</h1>
+{{if .Message}}<p>{{.Message}}</p>{{end}}
+{{if .Booking}}<p>{{.Booking}}</p>{{end}}
+{{if .CanCheckIn}}<form method="post"><button type="submit">Check in</button></form>{{end}}
+</body></html>
+`))
+
+type checkInView struct {
+	Space      string
+	Message    string
+	Booking    string
+	CanCheckIn bool
+}
+
+// ShowCheckIn shows the booking that scanning the code would claim.
+// Scanning only reads; the page's button does the check-in, so link
+// previews and prefetching can't claim a booking.
+func (h *CheckInHandler) ShowCheckIn(w http.ResponseWriter, r *http.Request) {
+	h.serve(w, r, false)
+}
+
+// ClaimCheckIn checks in to the booking currently due in the code's space
+func (h *CheckInHandler) ClaimCheckIn(w http.ResponseWriter, r *http.Request) {
+	h.serve(w, r, true)
+}
+
+func (h *CheckInHandler) serve(w http.ResponseWriter, r *http.Request, claim bool) {
+	var (
+		space   models.Space
+		booking models.Booking
+	)
+	now := time.Now()
+	err := h.DB.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("check_in_token_hash = ?", hashURLToken(mux.Vars(r)["token"])).First(&space).Error
+		if err != nil {
+			return err
+		}
+		// A booking still running can overlap the next one's early check-in
+		var due []models.Booking
+		query := tx
+		if claim {
+			query = query.Clauses(forUpdate)
+		}
+		err = query.Where("space_id = ? AND status = ?", space.ID, models.StatusConfirmed).
+			Where("start_time <= ? AND end_time > ?", now.Add(checkInOpens), now).
+			Order("start_time").Find(&due).Error
+		if err != nil {
+			return err
+		}
+		i := slices.IndexFunc(due, func(b models.Booking) bool { return checkInOpen(&b, space.CheckInMinutes, now) })
+		if i < 0 {
+			return errNothingToClaim
+		}
+		booking = due[i]
+		if !claim {
+			return nil
+		}
+		return checkIn(tx, r, &booking, now)
+	})
+
+	loc := h.Rules.Location()
+	view := checkInView{Space: space.Name}
+	if booking.ID != 0 {
+		view.Booking = fmt.Sprintf("%s, %s–%s", booking.User,
+			booking.StartTime.In(loc).Format("15:04"), booking.EndTime.In(loc).Format("15:04"))
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		httpError(w, r, "Unknown check-in code", http.StatusNotFound)
+		return
+	case errors.Is(err, errNothingToClaim):
+		view.Message = "There is no booking to check in to right now."
+	case err != nil:
+		serverError(w, r, "Error checking in", err)
+		return
+	case booking.CheckedInAt != nil:
+		view.Message = "Checked in at " + booking.CheckedInAt.In(loc).Format("15:04") + "."
+	default:
+		view.CanCheckIn = true
+	}
+	checkInPage.Execute(w, view)
+}
+
+// RotateCheckInCode issues a new check-in QR code for a space, replacing
+// any earlier one, so the printed code stops working. The token is only
+// shown this once. Only admins may rotate codes.
+func (h *SpaceHandler) RotateCheckInCode(w http.ResponseWriter, r *http.Request) {
+	token, hash := newURLToken()
+	var space models.Space
+	err := h.DB.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&space, pathID(r)).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&space).UpdateColumn("check_in_token_hash", hash).Error; err != nil {
+			return err
+		}
+		return audit.Record(tx, r, "space.check_in_code", "space", strconv.FormatInt(space.ID, 10), nil)
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		httpError(w, r, "Space not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		serverError(w, r, "Error creating check-in code", err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"token": token,
+		"url":   baseURL(r, h.PublicURL) + "/check-in/" + token,
+	})
+}
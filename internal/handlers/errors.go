@@ -0,0 +1,33 @@
+// internal/handlers/errors.go
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"skedda-goclone/internal/logging"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// httpError writes an error response like http.Error, tagged with the
+// request ID so a report from a user can be matched to the server logs
+func httpError(w http.ResponseWriter, r *http.Request, message string, code int) {
+	if id := logging.RequestID(r.Context()); id != "" {
+		message = fmt.Sprintf("%s (request ID %s)", message, id)
+	}
+	http.Error(w, message, code)
+}
+
+// serverError logs err and responds with a 500 carrying message
+func serverError(w http.ResponseWriter, r *http.Request, message string, err error) {
+	logging.FromContext(r.Context()).Error(message, "error", err)
+	httpError(w, r, message, http.StatusInternalServerError)
+}
+
+// isDuplicate reports whether err is a unique constraint violation, such as
+// a second space with the same name
+func isDuplicate(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
@@ -0,0 +1,141 @@
+// internal/handlers/events.go
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"skedda-goclone/internal/auth"
+	"skedda-goclone/internal/events"
+	"skedda-goclone/internal/logging"
+	"skedda-goclone/internal/models"
+	"skedda-goclone/internal/webhook"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// publish announces a change to webhook subscribers and live clients.
+// Pass the transaction that made the change.
+func publish(tx *gorm.DB, event string, data any) error {
+	if err := webhook.Enqueue(tx, event, data); err != nil {
+		return err
+	}
+	return events.Publish(tx, event, data)
+}
+
+type EventsHandler struct {
+	DB  *gorm.DB
+	Hub *events.Hub
+}
+
+// Settings for the event stream
+const (
+	// heartbeatInterval keeps idle streams from being closed by proxies
+	heartbeatInterval = 25 * time.Second
+	// catchUpPage is how many missed events are read at a time on resume
+	catchUpPage = 500
+	// retryMillis tells browsers how soon to reconnect
+	retryMillis = 3000
+)
+
+// StreamEvents sends changes as Server-Sent Events as they happen. Clients
+// only get events for resources they can read. A client that reconnects
+// with the Last-Event-ID header, or a last_event_id parameter, first gets
+// the events it missed.
+func (h *EventsHandler) StreamEvents(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+	var since int64
+	if lastID != "" {
+		var err error
+		if since, err = strconv.ParseInt(lastID, 10, 64); err != nil {
+			httpError(w, r, "Invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Streams outlive the server's write timeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		serverError(w, r, "Streaming is not supported", err)
+		return
+	}
+
+	// Subscribe before catching up, so nothing published in between is lost
+	live, unsubscribe := h.Hub.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", retryMillis)
+
+	// Catching up overlaps the live events, and resuming repeats the last
+	// few the client saw in case one committed out of order, so each event
+	// is only sent once. Clients ignore IDs they already have.
+	var recent events.Recent
+	send := func(event models.Event) error {
+		if !recent.Add(event) || !caller.HasScope(event.Resource+":read") {
+			return nil
+		}
+		_, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Data)
+		return err
+	}
+
+	if lastID != "" {
+		from, err := events.ResumePoint(r.Context(), h.DB, since)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("reading missed events", "error", err)
+			return
+		}
+		for {
+			missed, err := events.Since(r.Context(), h.DB, from, catchUpPage)
+			if err != nil {
+				logging.FromContext(r.Context()).Error("reading missed events", "error", err)
+				return
+			}
+			for _, event := range missed {
+				if err := send(event); err != nil {
+					return
+				}
+				from = event.ID
+			}
+			if len(missed) < catchUpPage {
+				break
+			}
+		}
+	}
+	if rc.Flush() != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-live:
+			if !ok {
+				// Too far behind; the client resumes from its last event
+				return
+			}
+			if send(event) != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+		if rc.Flush() != nil {
+			return
+		}
+	}
+}
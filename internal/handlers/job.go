@@ -0,0 +1,65 @@
+// internal/handlers/job.go
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"skedda-goclone/internal/models"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// JobHandler lets admins see and requeue background jobs
+type JobHandler struct {
+	DB *gorm.DB
+}
+
+// ListJobs returns background jobs, newest first, optionally limited to
+// one kind or status
+func (h *JobHandler) ListJobs(w http.ResponseWriter, r *http.Request) {
+	query := h.DB.WithContext(r.Context()).Order("id DESC").Limit(defaultAuditLimit)
+	if v := r.URL.Query().Get("kind"); v != "" {
+		query = query.Where("kind = ?", v)
+	}
+	if v := r.URL.Query().Get("status"); v != "" {
+		query = query.Where("status = ?", v)
+	}
+	if v := r.URL.Query().Get("before_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			httpError(w, r, "Invalid before_id", http.StatusBadRequest)
+			return
+		}
+		query = query.Where("id < ?", id)
+	}
+
+	var jobs []models.Job
+	if err := query.Find(&jobs).Error; err != nil {
+		serverError(w, r, "Error fetching jobs", err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(jobs)
+}
+
+// RetryJob requeues a job that was given up on with a fresh set of
+// attempts
+func (h *JobHandler) RetryJob(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	result := h.DB.WithContext(r.Context()).Model(&models.Job{}).
+		Where("id = ? AND status = ?", id, models.JobDead).
+		Updates(map[string]any{"status": models.JobPending, "attempts": 0, "run_at": time.Now(), "finished_at": nil})
+	if result.Error != nil {
+		serverError(w, r, "Error requeueing job", result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		httpError(w, r, "No dead job with that ID", http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "Job requeued"})
+}
@@ -0,0 +1,22 @@
+// internal/handlers/job_test.go
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"skedda-goclone/internal/dbtest"
+)
+
+func TestListJobsRejectsBadCursor(t *testing.T) {
+	h := &JobHandler{DB: dbtest.Open(t).DB}
+	for cursor, want := range map[string]int{"abc": http.StatusBadRequest, "1; DROP": http.StatusBadRequest, "10": http.StatusOK} {
+		w := httptest.NewRecorder()
+		h.ListJobs(w, httptest.NewRequest(http.MethodGet, "/api/jobs?before_id="+url.QueryEscape(cursor), nil))
+		if w.Code != want {
+			t.Errorf("before_id=%q returned %d, want %d", cursor, w.Code, want)
+		}
+	}
+}
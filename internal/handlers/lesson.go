@@ -0,0 +1,177 @@
+// internal/handlers/lesson.go
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"skedda-goclone/internal/models"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// defaultScheduleSpan is how far ahead a teacher's schedule runs when no
+// end is given
+const defaultScheduleSpan = 7 * 24 * time.Hour
+
+// withLesson preloads who and what a booking is for. Only the public
+// details of the teacher are loaded.
+func withLesson(db *gorm.DB) *gorm.DB {
+	return db.
+		Preload("Teacher", func(db *gorm.DB) *gorm.DB { return db.Select("id", "name", "email") }).
+		Preload("Subject").
+		Preload("Attendees", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		Preload("Attendees.Student").
+		Preload("Attendees.Teacher", func(db *gorm.DB) *gorm.DB { return db.Select("id", "name", "email") })
+}
+
+// forUpdate locks the bookings a query reads until its transaction ends
+var forUpdate = clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "bookings"}}
+
+// linkLesson points b at the teacher and subject in input and lists its
+// students and other attendees, returning a ruleError if any of them
+// don't exist. Attendees already on b keep their RSVP unless input sets
+// one. A lesson without a user gets its students' names there, for
+// clients that only show User.
+func linkLesson(tx *gorm.DB, b *models.Booking, input *bookingInput) error {
+	b.TeacherID, b.Teacher = input.TeacherID, nil
+	b.SubjectID, b.Subject = input.SubjectID, nil
+	if b.TeacherID != nil {
+		if err := mustExist(tx, &models.Teacher{}, *b.TeacherID, "teacher"); err != nil {
+			return err
+		}
+	}
+	if b.SubjectID != nil {
+		if err := mustExist(tx, &models.Subject{}, *b.SubjectID, "subject"); err != nil {
+			return err
+		}
+	}
+
+	inputs := make([]attendeeInput, 0, len(input.StudentIDs)+len(input.Attendees))
+	for _, id := range input.StudentIDs {
+		inputs = append(inputs, attendeeInput{StudentID: &id})
+	}
+	inputs = append(inputs, input.Attendees...)
+
+	current := b.Attendees
+	b.Attendees = nil
+	for _, in := range inputs {
+		a, err := in.attendee()
+		if err != nil {
+			return ruleError{err}
+		}
+		if i := slices.IndexFunc(current, func(c models.Attendee) bool { return sameAttendee(&c, &a) }); i >= 0 {
+			rsvp := a.RSVP
+			a = current[i]
+			a.Student, a.Teacher = nil, nil
+			if in.RSVP != "" && a.RSVP != rsvp {
+				now := time.Now()
+				a.RSVP, a.RespondedAt = rsvp, &now
+			}
+		}
+		b.Attendees = addAttendee(b.Attendees, a, in.RSVP != "")
+	}
+	if err := checkAttendeeLinks(tx, b.Attendees); err != nil {
+		return err
+	}
+
+	if b.User == "" {
+		var ids []int64
+		for _, a := range b.Attendees {
+			if a.StudentID != nil {
+				ids = append(ids, *a.StudentID)
+			}
+		}
+		var names []string
+		if len(ids) > 0 {
+			if err := tx.Model(&models.Student{}).Where("id IN ?", ids).Order("name").Pluck("name", &names).Error; err != nil {
+				return err
+			}
+		}
+		b.User = strings.Join(names, ", ")
+	}
+	return nil
+}
+
+func mustExist(tx *gorm.DB, model any, id int64, name string) error {
+	var count int64
+	if err := tx.Model(model).Where("id = ?", id).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return ruleError{fmt.Errorf("%s %d not found", name, id)}
+	}
+	return nil
+}
+
+// StudentLessons lists a student's upcoming lessons that haven't been
+// cancelled or released
+func (h *BookingHandler) StudentLessons(w http.ResponseWriter, r *http.Request) {
+	db := h.DB.WithContext(r.Context())
+	var student models.Student
+	if err := db.First(&student, pathID(r)).Error; err != nil {
+		ownerError(w, r, models.OwnerStudent, err)
+		return
+	}
+
+	var bookings []models.Booking
+	err := db.Scopes(withLesson, studentBookings(&student)).
+		Where("end_time > ? AND status NOT IN ?", time.Now(), models.InactiveStatuses).
+		Order("start_time").Find(&bookings).Error
+	if err != nil {
+		serverError(w, r, "Error fetching lessons", err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(bookings)
+}
+
+// TeacherSchedule lists a teacher's lessons ending after `from` (default
+// now) and starting before `to` (default a week after from). Cancelled
+// and released lessons are left out unless cancelled=true.
+func (h *BookingHandler) TeacherSchedule(w http.ResponseWriter, r *http.Request) {
+	db := h.DB.WithContext(r.Context())
+	var teacher models.Teacher
+	if err := db.First(&teacher, pathID(r)).Error; err != nil {
+		ownerError(w, r, models.OwnerTeacher, err)
+		return
+	}
+
+	from := time.Now()
+	if v := r.URL.Query().Get("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			httpError(w, r, "Invalid from time", http.StatusBadRequest)
+			return
+		}
+		from = t
+	}
+	to := from.Add(defaultScheduleSpan)
+	if v := r.URL.Query().Get("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			httpError(w, r, "Invalid to time", http.StatusBadRequest)
+			return
+		}
+		to = t
+	}
+
+	query := db.Scopes(withLesson, teacherBookings(&teacher)).
+		Where("end_time > ? AND start_time < ?", from, to).
+		Order("start_time")
+	if r.URL.Query().Get("cancelled") != "true" {
+		query = query.Where("status NOT IN ?", models.InactiveStatuses)
+	}
+
+	var bookings []models.Booking
+	if err := query.Find(&bookings).Error; err != nil {
+		serverError(w, r, "Error fetching schedule", err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(bookings)
+}
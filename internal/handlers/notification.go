@@ -0,0 +1,191 @@
+// internal/handlers/notification.go
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"skedda-goclone/internal/audit"
+	"skedda-goclone/internal/auth"
+	"skedda-goclone/internal/models"
+	"skedda-goclone/internal/notify"
+	"slices"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// NotificationHandler manages how teachers and students are notified of
+// their bookings
+type NotificationHandler struct {
+	DB *gorm.DB
+}
+
+// preferenceInput is a notification preference as sent by a client
+type preferenceInput struct {
+	Channels   []string `json:"channels"`
+	Events     []string `json:"events"`
+	QuietStart string   `json:"quiet_start"`
+	QuietEnd   string   `json:"quiet_end"`
+	Delivery   string   `json:"delivery"`
+	DigestTime string   `json:"digest_time"`
+}
+
+func (in *preferenceInput) validate() error {
+	for _, c := range in.Channels {
+		if !slices.Contains(models.NotificationChannels, c) {
+			return fmt.Errorf("unknown channel %q", c)
+		}
+	}
+	for _, e := range in.Events {
+		if !slices.Contains(notify.Events, e) {
+			return fmt.Errorf("%q is not an event people are notified of", e)
+		}
+	}
+	if (in.QuietStart == "") != (in.QuietEnd == "") {
+		return errors.New("quiet_start and quiet_end must be set together")
+	}
+	for _, t := range []string{in.QuietStart, in.QuietEnd} {
+		if _, err := time.Parse("15:04", t); t != "" && err != nil {
+			return fmt.Errorf("quiet hours %q must be HH:MM", t)
+		}
+	}
+	switch in.Delivery {
+	case "":
+		in.Delivery = models.DeliveryImmediate
+	case models.DeliveryImmediate, models.DeliveryDigest:
+	default:
+		return fmt.Errorf("delivery must be %s or %s", models.DeliveryImmediate, models.DeliveryDigest)
+	}
+	if in.DigestTime == "" {
+		in.DigestTime = models.DefaultDigestTime
+	}
+	if _, err := time.Parse("15:04", in.DigestTime); err != nil {
+		return fmt.Errorf("digest_time %q must be HH:MM", in.DigestTime)
+	}
+	return nil
+}
+
+// GetTeacherPreferences returns how a teacher is notified
+func (h *NotificationHandler) GetTeacherPreferences(w http.ResponseWriter, r *http.Request) {
+	h.getPreference(w, r, models.OwnerTeacher, &models.Teacher{})
+}
+
+// SetTeacherPreferences changes how a teacher is notified. Teachers can
+// change their own, and admins anyone's.
+func (h *NotificationHandler) SetTeacherPreferences(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+	if int64(pathID(r)) != caller.TeacherID && !caller.IsAdmin() {
+		httpError(w, r, "You can only change your own notifications", http.StatusForbidden)
+		return
+	}
+	h.setPreference(w, r, models.OwnerTeacher, &models.Teacher{})
+}
+
+// GetStudentPreferences returns how a student is notified
+func (h *NotificationHandler) GetStudentPreferences(w http.ResponseWriter, r *http.Request) {
+	h.getPreference(w, r, models.OwnerStudent, &models.Student{})
+}
+
+// SetStudentPreferences changes how a student is notified. Admins can
+// change anyone's, and teachers those of students they teach.
+func (h *NotificationHandler) SetStudentPreferences(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+	if !caller.IsAdmin() {
+		teaches, err := teachesStudent(h.DB.WithContext(r.Context()), caller.TeacherID, int64(pathID(r)))
+		if err != nil {
+			serverError(w, r, "Error checking who teaches the student", err)
+			return
+		}
+		if !teaches {
+			httpError(w, r, "You can only change the notifications of students you teach", http.StatusForbidden)
+			return
+		}
+	}
+	h.setPreference(w, r, models.OwnerStudent, &models.Student{})
+}
+
+// teachesStudent reports whether the teacher takes, or took, a booking the
+// student attends
+func teachesStudent(db *gorm.DB, teacherID, studentID int64) (bool, error) {
+	if teacherID == 0 {
+		return false, nil
+	}
+	var n int64
+	err := db.Table("attendees").
+		Joins("JOIN bookings ON bookings.id = attendees.booking_id AND bookings.deleted_at IS NULL").
+		Where("attendees.student_id = ? AND bookings.teacher_id = ?", studentID, teacherID).
+		Limit(1).Count(&n).Error
+	return n > 0, err
+}
+
+// getPreference responds with the preference of the owner in the path,
+// which is loaded into owner to check it exists
+func (h *NotificationHandler) getPreference(w http.ResponseWriter, r *http.Request, ownerType string, owner any) {
+	db := h.DB.WithContext(r.Context())
+	if err := db.First(owner, pathID(r)).Error; err != nil {
+		ownerError(w, r, ownerType, err)
+		return
+	}
+	pref, err := notify.LoadPreference(db, ownerType, int64(pathID(r)))
+	if err != nil {
+		serverError(w, r, "Error fetching notification preferences", err)
+		return
+	}
+	json.NewEncoder(w).Encode(pref)
+}
+
+func (h *NotificationHandler) setPreference(w http.ResponseWriter, r *http.Request, ownerType string, owner any) {
+	var input preferenceInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		httpError(w, r, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if err := input.validate(); err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ownerID := int64(pathID(r))
+	var pref models.NotificationPreference
+	err := h.DB.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(owner, ownerID).Error; err != nil {
+			return err
+		}
+		before, err := notify.LoadPreference(tx, ownerType, ownerID)
+		if err != nil {
+			return err
+		}
+		pref = models.NotificationPreference{
+			OwnerType:  ownerType,
+			OwnerID:    ownerID,
+			Channels:   slices.Compact(slices.Sorted(slices.Values(input.Channels))),
+			Events:     slices.Compact(slices.Sorted(slices.Values(input.Events))),
+			QuietStart: input.QuietStart,
+			QuietEnd:   input.QuietEnd,
+			Delivery:   input.Delivery,
+			DigestTime: input.DigestTime,
+		}
+		err = tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "owner_type"}, {Name: "owner_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"updated_at", "channels", "events", "quiet_start", "quiet_end", "delivery", "digest_time"}),
+		}).Create(&pref).Error
+		if err != nil {
+			return err
+		}
+		return audit.RecordChange(tx, r, ownerType+".notifications", ownerType, strconv.FormatInt(ownerID, 10), before, pref)
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		ownerError(w, r, ownerType, err)
+		return
+	}
+	if err != nil {
+		serverError(w, r, "Error updating notification preferences", err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(pref)
+}
@@ -0,0 +1,58 @@
+// internal/handlers/notification_test.go
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"skedda-goclone/internal/auth"
+	"skedda-goclone/internal/dbtest"
+	"skedda-goclone/internal/models"
+
+	"github.com/gorilla/mux"
+)
+
+func TestSetStudentPreferencesNeedsTeacher(t *testing.T) {
+	db := dbtest.Open(t).DB
+	teacher := models.Teacher{Name: "Ana", Email: "ana@example.com"}
+	other := models.Teacher{Name: "Ben", Email: "ben@example.com"}
+	student := models.Student{Name: "Cleo"}
+	space := models.Space{Name: "Room A"}
+	for _, v := range []any{&teacher, &other, &student, &space} {
+		if err := db.Create(v).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Now().Add(time.Hour)
+	booking := models.Booking{SpaceID: space.ID, TeacherID: &teacher.ID, StartTime: start, EndTime: start.Add(time.Hour), Status: models.StatusConfirmed}
+	if err := db.Create(&booking).Error; err != nil {
+		t.Fatal(err)
+	}
+	attendee := models.Attendee{BookingID: booking.ID, Kind: models.AttendeeStudent, StudentID: &student.ID, RSVP: models.RSVPAccepted}
+	if err := db.Create(&attendee).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	h := &NotificationHandler{DB: db}
+	for _, tc := range []struct {
+		name   string
+		caller auth.Principal
+		want   int
+	}{
+		{"teacher of the student", auth.Principal{TeacherID: teacher.ID}, http.StatusOK},
+		{"other teacher", auth.Principal{TeacherID: other.ID}, http.StatusForbidden},
+		{"admin", auth.Principal{TeacherID: other.ID, Role: models.RoleAdmin}, http.StatusOK},
+	} {
+		r := httptest.NewRequest(http.MethodPut, "/api/students/1/notifications", strings.NewReader(`{"channels":["email"]}`))
+		r = mux.SetURLVars(r, map[string]string{"id": strconv.FormatInt(student.ID, 10)})
+		w := httptest.NewRecorder()
+		h.SetStudentPreferences(w, r.WithContext(auth.WithPrincipal(r.Context(), tc.caller)))
+		if w.Code != tc.want {
+			t.Errorf("%s: got %d, want %d", tc.name, w.Code, tc.want)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+// internal/handlers/oidc.go
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"skedda-goclone/internal/audit"
+	"skedda-goclone/internal/auth"
+	"skedda-goclone/internal/logging"
+	"skedda-goclone/internal/models"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	oidcCookie     = "skedda_oidc"
+	oidcCookiePath = "/api/auth/oidc"
+	// oidcFlowTTL bounds how long a user may spend at the provider
+	oidcFlowTTL = 10 * time.Minute
+)
+
+// oidcFlow is the state of a single sign-on in progress. It is sealed into
+// a cookie, so the callback can be handled by any server instance.
+type oidcFlow struct {
+	State    string    `json:"state"`
+	Nonce    string    `json:"nonce"`
+	Verifier string    `json:"verifier"`
+	Expires  time.Time `json:"expires"`
+}
+
+// OIDCLogin starts single sign-on by sending the browser to the provider
+func (h *TeacherHandler) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if h.OIDC == nil {
+		httpError(w, r, "Single sign-on is not configured", http.StatusNotFound)
+		return
+	}
+
+	flow := oidcFlow{
+		State:    randomToken(),
+		Nonce:    randomToken(),
+		Verifier: randomToken() + randomToken(),
+		Expires:  time.Now().Add(oidcFlowTTL),
+	}
+	target, err := h.OIDC.AuthCodeURL(r.Context(), flow.State, flow.Nonce, flow.Verifier)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("starting single sign-on", "error", err)
+		httpError(w, r, "Single sign-on is unavailable", http.StatusBadGateway)
+		return
+	}
+	sealed, err := auth.Seal([]byte(h.Auth.TokenSecret), "oidc", flow)
+	if err != nil {
+		serverError(w, r, "Error starting single sign-on", err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookie,
+		Value:    sealed,
+		Path:     oidcCookiePath,
+		MaxAge:   int(oidcFlowTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil || isHTTPS(h.SSO.RedirectURL),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// OIDCCallback finishes single sign-on. The provider's verified email is
+// matched to a teacher, who is created first if just-in-time provisioning
+// is on. The login then continues as a password login would, including
+// any second factor.
+func (h *TeacherHandler) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if h.OIDC == nil {
+		httpError(w, r, "Single sign-on is not configured", http.StatusNotFound)
+		return
+	}
+
+	// The flow cookie is single use
+	http.SetCookie(w, &http.Cookie{Name: oidcCookie, Path: oidcCookiePath, MaxAge: -1})
+
+	now := time.Now()
+	var flow oidcFlow
+	cookie, err := r.Cookie(oidcCookie)
+	if err != nil || auth.Open([]byte(h.Auth.TokenSecret), "oidc", cookie.Value, &flow) != nil ||
+		now.After(flow.Expires) ||
+		subtle.ConstantTimeCompare([]byte(flow.State), []byte(r.URL.Query().Get("state"))) != 1 {
+		httpError(w, r, "Single sign-on session is invalid or has expired, please try again", http.StatusBadRequest)
+		return
+	}
+	if reason := r.URL.Query().Get("error"); reason != "" {
+		logging.FromContext(r.Context()).Warn("identity provider refused sign-on", "error", reason)
+		httpError(w, r, "Single sign-on was refused", http.StatusUnauthorized)
+		return
+	}
+
+	rawIDToken, err := h.OIDC.Exchange(r.Context(), r.URL.Query().Get("code"), flow.Verifier)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("exchanging single sign-on code", "error", err)
+		httpError(w, r, "Single sign-on failed", http.StatusBadGateway)
+		return
+	}
+	claims, err := h.OIDC.VerifyIDToken(r.Context(), rawIDToken, flow.Nonce, now)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("rejected ID token", "error", err)
+		httpError(w, r, "Single sign-on failed", http.StatusUnauthorized)
+		return
+	}
+	if claims.Email == "" || !claims.EmailVerified {
+		httpError(w, r, "Your identity provider did not confirm your email address", http.StatusForbidden)
+		return
+	}
+
+	teacher, err := h.ssoTeacher(r, claims)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		h.auditSSOFailure(r, claims.Email, "unknown_email")
+		httpError(w, r, "No account is registered for this email", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		serverError(w, r, "Error retrieving teacher", err)
+		return
+	}
+	if teacher.IsLocked(now) {
+		h.loginFailed(w, r, teacher, teacher.Email, "locked")
+		return
+	}
+
+	purpose := h.loginPurpose(teacher)
+	if h.SSO.PostLoginRedirect == "" {
+		h.issueToken(w, r, teacher, purpose, now)
+		return
+	}
+
+	token, expires, err := h.newToken(teacher, purpose, now)
+	if err != nil {
+		serverError(w, r, "Error issuing token", err)
+		return
+	}
+	fragment := url.Values{}
+	fragment.Set("token", token)
+	fragment.Set("expires_at", expires.Format(time.RFC3339))
+	fragment.Set("mfa_required", strconv.FormatBool(purpose == auth.PurposeMFA))
+	fragment.Set("totp_enrollment_required", strconv.FormatBool(purpose == auth.PurposeEnroll))
+	http.Redirect(w, r, h.SSO.PostLoginRedirect+"#"+fragment.Encode(), http.StatusFound)
+}
+
+// ssoTeacher finds the teacher with the signed-in email, provisioning one
+// when that's enabled. Emails are normalized as for password logins, so
+// both find the same account.
+func (h *TeacherHandler) ssoTeacher(r *http.Request, claims auth.IDClaims) (*models.Teacher, error) {
+	db := h.DB.WithContext(r.Context())
+	email := normalizeEmail(claims.Email)
+	var teacher models.Teacher
+	err := db.Where("LOWER(email) = ?", email).First(&teacher).Error
+	if err == nil || !errors.Is(err, gorm.ErrRecordNotFound) || !h.SSO.JITProvisioning {
+		return &teacher, err
+	}
+
+	teacher = models.Teacher{Name: claims.Name, Email: email, Role: models.RoleTeacher}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&teacher).Error; err != nil {
+			return err
+		}
+		return audit.Record(tx, r, "teacher.provision", "teacher", strconv.FormatInt(teacher.ID, 10),
+			map[string]string{"email": email, "issuer": claims.Issuer, "subject": claims.Subject})
+	})
+	return &teacher, err
+}
+
+func (h *TeacherHandler) auditSSOFailure(r *http.Request, email, reason string) {
+	if err := audit.Record(h.DB, r, "login.failed", "teacher", "", map[string]string{"email": email, "reason": reason, "method": "oidc"}); err != nil {
+		logging.FromContext(r.Context()).Error("recording failed login", "error", err)
+	}
+}
+
+// randomToken returns 32 bytes of randomness, base64url encoded
+func randomToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func isHTTPS(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	return err == nil && u.Scheme == "https"
+}
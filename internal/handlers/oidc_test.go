@@ -0,0 +1,143 @@
+// internal/handlers/oidc_test.go
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"skedda-goclone/internal/auth"
+	"skedda-goclone/internal/config"
+	"skedda-goclone/internal/dbtest"
+	"skedda-goclone/internal/mockidp"
+	"skedda-goclone/internal/models"
+
+	"gorm.io/gorm"
+)
+
+const ssoCallback = "http://app.test/api/auth/oidc/callback"
+
+// ssoHandler returns a teacher handler signing in through a mock provider
+func ssoHandler(t *testing.T, db *gorm.DB, jit bool) *TeacherHandler {
+	t.Helper()
+	srv := httptest.NewServer(nil)
+	t.Cleanup(srv.Close)
+	idp, err := mockidp.New(srv.URL+"/", "skedda", "secret",
+		mockidp.User{Subject: "1", Email: "Ada@Example.com", Name: "Ada", EmailVerified: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Config.Handler = idp
+
+	sso := config.OIDCConfig{IssuerURL: srv.URL + "/", ClientID: "skedda", ClientSecret: "secret", RedirectURL: ssoCallback, JITProvisioning: jit}
+	return &TeacherHandler{
+		DB:   db,
+		Auth: config.AuthConfig{TokenSecret: "0123456789abcdef0123456789abcdef", TokenTTL: time.Hour},
+		OIDC: &auth.OIDCProvider{
+			Issuer: sso.IssuerURL, ClientID: sso.ClientID, ClientSecret: sso.ClientSecret,
+			RedirectURL: sso.RedirectURL, Scopes: []string{"openid", "email", "profile"},
+		},
+		SSO: sso,
+	}
+}
+
+// ssoSignIn walks a browser through the provider and returns the callback
+// request it would make
+func ssoSignIn(t *testing.T, h *TeacherHandler) *http.Request {
+	t.Helper()
+	start := httptest.NewRecorder()
+	h.OIDCLogin(start, httptest.NewRequest(http.MethodGet, "/api/auth/oidc/login", nil))
+	if start.Code != http.StatusFound {
+		t.Fatalf("login returned %d: %s", start.Code, start.Body)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(start.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("provider returned %s", resp.Status)
+	}
+
+	callback := httptest.NewRequest(http.MethodGet, resp.Header.Get("Location"), nil)
+	for _, c := range start.Result().Cookies() {
+		callback.AddCookie(c)
+	}
+	return callback
+}
+
+func TestOIDCLoginProvisionsTeacher(t *testing.T) {
+	h := ssoHandler(t, dbtest.Open(t).DB, true)
+	w := httptest.NewRecorder()
+	h.OIDCCallback(w, ssoSignIn(t, h))
+	if w.Code != http.StatusOK {
+		t.Fatalf("callback returned %d: %s", w.Code, w.Body)
+	}
+
+	var body struct {
+		Token   string         `json:"token"`
+		Teacher models.Teacher `json:"teacher"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	claims, err := auth.VerifyToken([]byte(h.Auth.TokenSecret), body.Token, time.Now())
+	if err != nil || claims.TeacherID != body.Teacher.ID || claims.Purpose != "" {
+		t.Errorf("issued token %+v, %v for teacher %d", claims, err, body.Teacher.ID)
+	}
+	// Stored as password logins and registration store it
+	if body.Teacher.Email != "ada@example.com" {
+		t.Errorf("provisioned teacher with email %q, want it lower-cased", body.Teacher.Email)
+	}
+
+	// Signing in again finds the same account rather than making another
+	w = httptest.NewRecorder()
+	h.OIDCCallback(w, ssoSignIn(t, h))
+	var count int64
+	h.DB.Model(&models.Teacher{}).Count(&count)
+	if w.Code != http.StatusOK || count != 1 {
+		t.Errorf("second sign-on returned %d with %d teachers", w.Code, count)
+	}
+}
+
+func TestOIDCLoginMatchesEmailInAnyCase(t *testing.T) {
+	h := ssoHandler(t, dbtest.Open(t).DB, false)
+	teacher := models.Teacher{Name: "Ada", Email: "ada@example.com", Role: models.RoleTeacher}
+	if err := h.DB.Create(&teacher).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	h.OIDCCallback(w, ssoSignIn(t, h))
+	if w.Code != http.StatusOK {
+		t.Fatalf("callback returned %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestOIDCLoginRefusesUnknownEmail(t *testing.T) {
+	h := ssoHandler(t, dbtest.Open(t).DB, false)
+	w := httptest.NewRecorder()
+	h.OIDCCallback(w, ssoSignIn(t, h))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("callback for an unknown email returned %d", w.Code)
+	}
+}
+
+func TestOIDCCallbackChecksState(t *testing.T) {
+	// The state is checked before anything touches the database
+	h := ssoHandler(t, nil, true)
+	callback := ssoSignIn(t, h)
+	q := callback.URL.Query()
+	q.Set("state", "forged")
+	callback.URL.RawQuery = q.Encode()
+
+	w := httptest.NewRecorder()
+	h.OIDCCallback(w, callback)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("callback with a forged state returned %d", w.Code)
+	}
+}
@@ -0,0 +1,452 @@
+// internal/handlers/schedule.go
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"skedda-goclone/internal/audit"
+	"skedda-goclone/internal/auth"
+	"skedda-goclone/internal/availability"
+	"skedda-goclone/internal/metrics"
+	"skedda-goclone/internal/models"
+	"skedda-goclone/internal/notify"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// AvailabilityHandler manages the weekly hours of teachers and students
+type AvailabilityHandler struct {
+	DB *gorm.DB
+}
+
+// windowInput is one weekly window as sent by a client
+type windowInput struct {
+	Weekday   int    `json:"weekday"`
+	StartTime string `json:"start_time"`
+	EndTime   string `json:"end_time"`
+}
+
+func (in windowInput) validate() error {
+	if in.Weekday < 0 || in.Weekday > 6 {
+		return fmt.Errorf("weekday must be 0 (Sunday) to 6, not %d", in.Weekday)
+	}
+	start, err := time.Parse("15:04", in.StartTime)
+	if err != nil {
+		return fmt.Errorf("start_time %q must be HH:MM", in.StartTime)
+	}
+	end, err := time.Parse("15:04", in.EndTime)
+	if err != nil {
+		return fmt.Errorf("end_time %q must be HH:MM", in.EndTime)
+	}
+	if !end.After(start) {
+		return fmt.Errorf("window %s-%s must end after it starts", in.StartTime, in.EndTime)
+	}
+	return nil
+}
+
+// GetTeacherAvailability returns a teacher's weekly hours
+func (h *AvailabilityHandler) GetTeacherAvailability(w http.ResponseWriter, r *http.Request) {
+	h.getWindows(w, r, models.OwnerTeacher, &models.Teacher{})
+}
+
+// SetTeacherAvailability replaces a teacher's weekly hours. Teachers can
+// set their own, and admins anyone's.
+func (h *AvailabilityHandler) SetTeacherAvailability(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.FromContext(r.Context())
+	if int64(pathID(r)) != caller.TeacherID && !caller.IsAdmin() {
+		httpError(w, r, "You can only change your own availability", http.StatusForbidden)
+		return
+	}
+	h.setWindows(w, r, models.OwnerTeacher, &models.Teacher{})
+}
+
+// GetStudentAvailability returns a student's weekly hours
+func (h *AvailabilityHandler) GetStudentAvailability(w http.ResponseWriter, r *http.Request) {
+	h.getWindows(w, r, models.OwnerStudent, &models.Student{})
+}
+
+// SetStudentAvailability replaces a student's weekly hours
+func (h *AvailabilityHandler) SetStudentAvailability(w http.ResponseWriter, r *http.Request) {
+	h.setWindows(w, r, models.OwnerStudent, &models.Student{})
+}
+
+// getWindows responds with the windows of the owner in the path, which is
+// loaded into owner to check it exists
+func (h *AvailabilityHandler) getWindows(w http.ResponseWriter, r *http.Request, ownerType string, owner any) {
+	db := h.DB.WithContext(r.Context())
+	if err := db.First(owner, pathID(r)).Error; err != nil {
+		ownerError(w, r, ownerType, err)
+		return
+	}
+	windows, err := loadWindows(db, ownerType, int64(pathID(r)))
+	if err != nil {
+		serverError(w, r, "Error fetching availability", err)
+		return
+	}
+	json.NewEncoder(w).Encode(windows)
+}
+
+func (h *AvailabilityHandler) setWindows(w http.ResponseWriter, r *http.Request, ownerType string, owner any) {
+	var input []windowInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		httpError(w, r, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	for _, in := range input {
+		if err := in.validate(); err != nil {
+			httpError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	ownerID := int64(pathID(r))
+	windows := make([]models.AvailabilityWindow, len(input))
+	err := h.DB.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(owner, ownerID).Error; err != nil {
+			return err
+		}
+		before, err := loadWindows(tx, ownerType, ownerID)
+		if err != nil {
+			return err
+		}
+		if err := tx.Where("owner_type = ? AND owner_id = ?", ownerType, ownerID).Delete(&models.AvailabilityWindow{}).Error; err != nil {
+			return err
+		}
+		for i, in := range input {
+			windows[i] = models.AvailabilityWindow{OwnerType: ownerType, OwnerID: ownerID, Weekday: in.Weekday, StartTime: in.StartTime, EndTime: in.EndTime}
+		}
+		if len(windows) > 0 {
+			if err := tx.Create(&windows).Error; err != nil {
+				return err
+			}
+		}
+		// Diff compares objects, so wrap the lists
+		return audit.RecordChange(tx, r, ownerType+".availability", ownerType, strconv.FormatInt(ownerID, 10),
+			map[string]any{"windows": before}, map[string]any{"windows": windows})
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		ownerError(w, r, ownerType, err)
+		return
+	}
+	if err != nil {
+		serverError(w, r, "Error updating availability", err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(windows)
+}
+
+// ownerNames are the display names of availability owners
+var ownerNames = map[string]string{
+	models.OwnerTeacher: "Teacher",
+	models.OwnerStudent: "Student",
+}
+
+// ownerError responds to a failure loading an owner or their windows
+func ownerError(w http.ResponseWriter, r *http.Request, ownerType string, err error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		httpError(w, r, ownerNames[ownerType]+" not found", http.StatusNotFound)
+		return
+	}
+	serverError(w, r, "Error fetching availability", err)
+}
+
+func loadWindows(db *gorm.DB, ownerType string, ownerID int64) ([]models.AvailabilityWindow, error) {
+	windows := []models.AvailabilityWindow{}
+	err := db.Where("owner_type = ? AND owner_id = ?", ownerType, ownerID).
+		Order("weekday, start_time").Find(&windows).Error
+	return windows, err
+}
+
+// teacherBookings limits a booking query to the bookings a teacher leads
+// or attends. Bookings without a teacher are matched on the user field,
+// which may hold either the teacher's email or name.
+func teacherBookings(t *models.Teacher) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where(`(teacher_id = @id
+			OR bookings.id IN (SELECT booking_id FROM attendees WHERE teacher_id = @id AND rsvp <> @declined)
+			OR (teacher_id IS NULL AND LOWER("user") IN (LOWER(@email), LOWER(@name))))`,
+			sql.Named("id", t.ID), sql.Named("declined", models.RSVPDeclined), sql.Named("email", t.Email), sql.Named("name", t.Name))
+	}
+}
+
+// studentBookings limits a booking query to a student's bookings, whether
+// they are an attendee who hasn't declined or named in the user field
+func studentBookings(s *models.Student) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where(`(bookings.id IN (SELECT booking_id FROM attendees WHERE student_id = ? AND rsvp <> ?) OR LOWER("user") = LOWER(?))`,
+			s.ID, models.RSVPDeclined, s.Name)
+	}
+}
+
+// Advisory lock classes for participants. The two-key form of
+// pg_advisory_xact_lock doesn't collide with the one-key space locks.
+const (
+	lockTeacher int32 = 1
+	lockStudent int32 = 2
+)
+
+// lessonInput schedules a lesson for a teacher and a student
+type lessonInput struct {
+	TeacherID int64                `json:"teacher_id"`
+	StudentID int64                `json:"student_id"`
+	SubjectID *int64               `json:"subject_id"`
+	SpaceID   int64                `json:"space_id"`
+	StartTime time.Time            `json:"start_time"`
+	EndTime   time.Time            `json:"end_time"`
+	Notes     string               `json:"notes"`
+	Priority  models.PriorityLevel `json:"priority"`
+}
+
+// unavailableError names a participant who can't make a lesson
+type unavailableError struct{ who string }
+
+func (e unavailableError) Error() string {
+	return e.who + " is not available at that time"
+}
+
+// FindLessonSlots returns slots when a room, a teacher and a student are
+// all free. It takes the slot finder's parameters plus teacher_id and
+// student_id, at least one of which is required.
+func (h *BookingHandler) FindLessonSlots(w http.ResponseWriter, r *http.Request) {
+	req, err := h.slotRequest(r)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	teacherID, err := optionalID(r, "teacher_id")
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	studentID, err := optionalID(r, "student_id")
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if teacherID == 0 && studentID == 0 {
+		httpError(w, r, "teacher_id or student_id is required", http.StatusBadRequest)
+		return
+	}
+
+	db := h.DB.WithContext(r.Context())
+	if teacherID != 0 {
+		var teacher models.Teacher
+		if err := db.First(&teacher, teacherID).Error; err != nil {
+			ownerError(w, r, models.OwnerTeacher, err)
+			return
+		}
+		p, err := h.participant(db, models.OwnerTeacher, teacher.ID, teacherBookings(&teacher), req.From, req.To)
+		if err != nil {
+			serverError(w, r, "Error fetching teacher availability", err)
+			return
+		}
+		req.Participants = append(req.Participants, p)
+	}
+	if studentID != 0 {
+		var student models.Student
+		if err := db.First(&student, studentID).Error; err != nil {
+			ownerError(w, r, models.OwnerStudent, err)
+			return
+		}
+		p, err := h.participant(db, models.OwnerStudent, student.ID, studentBookings(&student), req.From, req.To)
+		if err != nil {
+			serverError(w, r, "Error fetching student availability", err)
+			return
+		}
+		req.Participants = append(req.Participants, p)
+	}
+
+	slots, err := h.findSlots(db, req)
+	if err != nil {
+		serverError(w, r, "Error finding slots", err)
+		return
+	}
+	json.NewEncoder(w).Encode(slots)
+}
+
+// ScheduleLesson books a lesson once the room, the teacher and the student
+// are all confirmed free. Everything is checked again under locks in one
+// transaction, so a slot taken since it was offered is refused rather
+// than double booked.
+func (h *BookingHandler) ScheduleLesson(w http.ResponseWriter, r *http.Request) {
+	var input lessonInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		httpError(w, r, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if input.TeacherID <= 0 || input.StudentID <= 0 {
+		httpError(w, r, "teacher_id and student_id are required", http.StatusBadRequest)
+		return
+	}
+
+	booking := models.Booking{
+		SpaceID:   input.SpaceID,
+		StartTime: input.StartTime,
+		EndTime:   input.EndTime,
+		Notes:     input.Notes,
+		Status:    models.StatusConfirmed,
+		Priority:  input.Priority,
+		TeacherID: &input.TeacherID,
+		SubjectID: input.SubjectID,
+	}
+	if err := h.checkRules(&booking, time.Now()); err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	lesson := availability.Interval{Start: booking.StartTime, End: booking.EndTime}
+	err := h.DB.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		var teacher models.Teacher
+		if err := tx.First(&teacher, input.TeacherID).Error; err != nil {
+			return notFound(models.OwnerTeacher, err)
+		}
+		var student models.Student
+		if err := tx.First(&student, input.StudentID).Error; err != nil {
+			return notFound(models.OwnerStudent, err)
+		}
+		if booking.SubjectID != nil {
+			if err := mustExist(tx, &models.Subject{}, *booking.SubjectID, "subject"); err != nil {
+				return err
+			}
+		}
+
+		status, err := approvalStatus(tx, r, booking.SpaceID)
+		if err != nil {
+			return err
+		}
+		booking.Status = status
+		if err := checkConflict(tx, &booking, h.Rules.Buffer); err != nil {
+			return err
+		}
+		participants := []struct {
+			lock  int32
+			owner string
+			id    int64
+			scope func(*gorm.DB) *gorm.DB
+			name  string
+		}{
+			{lockTeacher, models.OwnerTeacher, teacher.ID, teacherBookings(&teacher), teacher.Name},
+			{lockStudent, models.OwnerStudent, student.ID, studentBookings(&student), student.Name},
+		}
+		for _, p := range participants {
+			if err := tx.Exec("SELECT pg_advisory_xact_lock(?, ?)", p.lock, int32(p.id)).Error; err != nil {
+				return err
+			}
+			free, err := h.participant(tx, p.owner, p.id, p.scope, lesson.Start, lesson.End)
+			if err != nil {
+				return err
+			}
+			if !free.Available(lesson, h.Rules.Location()) {
+				return unavailableError{p.name}
+			}
+			for _, busy := range free.Busy {
+				if lesson.Overlaps(busy, h.Rules.Buffer) {
+					return unavailableError{p.name}
+				}
+			}
+		}
+
+		booking.User = student.Name
+		booking.Attendees = []models.Attendee{{Kind: models.AttendeeStudent, StudentID: &student.ID, RSVP: models.RSVPAccepted}}
+		if err := tx.Omit(clause.Associations).Create(&booking).Error; err != nil {
+			return err
+		}
+		if err := setAttendees(tx, &booking); err != nil {
+			return err
+		}
+		if err := tx.Scopes(withLesson).First(&booking, booking.ID).Error; err != nil {
+			return err
+		}
+		if err := audit.RecordChange(tx, r, "booking.create", "booking", bookingID(&booking), nil, booking); err != nil {
+			return err
+		}
+		if err := notify.Enqueue(tx, models.EventBookingCreated, &booking); err != nil {
+			return err
+		}
+		return publish(tx, models.EventBookingCreated, booking)
+	})
+
+	var (
+		missing     missingError
+		rule        ruleError
+		unavailable unavailableError
+	)
+	switch {
+	case errors.As(err, &missing):
+		httpError(w, r, missing.Error(), http.StatusNotFound)
+	case errors.As(err, &rule):
+		httpError(w, r, rule.Error(), http.StatusBadRequest)
+	case errors.Is(err, errBookingConflict):
+		metrics.BookingConflicts.WithLabelValues(spaceLabel(booking.SpaceID)).Inc()
+		httpError(w, r, "Booking conflicts with existing reservation", http.StatusConflict)
+	case errors.As(err, &unavailable):
+		httpError(w, r, unavailable.Error(), http.StatusConflict)
+	case err != nil:
+		serverError(w, r, "Error scheduling lesson", err)
+	default:
+		metrics.BookingsCreated.WithLabelValues(spaceLabel(booking.SpaceID), booking.Priority.String()).Inc()
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(booking)
+	}
+}
+
+// missingError marks a teacher or student that doesn't exist
+type missingError struct{ owner string }
+
+func (e missingError) Error() string {
+	return ownerNames[e.owner] + " not found"
+}
+
+// notFound turns a missing record into a missingError for owner
+func notFound(owner string, err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return missingError{owner}
+	}
+	return err
+}
+
+// participant loads the bookings and weekly hours of a teacher or student
+// around the span from from to to. scope selects their bookings.
+func (h *BookingHandler) participant(db *gorm.DB, ownerType string, ownerID int64, scope func(*gorm.DB) *gorm.DB, from, to time.Time) (availability.Participant, error) {
+	var p availability.Participant
+	bookings, err := h.activeBookings(db, from, to, scope)
+	if err != nil {
+		return p, err
+	}
+	for _, b := range bookings {
+		p.Busy = append(p.Busy, availability.Interval{Start: b.StartTime, End: b.EndTime})
+	}
+
+	windows, err := loadWindows(db, ownerType, ownerID)
+	if err != nil {
+		return p, err
+	}
+	for _, w := range windows {
+		p.Hours = append(p.Hours, availability.WeeklyWindow{
+			Weekday:     time.Weekday(w.Weekday),
+			StartMinute: clockString(w.StartTime),
+			EndMinute:   clockString(w.EndTime),
+		})
+	}
+	return p, nil
+}
+
+// optionalID parses an ID query parameter, returning 0 if it is absent
+func optionalID(r *http.Request, name string) (int64, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("invalid %s", name)
+	}
+	return id, nil
+}
@@ -0,0 +1,149 @@
+// internal/handlers/space.go
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"skedda-goclone/internal/audit"
+	"skedda-goclone/internal/models"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+type SpaceHandler struct {
+	DB        *gorm.DB
+	PublicURL string
+}
+
+// maxCheckInMinutes bounds a space's check-in window
+const maxCheckInMinutes = 240
+
+// duplicateSpace is the response when a space name is already taken
+const duplicateSpace = "A space with that name already exists"
+
+// spaceInput is the part of a space a client may set
+type spaceInput struct {
+	Name      string   `json:"name"`
+	Capacity  int      `json:"capacity"`
+	Amenities []string `json:"amenities"`
+	// CheckInMinutes is how long after the start a booking must be checked
+	// in to, 0 to not require check-in
+	CheckInMinutes int `json:"check_in_minutes"`
+	// RequiresApproval holds bookings by anyone but admins for approval
+	RequiresApproval bool `json:"requires_approval"`
+}
+
+func (in *spaceInput) validate() error {
+	in.Name = strings.TrimSpace(in.Name)
+	if in.Name == "" {
+		return errors.New("name is required")
+	}
+	if in.Capacity < 0 {
+		return errors.New("capacity must not be negative")
+	}
+	if in.CheckInMinutes < 0 || in.CheckInMinutes > maxCheckInMinutes {
+		return fmt.Errorf("check_in_minutes must be between 0 and %d", maxCheckInMinutes)
+	}
+	return nil
+}
+
+// ListSpaces returns every space, by name
+func (h *SpaceHandler) ListSpaces(w http.ResponseWriter, r *http.Request) {
+	var spaces []models.Space
+	if err := h.DB.WithContext(r.Context()).Order("name").Find(&spaces).Error; err != nil {
+		serverError(w, r, "Error fetching spaces", err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(spaces)
+}
+
+// CreateSpace adds a bookable space. Only admins may manage spaces.
+func (h *SpaceHandler) CreateSpace(w http.ResponseWriter, r *http.Request) {
+	var input spaceInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		httpError(w, r, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if err := input.validate(); err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	space := models.Space{
+		Name: input.Name, Capacity: input.Capacity, Amenities: input.Amenities,
+		CheckInMinutes: input.CheckInMinutes, RequiresApproval: input.RequiresApproval,
+	}
+	err := h.DB.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&space).Error; err != nil {
+			return err
+		}
+		if err := audit.RecordChange(tx, r, "space.create", "space", strconv.FormatInt(space.ID, 10), nil, space); err != nil {
+			return err
+		}
+		return publish(tx, models.EventSpaceCreated, space)
+	})
+	if isDuplicate(err) {
+		httpError(w, r, duplicateSpace, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		serverError(w, r, "Error saving space", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(space)
+}
+
+// UpdateSpace renames a space or changes its capacity, amenities,
+// check-in window and whether bookings need approval
+func (h *SpaceHandler) UpdateSpace(w http.ResponseWriter, r *http.Request) {
+	var input spaceInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		httpError(w, r, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if err := input.validate(); err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var space models.Space
+	err := h.DB.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&space, pathID(r)).Error; err != nil {
+			return err
+		}
+		before := space
+		space.Name = input.Name
+		space.Capacity = input.Capacity
+		space.Amenities = input.Amenities
+		space.CheckInMinutes = input.CheckInMinutes
+		space.RequiresApproval = input.RequiresApproval
+		if err := tx.Save(&space).Error; err != nil {
+			return err
+		}
+		if err := audit.RecordChange(tx, r, "space.update", "space", strconv.FormatInt(space.ID, 10), before, space); err != nil {
+			return err
+		}
+		return publish(tx, models.EventSpaceUpdated, space)
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		httpError(w, r, "Space not found", http.StatusNotFound)
+		return
+	}
+	if isDuplicate(err) {
+		httpError(w, r, duplicateSpace, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		serverError(w, r, "Error updating space", err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(space)
+}
@@ -0,0 +1,50 @@
+// internal/handlers/space_test.go
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"skedda-goclone/internal/dbtest"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestIsDuplicate(t *testing.T) {
+	if !isDuplicate(fmt.Errorf("saving: %w", &pgconn.PgError{Code: "23505"})) {
+		t.Error("wrapped unique violation not recognised")
+	}
+	if isDuplicate(&pgconn.PgError{Code: "23503"}) || isDuplicate(fmt.Errorf("other")) || isDuplicate(nil) {
+		t.Error("other errors taken for unique violations")
+	}
+}
+
+func TestSpaceNamesAreUnique(t *testing.T) {
+	h := &SpaceHandler{DB: dbtest.Open(t).DB}
+	create := func(name string) int {
+		w := httptest.NewRecorder()
+		h.CreateSpace(w, httptest.NewRequest(http.MethodPost, "/api/spaces", strings.NewReader(`{"name":"`+name+`"}`)))
+		return w.Code
+	}
+	if code := create("Room A"); code != http.StatusCreated {
+		t.Fatalf("creating a space returned %d", code)
+	}
+	if code := create("Room B"); code != http.StatusCreated {
+		t.Fatalf("creating a second space returned %d", code)
+	}
+	if code := create("Room A"); code != http.StatusConflict {
+		t.Errorf("creating a space with a taken name returned %d, want 409", code)
+	}
+
+	// Renaming onto a taken name conflicts too
+	r := httptest.NewRequest(http.MethodPut, "/api/spaces/2", strings.NewReader(`{"name":"Room A"}`))
+	w := httptest.NewRecorder()
+	h.UpdateSpace(w, mux.SetURLVars(r, map[string]string{"id": "2"}))
+	if w.Code != http.StatusConflict {
+		t.Errorf("renaming onto a taken name returned %d, want 409", w.Code)
+	}
+}
@@ -1,65 +1,316 @@
-// internal/handlers/teacher.go
-package handlers
-
-import (
-	"encoding/json"
-	"net/http"
-	"skedda-goclone/internal/models"
-
-	"gorm.io/gorm"
-)
-
-type TeacherHandler struct {
-	DB *gorm.DB
-}
-
-// RegisterTeacher handles teacher registration
-func (h *TeacherHandler) RegisterTeacher(w http.ResponseWriter, r *http.Request) {
-	var teacher models.Teacher
-	if err := json.NewDecoder(r.Body).Decode(&teacher); err != nil {
-		http.Error(w, "Invalid input", http.StatusBadRequest)
-		return
-	}
-
-	// Use GORM to create teacher
-	if err := h.DB.Create(&teacher).Error; err != nil {
-		http.Error(w, "Could not register teacher", http.StatusInternalServerError)
-		return
-	}
-
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode("Teacher registered successfully")
-}
-
-// LoginTeacher handles teacher login
-func (h *TeacherHandler) LoginTeacher(w http.ResponseWriter, r *http.Request) {
-	var loginRequest struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&loginRequest); err != nil {
-		http.Error(w, "Invalid input", http.StatusBadRequest)
-		return
-	}
-
-	// Retrieve teacher from the database using GORM
-	var teacher models.Teacher
-	if err := h.DB.Where("email = ?", loginRequest.Email).First(&teacher).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			http.Error(w, "Teacher not found", http.StatusUnauthorized)
-		} else {
-			http.Error(w, "Error retrieving teacher", http.StatusInternalServerError)
-		}
-		return
-	}
-
-	// Verify password
-	if !teacher.CheckPassword(loginRequest.Password) {
-		http.Error(w, "Incorrect password", http.StatusUnauthorized)
-		return
-	}
-
-	// Successful login
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode("Teacher logged in successfully")
-}
+// internal/handlers/teacher.go
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"regexp"
+	"skedda-goclone/internal/audit"
+	"skedda-goclone/internal/auth"
+	"skedda-goclone/internal/config"
+	"skedda-goclone/internal/logging"
+	"skedda-goclone/internal/metrics"
+	"skedda-goclone/internal/models"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+type TeacherHandler struct {
+	DB             *gorm.DB
+	Auth           config.AuthConfig
+	IPLimiter      *auth.Limiter
+	AccountLimiter *auth.Limiter
+	// OIDC is the single sign-on provider, nil when SSO is not configured
+	OIDC *auth.OIDCProvider
+	SSO  config.OIDCConfig
+}
+
+// RegisterTeacher handles teacher registration
+func (h *TeacherHandler) RegisterTeacher(w http.ResponseWriter, r *http.Request) {
+	var teacher models.Teacher
+	if err := json.NewDecoder(r.Body).Decode(&teacher); err != nil {
+		httpError(w, r, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	// Admins are only created through the management CLI
+	teacher.Role = models.RoleTeacher
+	teacher.Email = normalizeEmail(teacher.Email)
+
+	// Use GORM to create teacher
+	err := h.DB.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&teacher).Error; err != nil {
+			return err
+		}
+		return audit.RecordChange(tx, r, "teacher.create", "teacher", strconv.FormatInt(teacher.ID, 10), nil, teacher)
+	})
+	if err != nil {
+		serverError(w, r, "Could not register teacher", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode("Teacher registered successfully")
+}
+
+// invalidLogin is the response to every rejected login, so the response
+// doesn't reveal whether an email is registered or an account is locked
+const invalidLogin = "Invalid email or password"
+
+// normalizeEmail is the form of an email address used to look up and
+// throttle logins. Accounts registered before addresses were normalized
+// may be stored in any case, so lookups compare against LOWER(email).
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// dummyTeacher has a real bcrypt hash to check passwords against when the
+// email is unknown, so that such logins take as long as any other
+var dummyTeacher = sync.OnceValue(func() *models.Teacher {
+	t := &models.Teacher{}
+	t.SetPassword("not the password of any account")
+	return t
+})
+
+// LoginTeacher handles teacher login
+func (h *TeacherHandler) LoginTeacher(w http.ResponseWriter, r *http.Request) {
+	var loginRequest struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&loginRequest); err != nil {
+		httpError(w, r, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	// Throttle guessing from one address, and against one account
+	now := time.Now()
+	if ok, wait := h.IPLimiter.Allow(audit.ClientIP(r), now); !ok {
+		h.loginThrottled(w, r, loginRequest.Email, wait)
+		return
+	}
+	if ok, wait := h.AccountLimiter.Allow(normalizeEmail(loginRequest.Email), now); !ok {
+		h.loginThrottled(w, r, loginRequest.Email, wait)
+		return
+	}
+
+	// Retrieve teacher from the database using GORM
+	var teacher models.Teacher
+	if err := h.DB.WithContext(r.Context()).Where("LOWER(email) = ?", normalizeEmail(loginRequest.Email)).First(&teacher).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			dummyTeacher().CheckPassword(loginRequest.Password)
+			h.loginFailed(w, r, nil, loginRequest.Email, "unknown_email")
+		} else {
+			serverError(w, r, "Error retrieving teacher", err)
+		}
+		return
+	}
+
+	if teacher.IsLocked(now) {
+		h.loginFailed(w, r, &teacher, loginRequest.Email, "locked")
+		return
+	}
+
+	// Verify password, locking the account after repeated failures
+	if !teacher.CheckPassword(loginRequest.Password) {
+		if err := h.recordFailure(r, &teacher, now); err != nil {
+			serverError(w, r, "Error recording failed login", err)
+			return
+		}
+		h.loginFailed(w, r, &teacher, loginRequest.Email, "bad_password")
+		return
+	}
+
+	if err := h.clearFailures(r, &teacher); err != nil {
+		serverError(w, r, "Error retrieving teacher", err)
+		return
+	}
+
+	// Successful login
+	h.issueToken(w, r, &teacher, h.loginPurpose(&teacher), now)
+}
+
+// loginPurpose returns the purpose of the token to issue once a teacher
+// has proved their first factor. Accounts with a second factor must prove
+// it before getting full access, and accounts whose role requires one must
+// enroll first.
+func (h *TeacherHandler) loginPurpose(teacher *models.Teacher) string {
+	switch {
+	case teacher.TOTPEnabled:
+		return auth.PurposeMFA
+	case h.Auth.RequiresTOTP(teacher.Role):
+		return auth.PurposeEnroll
+	default:
+		return ""
+	}
+}
+
+// Lifetimes of the restricted tokens handed out part way through a login
+const (
+	mfaTokenTTL    = 5 * time.Minute
+	enrollTokenTTL = 15 * time.Minute
+)
+
+// issueToken responds with a login token for teacher. A purpose restricts
+// what the token can be used for; see auth.PurposeMFA and auth.PurposeEnroll.
+func (h *TeacherHandler) issueToken(w http.ResponseWriter, r *http.Request, teacher *models.Teacher, purpose string, now time.Time) {
+	token, expires, err := h.newToken(teacher, purpose, now)
+	if err != nil {
+		serverError(w, r, "Error issuing token", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{
+		"token":                    token,
+		"expires_at":               expires,
+		"teacher":                  teacher,
+		"mfa_required":             purpose == auth.PurposeMFA,
+		"totp_enrollment_required": purpose == auth.PurposeEnroll,
+	})
+}
+
+func (h *TeacherHandler) newToken(teacher *models.Teacher, purpose string, now time.Time) (string, time.Time, error) {
+	ttl := h.Auth.TokenTTL
+	switch purpose {
+	case auth.PurposeMFA:
+		ttl = mfaTokenTTL
+	case auth.PurposeEnroll:
+		ttl = enrollTokenTTL
+	}
+
+	claims := auth.Claims{
+		TeacherID: teacher.ID,
+		Role:      teacher.Role,
+		Purpose:   purpose,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(ttl),
+	}
+	token, err := auth.IssueToken([]byte(h.Auth.TokenSecret), claims)
+	return token, claims.ExpiresAt, err
+}
+
+// recordFailure counts a failed password for teacher and locks the
+// account once the failures pass the lockout threshold. The count is
+// incremented in SQL so concurrent failures are all counted.
+func (h *TeacherHandler) recordFailure(r *http.Request, teacher *models.Teacher, now time.Time) error {
+	db := h.DB.WithContext(r.Context())
+	var failures int
+	if err := db.Raw("UPDATE teachers SET failed_logins = failed_logins + 1 WHERE id = ? RETURNING failed_logins", teacher.ID).
+		Scan(&failures).Error; err != nil {
+		return err
+	}
+
+	if lockout := h.Auth.LockoutDuration(failures); lockout > 0 {
+		until := now.Add(lockout)
+		return db.Model(teacher).UpdateColumn("locked_until", until).Error
+	}
+	return nil
+}
+
+// clearFailures resets the failed login count after a successful login
+func (h *TeacherHandler) clearFailures(r *http.Request, teacher *models.Teacher) error {
+	if teacher.FailedLogins == 0 && teacher.LockedUntil == nil {
+		return nil
+	}
+	return h.DB.WithContext(r.Context()).Model(teacher).
+		UpdateColumns(map[string]any{"failed_logins": 0, "locked_until": nil}).Error
+}
+
+// loginFailed audits and rejects a login attempt
+func (h *TeacherHandler) loginFailed(w http.ResponseWriter, r *http.Request, teacher *models.Teacher, email, reason string) {
+	metrics.LoginFailures.WithLabelValues(reason).Inc()
+
+	var entityID string
+	if teacher != nil {
+		entityID = strconv.FormatInt(teacher.ID, 10)
+	}
+	if err := audit.Record(h.DB, r, "login.failed", "teacher", entityID, map[string]string{"email": email, "reason": reason}); err != nil {
+		logging.FromContext(r.Context()).Error("recording failed login", "error", err)
+	}
+
+	httpError(w, r, invalidLogin, http.StatusUnauthorized)
+}
+
+// loginThrottled rejects a login attempt over the rate limit
+func (h *TeacherHandler) loginThrottled(w http.ResponseWriter, r *http.Request, email string, wait time.Duration) {
+	metrics.LoginFailures.WithLabelValues("rate_limited").Inc()
+	if err := audit.Record(h.DB, r, "login.failed", "teacher", "", map[string]string{"email": email, "reason": "rate_limited"}); err != nil {
+		logging.FromContext(r.Context()).Error("recording failed login", "error", err)
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	httpError(w, r, "Too many login attempts, try again later", http.StatusTooManyRequests)
+}
+
+// UnlockTeacher lets an admin clear a teacher's lockout and failed logins
+func (h *TeacherHandler) UnlockTeacher(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	err := h.DB.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Teacher{}).Where("id = ?", id).
+			UpdateColumns(map[string]any{"failed_logins": 0, "locked_until": nil})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return audit.Record(tx, r, "teacher.unlock", "teacher", id, nil)
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		httpError(w, r, "Teacher not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		serverError(w, r, "Error unlocking teacher", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Teacher unlocked successfully"})
+}
+
+// localePattern matches language tags such as en, es or pt-BR
+var localePattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// SetLocale sets the language of the emails the caller is sent. An empty
+// locale goes back to the server default.
+func (h *TeacherHandler) SetLocale(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Locale string `json:"locale"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		httpError(w, r, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if input.Locale != "" && !localePattern.MatchString(input.Locale) {
+		httpError(w, r, "locale must be a language tag such as en or es", http.StatusBadRequest)
+		return
+	}
+
+	teacher, ok := h.currentTeacher(w, r)
+	if !ok {
+		return
+	}
+	before := *teacher
+	teacher.Locale = input.Locale
+	err := h.DB.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(teacher).Update("locale", teacher.Locale).Error; err != nil {
+			return err
+		}
+		return audit.RecordChange(tx, r, "teacher.locale", "teacher", strconv.FormatInt(teacher.ID, 10), before, *teacher)
+	})
+	if err != nil {
+		serverError(w, r, "Error saving locale", err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(teacher)
+}
@@ -0,0 +1,14 @@
+// internal/handlers/teacher_test.go
+package handlers
+
+import "testing"
+
+func TestNormalizeEmail(t *testing.T) {
+	// The login throttle and the account lookup must agree on which
+	// spellings are the same account
+	for _, in := range []string{"Ada@Example.com", " ada@example.com\t", "ADA@EXAMPLE.COM"} {
+		if got := normalizeEmail(in); got != "ada@example.com" {
+			t.Errorf("normalizeEmail(%q) = %q", in, got)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+// internal/handlers/totp.go
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"skedda-goclone/internal/audit"
+	"skedda-goclone/internal/auth"
+	"skedda-goclone/internal/models"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// recoveryCodeCount is how many recovery codes a teacher gets on enrolling
+const recoveryCodeCount = 10
+
+// EnrollTOTP starts two-factor enrollment for the caller, returning the
+// secret and the URI to show as a QR code. Enrollment is finished by
+// ConfirmTOTP with a code from the authenticator app.
+func (h *TeacherHandler) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	teacher, ok := h.currentTeacher(w, r)
+	if !ok {
+		return
+	}
+	if teacher.TOTPEnabled {
+		httpError(w, r, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		serverError(w, r, "Error generating secret", err)
+		return
+	}
+	err = h.DB.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(teacher).UpdateColumns(map[string]any{"totp_secret": secret, "totp_last_step": 0}).Error; err != nil {
+			return err
+		}
+		return audit.Record(tx, r, "totp.enroll", "teacher", strconv.FormatInt(teacher.ID, 10), nil)
+	})
+	if err != nil {
+		serverError(w, r, "Error starting enrollment", err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"secret":           secret,
+		"provisioning_uri": auth.TOTPProvisioningURI(h.Auth.TOTPIssuer, teacher.Email, secret),
+	})
+}
+
+// ConfirmTOTP enables two-factor authentication once the caller proves
+// their app works, and returns recovery codes. The codes are only ever
+// shown here. Callers holding an enrollment-only token also get a full one.
+func (h *TeacherHandler) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		httpError(w, r, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	teacher, ok := h.currentTeacher(w, r)
+	if !ok {
+		return
+	}
+	if teacher.TOTPEnabled {
+		httpError(w, r, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+	if teacher.TOTPSecret == "" {
+		httpError(w, r, "Start enrollment first", http.StatusConflict)
+		return
+	}
+
+	now := time.Now()
+	step, ok := auth.ValidateTOTP(teacher.TOTPSecret, input.Code, now, teacher.TOTPLastStep)
+	if !ok {
+		httpError(w, r, "Invalid code", http.StatusBadRequest)
+		return
+	}
+
+	codes, err := auth.NewRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		serverError(w, r, "Error generating recovery codes", err)
+		return
+	}
+	err = h.DB.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(teacher).UpdateColumns(map[string]any{"totp_enabled": true, "totp_last_step": step}).Error; err != nil {
+			return err
+		}
+		if err := replaceRecoveryCodes(tx, teacher.ID, codes); err != nil {
+			return err
+		}
+		return audit.Record(tx, r, "totp.enable", "teacher", strconv.FormatInt(teacher.ID, 10), nil)
+	})
+	if err != nil {
+		serverError(w, r, "Error enabling two-factor authentication", err)
+		return
+	}
+
+	response := map[string]any{"recovery_codes": codes}
+	if p, _ := auth.FromContext(r.Context()); p.Purpose == auth.PurposeEnroll {
+		token, expires, err := h.newToken(teacher, "", now)
+		if err != nil {
+			serverError(w, r, "Error issuing token", err)
+			return
+		}
+		response["token"] = token
+		response["expires_at"] = expires
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// VerifyTOTPLogin completes a login by exchanging the token from the
+// password step and a TOTP or recovery code for a full token
+func (h *TeacherHandler) VerifyTOTPLogin(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		httpError(w, r, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	claims, err := auth.VerifyToken([]byte(h.Auth.TokenSecret), input.MFAToken, now)
+	if err != nil || claims.Purpose != auth.PurposeMFA {
+		httpError(w, r, invalidLogin, http.StatusUnauthorized)
+		return
+	}
+
+	// Six digit codes are guessable without a tight limit
+	if ok, wait := h.AccountLimiter.Allow("totp:"+strconv.FormatInt(claims.TeacherID, 10), now); !ok {
+		h.loginThrottled(w, r, "", wait)
+		return
+	}
+
+	var teacher models.Teacher
+	if err := h.DB.WithContext(r.Context()).First(&teacher, claims.TeacherID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			httpError(w, r, invalidLogin, http.StatusUnauthorized)
+		} else {
+			serverError(w, r, "Error retrieving teacher", err)
+		}
+		return
+	}
+	if teacher.IsLocked(now) {
+		h.loginFailed(w, r, &teacher, teacher.Email, "locked")
+		return
+	}
+	// The second factor may have been reset since the password step, and
+	// then there is nothing to check the code against
+	if !teacher.TOTPEnabled {
+		h.loginFailed(w, r, &teacher, teacher.Email, "totp_disabled")
+		return
+	}
+
+	ok, err := h.checkSecondFactor(r, &teacher, input.Code, now)
+	if err != nil {
+		serverError(w, r, "Error verifying code", err)
+		return
+	}
+	if !ok {
+		if err := h.recordFailure(r, &teacher, now); err != nil {
+			serverError(w, r, "Error recording failed login", err)
+			return
+		}
+		h.loginFailed(w, r, &teacher, teacher.Email, "bad_totp")
+		return
+	}
+
+	if err := h.clearFailures(r, &teacher); err != nil {
+		serverError(w, r, "Error retrieving teacher", err)
+		return
+	}
+	h.issueToken(w, r, &teacher, "", now)
+}
+
+// DisableTOTP turns off two-factor authentication for the caller, given a
+// current TOTP or recovery code. Roles that require it can't turn it off.
+func (h *TeacherHandler) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		httpError(w, r, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	teacher, ok := h.currentTeacher(w, r)
+	if !ok {
+		return
+	}
+	if h.Auth.RequiresTOTP(teacher.Role) {
+		httpError(w, r, "Two-factor authentication is required for your role", http.StatusForbidden)
+		return
+	}
+	if !teacher.TOTPEnabled {
+		httpError(w, r, "Two-factor authentication is not enabled", http.StatusConflict)
+		return
+	}
+
+	ok, err := h.checkSecondFactor(r, teacher, input.Code, time.Now())
+	if err != nil {
+		serverError(w, r, "Error verifying code", err)
+		return
+	}
+	if !ok {
+		httpError(w, r, "Invalid code", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.clearTOTP(r, teacher.ID, "totp.disable"); err != nil {
+		serverError(w, r, "Error disabling two-factor authentication", err)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"message": "Two-factor authentication disabled"})
+}
+
+// ResetTOTP lets an admin remove a teacher's second factor, such as after
+// a lost phone. The teacher enrolls again at their next login if their
+// role requires it.
+func (h *TeacherHandler) ResetTOTP(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		httpError(w, r, "Invalid teacher ID", http.StatusBadRequest)
+		return
+	}
+
+	err = h.clearTOTP(r, id, "totp.reset")
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		httpError(w, r, "Teacher not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		serverError(w, r, "Error resetting two-factor authentication", err)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"message": "Two-factor authentication reset"})
+}
+
+// currentTeacher loads the authenticated caller, responding with an error
+// if that fails
+func (h *TeacherHandler) currentTeacher(w http.ResponseWriter, r *http.Request) (*models.Teacher, bool) {
+	p, _ := auth.FromContext(r.Context())
+	var teacher models.Teacher
+	if err := h.DB.WithContext(r.Context()).First(&teacher, p.TeacherID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			httpError(w, r, "Teacher not found", http.StatusNotFound)
+		} else {
+			serverError(w, r, "Error retrieving teacher", err)
+		}
+		return nil, false
+	}
+	return &teacher, true
+}
+
+// checkSecondFactor accepts a TOTP code that hasn't been used before, or
+// an unused recovery code, marking whichever it was as used
+func (h *TeacherHandler) checkSecondFactor(r *http.Request, teacher *models.Teacher, code string, now time.Time) (bool, error) {
+	db := h.DB.WithContext(r.Context())
+
+	if step, ok := auth.ValidateTOTP(teacher.TOTPSecret, code, now, teacher.TOTPLastStep); ok {
+		// Only one request can move the step forward, so a code can't be
+		// replayed by racing two requests
+		result := db.Model(&models.Teacher{}).
+			Where("id = ? AND totp_last_step < ?", teacher.ID, step).
+			UpdateColumn("totp_last_step", step)
+		return result.RowsAffected == 1, result.Error
+	}
+
+	result := db.Model(&models.RecoveryCode{}).
+		Where("teacher_id = ? AND code_hash = ? AND used_at IS NULL", teacher.ID, auth.HashRecoveryCode(code)).
+		UpdateColumn("used_at", now)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false, result.Error
+	}
+	return true, audit.Record(h.DB, r, "totp.recovery_code_used", "teacher", strconv.FormatInt(teacher.ID, 10), nil)
+}
+
+// clearTOTP removes a teacher's second factor and recovery codes
+func (h *TeacherHandler) clearTOTP(r *http.Request, teacherID int64, action string) error {
+	return h.DB.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Teacher{}).Where("id = ?", teacherID).
+			UpdateColumns(map[string]any{"totp_secret": "", "totp_enabled": false, "totp_last_step": 0})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		if err := tx.Where("teacher_id = ?", teacherID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return audit.Record(tx, r, action, "teacher", strconv.FormatInt(teacherID, 10), nil)
+	})
+}
+
+// replaceRecoveryCodes stores the hashes of codes as the teacher's only
+// recovery codes
+func replaceRecoveryCodes(tx *gorm.DB, teacherID int64, codes []string) error {
+	if err := tx.Where("teacher_id = ?", teacherID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return err
+	}
+	records := make([]models.RecoveryCode, len(codes))
+	for i, code := range codes {
+		records[i] = models.RecoveryCode{TeacherID: teacherID, CodeHash: auth.HashRecoveryCode(code)}
+	}
+	return tx.Create(&records).Error
+}
@@ -0,0 +1,171 @@
+// internal/handlers/webhook.go
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"skedda-goclone/internal/audit"
+	"skedda-goclone/internal/auth"
+	"skedda-goclone/internal/models"
+	"skedda-goclone/internal/webhook"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+type WebhookHandler struct {
+	DB *gorm.DB
+}
+
+// CreateWebhook subscribes a URL to events. The signing secret is only
+// ever shown in this response.
+func (h *WebhookHandler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		httpError(w, r, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	if u, err := url.Parse(input.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		httpError(w, r, "url must be an absolute http or https URL", http.StatusBadRequest)
+		return
+	}
+	if len(input.Events) == 0 {
+		httpError(w, r, "At least one event is required, from: "+strings.Join(models.WebhookEvents, ", "), http.StatusBadRequest)
+		return
+	}
+	for _, event := range input.Events {
+		if !slices.Contains(models.WebhookEvents, event) {
+			httpError(w, r, "Unknown event "+strconv.Quote(event)+", expected one of: "+strings.Join(models.WebhookEvents, ", "), http.StatusBadRequest)
+			return
+		}
+	}
+
+	secret, err := webhook.NewSecret()
+	if err != nil {
+		serverError(w, r, "Error generating secret", err)
+		return
+	}
+	caller, _ := auth.FromContext(r.Context())
+	slices.Sort(input.Events)
+	sub := models.WebhookSubscription{
+		CreatedBy: caller.TeacherID,
+		URL:       input.URL,
+		Secret:    secret,
+		Events:    slices.Compact(input.Events),
+		Active:    true,
+	}
+	err = h.DB.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&sub).Error; err != nil {
+			return err
+		}
+		return audit.RecordChange(tx, r, "webhook.create", "webhook", strconv.FormatInt(sub.ID, 10), nil, sub)
+	})
+	if err != nil {
+		serverError(w, r, "Error creating webhook", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]any{
+		"secret":  secret,
+		"webhook": sub,
+	})
+}
+
+// ListWebhooks lists every subscription
+func (h *WebhookHandler) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	var subs []models.WebhookSubscription
+	if err := h.DB.WithContext(r.Context()).Order("id").Find(&subs).Error; err != nil {
+		serverError(w, r, "Error fetching webhooks", err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(subs)
+}
+
+// DeleteWebhook deactivates a subscription. Its delivery log is kept, and
+// deliveries still queued for it are dead-lettered.
+func (h *WebhookHandler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	var sub models.WebhookSubscription
+	err := h.DB.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&sub, id).Error; err != nil {
+			return err
+		}
+		if !sub.Active {
+			return nil
+		}
+		before := sub
+		sub.Active = false
+		if err := tx.Model(&sub).Update("active", false).Error; err != nil {
+			return err
+		}
+		return audit.RecordChange(tx, r, "webhook.delete", "webhook", id, before, sub)
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		httpError(w, r, "Webhook not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		serverError(w, r, "Error deleting webhook", err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(sub)
+}
+
+// ListDeliveries returns a subscription's delivery log, newest first,
+// optionally limited to one status
+func (h *WebhookHandler) ListDeliveries(w http.ResponseWriter, r *http.Request) {
+	query := h.DB.WithContext(r.Context()).
+		Where("subscription_id = ?", mux.Vars(r)["id"]).
+		Order("id DESC").Limit(defaultAuditLimit)
+	if v := r.URL.Query().Get("status"); v != "" {
+		query = query.Where("status = ?", v)
+	}
+	if v := r.URL.Query().Get("before_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			httpError(w, r, "Invalid before_id", http.StatusBadRequest)
+			return
+		}
+		query = query.Where("id < ?", id)
+	}
+
+	var deliveries []models.WebhookDelivery
+	if err := query.Find(&deliveries).Error; err != nil {
+		serverError(w, r, "Error fetching deliveries", err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+// RetryDelivery requeues a dead-lettered delivery with a fresh set of
+// attempts
+func (h *WebhookHandler) RetryDelivery(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	result := h.DB.WithContext(r.Context()).Model(&models.WebhookDelivery{}).
+		Where("id = ? AND status = ?", id, models.DeliveryDead).
+		Updates(map[string]any{"status": models.DeliveryPending, "attempts": 0, "next_attempt_at": time.Now()})
+	if result.Error != nil {
+		serverError(w, r, "Error requeueing delivery", result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		httpError(w, r, "No dead-lettered delivery with that ID", http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "Delivery requeued"})
+}
@@ -0,0 +1,26 @@
+// internal/handlers/webhook_test.go
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"skedda-goclone/internal/dbtest"
+
+	"github.com/gorilla/mux"
+)
+
+func TestListDeliveriesRejectsBadCursor(t *testing.T) {
+	h := &WebhookHandler{DB: dbtest.Open(t).DB}
+	for cursor, want := range map[string]int{"abc": http.StatusBadRequest, "1; DROP": http.StatusBadRequest, "10": http.StatusOK} {
+		target := "/api/webhooks/1/deliveries?before_id=" + url.QueryEscape(cursor)
+		r := mux.SetURLVars(httptest.NewRequest(http.MethodGet, target, nil), map[string]string{"id": "1"})
+		w := httptest.NewRecorder()
+		h.ListDeliveries(w, r)
+		if w.Code != want {
+			t.Errorf("before_id=%q returned %d, want %d", cursor, w.Code, want)
+		}
+	}
+}
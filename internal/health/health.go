@@ -0,0 +1,99 @@
+// internal/health/health.go
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// checkTimeout bounds each dependency check, so a hung dependency makes
+// the server unready rather than hanging the orchestrator's probe
+const checkTimeout = 2 * time.Second
+
+// Check reports whether a dependency is usable
+type Check func(ctx context.Context) error
+
+// Checker serves the liveness and readiness endpoints
+type Checker struct {
+	names    []string
+	checks   map[string]Check
+	draining atomic.Bool
+}
+
+func NewChecker() *Checker {
+	return &Checker{checks: make(map[string]Check)}
+}
+
+// Add registers a dependency that must pass for the server to be ready
+func (c *Checker) Add(name string, check Check) {
+	c.names = append(c.names, name)
+	c.checks[name] = check
+}
+
+// Drain marks the server as shutting down, failing readiness from now on
+func (c *Checker) Drain() {
+	c.draining.Store(true)
+}
+
+type dependencyStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type readiness struct {
+	Status       string                      `json:"status"`
+	Dependencies map[string]dependencyStatus `json:"dependencies"`
+}
+
+// Liveness reports that the process is up and serving requests
+func (c *Checker) Liveness(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// Readiness runs every dependency check and responds 200 if they all pass,
+// or 503 if any fails or the server is draining for shutdown
+func (c *Checker) Readiness(w http.ResponseWriter, r *http.Request) {
+	result := readiness{Status: "ok", Dependencies: make(map[string]dependencyStatus)}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, name := range c.names {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+			defer cancel()
+
+			status := dependencyStatus{Status: "ok"}
+			if err := check(ctx); err != nil {
+				status = dependencyStatus{Status: "error", Error: err.Error()}
+			}
+			mu.Lock()
+			result.Dependencies[name] = status
+			mu.Unlock()
+		}(name, c.checks[name])
+	}
+	wg.Wait()
+
+	code := http.StatusOK
+	for _, status := range result.Dependencies {
+		if status.Status != "ok" {
+			result.Status = "unavailable"
+			code = http.StatusServiceUnavailable
+		}
+	}
+	if c.draining.Load() {
+		result.Status = "draining"
+		code = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,135 @@
+// internal/ical/ical.go
+package ical
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Event statuses, as defined by RFC 5545
+const (
+	StatusConfirmed = "CONFIRMED"
+	StatusTentative = "TENTATIVE"
+	StatusCancelled = "CANCELLED"
+)
+
+// Calendar is an iCalendar object holding events
+type Calendar struct {
+	// Name is shown by calendar apps for subscribed feeds
+	Name string
+	// TimeZone is the zone the events are meant to be shown in. Event
+	// times are written in UTC, so this is only a hint to clients.
+	TimeZone string
+	// Method is set for invitations, e.g. "REQUEST", and empty for feeds
+	Method string
+	Events []Event
+}
+
+// Event is a VEVENT. UID must stay the same for the life of the event, and
+// Sequence must grow whenever it changes, for clients to apply updates.
+type Event struct {
+	UID          string
+	Sequence     int64
+	Stamp        time.Time
+	Start, End   time.Time
+	Summary      string
+	Location     string
+	Description  string
+	Status       string
+	Organizer    string
+	Attendees    []string
+	LastModified time.Time
+}
+
+// Marshal encodes the calendar with the CRLF line endings and line folding
+// RFC 5545 requires
+func (c *Calendar) Marshal() []byte {
+	var w writer
+	w.line("BEGIN", "VCALENDAR")
+	w.line("VERSION", "2.0")
+	w.line("PRODID", "-//Skedda//Bookings//EN")
+	w.line("CALSCALE", "GREGORIAN")
+	if c.Method != "" {
+		w.line("METHOD", c.Method)
+	}
+	if c.Name != "" {
+		w.line("X-WR-CALNAME", escape(c.Name))
+	}
+	if c.TimeZone != "" {
+		w.line("X-WR-TIMEZONE", c.TimeZone)
+	}
+	for _, e := range c.Events {
+		w.line("BEGIN", "VEVENT")
+		w.line("UID", escape(e.UID))
+		stamp := e.Stamp
+		if stamp.IsZero() {
+			stamp = time.Now()
+		}
+		w.line("DTSTAMP", utc(stamp))
+		w.line("DTSTART", utc(e.Start))
+		w.line("DTEND", utc(e.End))
+		w.line("SEQUENCE", fmt.Sprint(e.Sequence))
+		w.line("SUMMARY", escape(e.Summary))
+		if e.Location != "" {
+			w.line("LOCATION", escape(e.Location))
+		}
+		if e.Description != "" {
+			w.line("DESCRIPTION", escape(e.Description))
+		}
+		if e.Status != "" {
+			w.line("STATUS", e.Status)
+		}
+		if e.Organizer != "" {
+			w.line("ORGANIZER", "mailto:"+e.Organizer)
+		}
+		for _, a := range e.Attendees {
+			w.line("ATTENDEE;ROLE=REQ-PARTICIPANT", "mailto:"+a)
+		}
+		if !e.LastModified.IsZero() {
+			w.line("LAST-MODIFIED", utc(e.LastModified))
+		}
+		w.line("END", "VEVENT")
+	}
+	w.line("END", "VCALENDAR")
+	return w.buf.Bytes()
+}
+
+type writer struct {
+	buf bytes.Buffer
+}
+
+// maxLine is the longest a content line may be, in octets, before folding
+const maxLine = 75
+
+// line writes a content line, folding it onto continuation lines without
+// splitting a UTF-8 sequence
+func (w *writer) line(name, value string) {
+	s := name + ":" + value
+	limit := maxLine
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		w.buf.WriteString(s[:cut])
+		w.buf.WriteString("\r\n ")
+		s = s[cut:]
+		// Continuation lines start with a space, which counts
+		limit = maxLine - 1
+	}
+	w.buf.WriteString(s)
+	w.buf.WriteString("\r\n")
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+func utc(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
@@ -0,0 +1,229 @@
+// internal/jobs/jobs.go
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"time"
+
+	"skedda-goclone/internal/config"
+	"skedda-goclone/internal/metrics"
+	"skedda-goclone/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Handler does the work of one job. Jobs run at least once, so a handler
+// must cope with being run again for work it already did.
+type Handler func(ctx context.Context, job *models.Job) error
+
+// Enqueue queues a one-off job of kind to run once runAt has passed. Pass
+// the transaction that made the change the job follows from, so the job
+// is only queued if the change is kept.
+func Enqueue(tx *gorm.DB, kind string, payload any, runAt time.Time) error {
+	return enqueue(tx, kind, nil, payload, runAt)
+}
+
+// EnqueueOnce is Enqueue for work that must only be queued once: if a job
+// with key was queued before, and hasn't been purged, it does nothing
+func EnqueueOnce(tx *gorm.DB, kind, key string, payload any, runAt time.Time) error {
+	return enqueue(tx, kind, &key, payload, runAt)
+}
+
+func enqueue(tx *gorm.DB, kind string, key *string, payload any, runAt time.Time) error {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	job := models.Job{Kind: kind, Key: key, Payload: raw, Status: models.JobPending, RunAt: runAt}
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&job).Error
+}
+
+// Runner runs due jobs. Any number of servers can run one against the same
+// database: each claims a job by leasing it, so a job runs on one server
+// at a time, and a server that dies mid job only delays it until the lease
+// runs out.
+type Runner struct {
+	DB     *gorm.DB
+	Config config.JobConfig
+
+	handlers  map[string]Handler
+	recurring map[string]time.Duration
+}
+
+// Handle registers the handler for jobs of kind. A runner only claims the
+// kinds it has handlers for.
+func (r *Runner) Handle(kind string, h Handler) {
+	if r.handlers == nil {
+		r.handlers = make(map[string]Handler)
+	}
+	r.handlers[kind] = h
+}
+
+// Every registers a job of kind that runs every interval, starting as soon
+// as the runner does. Servers share one such job between them.
+func (r *Runner) Every(kind string, interval time.Duration, h Handler) {
+	if r.recurring == nil {
+		r.recurring = make(map[string]time.Duration)
+	}
+	r.recurring[kind] = interval
+	r.Handle(kind, h)
+}
+
+// Run runs jobs as they fall due until ctx is cancelled
+func (r *Runner) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Config.PollInterval)
+	defer ticker.Stop()
+	scheduled := false
+	for {
+		if !scheduled {
+			err := r.schedule(ctx)
+			if err != nil && ctx.Err() == nil {
+				slog.Error("scheduling recurring jobs", "error", err)
+			}
+			scheduled = err == nil
+		}
+
+		for {
+			job, err := r.claim(ctx)
+			if err != nil && ctx.Err() == nil {
+				slog.Error("claiming job", "error", err)
+			}
+			if job == nil {
+				break
+			}
+			if err := r.run(ctx, job); err != nil {
+				slog.Error("recording job outcome", "job_id", job.ID, "kind", job.Kind, "error", err)
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// schedule queues every recurring job that isn't queued yet, keyed by its
+// kind. A job already queued takes the registered interval, and comes due
+// no later than one interval from now. A job another server is running is
+// left as it is, so its lease isn't cut short.
+func (r *Runner) schedule(ctx context.Context) error {
+	now := time.Now()
+	for kind, interval := range r.recurring {
+		key := kind
+		job := models.Job{Kind: kind, Key: &key, Status: models.JobPending, RunAt: now, Interval: interval}
+		err := r.DB.WithContext(ctx).Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "key"}},
+			DoUpdates: clause.Assignments(map[string]any{
+				"interval": interval,
+				"status":   gorm.Expr("CASE WHEN jobs.status = ? THEN jobs.status ELSE ? END", models.JobRunning, models.JobPending),
+				"run_at":   gorm.Expr("CASE WHEN jobs.status = ? THEN jobs.run_at ELSE LEAST(jobs.run_at, ?) END", models.JobRunning, now.Add(interval)),
+			}),
+		}).Create(&job).Error
+		if err != nil {
+			return fmt.Errorf("%s: %w", kind, err)
+		}
+	}
+	return nil
+}
+
+// claim leases the next due job by marking it running until past the
+// longest it can take, or returns nil if none is due. A running job whose
+// lease ran out, because its server died, is due again.
+func (r *Runner) claim(ctx context.Context) (*models.Job, error) {
+	if len(r.handlers) == 0 {
+		return nil, nil
+	}
+	var jobs []models.Job
+	err := r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status IN ? AND run_at <= ? AND kind IN ?", []string{models.JobPending, models.JobRunning}, now, slices.Collect(maps.Keys(r.handlers))).
+			Order("run_at").Limit(1).Find(&jobs).Error
+		if err != nil || len(jobs) == 0 {
+			return err
+		}
+		return tx.Model(&jobs[0]).Updates(map[string]any{"status": models.JobRunning, "run_at": now.Add(2 * r.Config.Timeout)}).Error
+	})
+	if err != nil || len(jobs) == 0 {
+		return nil, err
+	}
+	return &jobs[0], nil
+}
+
+// run runs job and records the outcome: a recurring job comes due again
+// after its interval, and a failed job is retried or, once it has used up
+// its attempts, given up on
+func (r *Runner) run(ctx context.Context, job *models.Job) error {
+	start := time.Now()
+	job.Attempts++
+	job.LastRunAt = &start
+	runErr := r.call(ctx, job)
+	now := time.Now()
+
+	outcome := "retry"
+	job.Status = models.JobPending
+	switch {
+	case runErr == nil:
+		outcome = models.JobSucceeded
+		job.LastError = ""
+		if job.Interval > 0 {
+			job.Attempts = 0
+			job.RunAt = start.Add(job.Interval)
+		} else {
+			job.Status = models.JobSucceeded
+			job.FinishedAt = &now
+		}
+	case job.Interval == 0 && job.Attempts >= r.Config.MaxAttempts:
+		outcome = models.JobDead
+		job.Status = models.JobDead
+		job.LastError = runErr.Error()
+		job.FinishedAt = &now
+		slog.Warn("job given up on", "job_id", job.ID, "kind", job.Kind, "attempts", job.Attempts, "error", runErr)
+	default:
+		job.LastError = runErr.Error()
+		wait := r.backoff(job.Attempts)
+		if job.Interval > 0 {
+			wait = min(wait, job.Interval)
+		}
+		job.RunAt = now.Add(wait)
+		slog.Warn("job failed", "job_id", job.ID, "kind", job.Kind, "attempts", job.Attempts, "error", runErr)
+	}
+	metrics.JobRuns.WithLabelValues(job.Kind, outcome).Inc()
+
+	// Record the outcome even when shutting down, so the job isn't left
+	// waiting out its lease
+	return r.DB.WithContext(context.WithoutCancel(ctx)).Model(job).Select(
+		"status", "run_at", "attempts", "last_run_at", "last_error", "finished_at",
+	).Updates(job).Error
+}
+
+// call runs job's handler within the job timeout, turning a panic into an
+// error so one bad job can't take the runner down
+func (r *Runner) call(ctx context.Context, job *models.Job) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, r.Config.Timeout)
+	defer cancel()
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return r.handlers[job.Kind](ctx, job)
+}
+
+// backoff returns how long to wait after the given number of failed attempts
+func (r *Runner) backoff(attempts int) time.Duration {
+	wait := r.Config.RetryBase
+	for i := 1; i < attempts && wait < r.Config.RetryMax; i++ {
+		wait *= 2
+	}
+	return min(wait, r.Config.RetryMax)
+}
@@ -0,0 +1,127 @@
+// internal/jobs/jobs_test.go
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"skedda-goclone/internal/config"
+	"skedda-goclone/internal/dbtest"
+	"skedda-goclone/internal/models"
+)
+
+func testConfig() config.JobConfig {
+	return config.JobConfig{MaxAttempts: 3, RetryBase: time.Second, RetryMax: 10 * time.Second, Timeout: time.Minute, PollInterval: time.Second}
+}
+
+func TestBackoff(t *testing.T) {
+	r := &Runner{Config: testConfig()}
+	for attempts, want := range map[int]time.Duration{
+		1: time.Second,
+		2: 2 * time.Second,
+		3: 4 * time.Second,
+		4: 8 * time.Second,
+		5: 10 * time.Second,
+		9: 10 * time.Second,
+	} {
+		if got := r.backoff(attempts); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+func TestCallRecoversPanics(t *testing.T) {
+	r := &Runner{Config: testConfig()}
+	r.Handle("boom", func(context.Context, *models.Job) error { panic("bad job") })
+	if err := r.call(context.Background(), &models.Job{Kind: "boom"}); err == nil {
+		t.Error("panicking job reported success")
+	}
+}
+
+func loadJob(t *testing.T, r *Runner, id int64) models.Job {
+	t.Helper()
+	var job models.Job
+	if err := r.DB.First(&job, id).Error; err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func TestRunRetriesThenGivesUp(t *testing.T) {
+	db := dbtest.Open(t)
+	r := &Runner{DB: db.DB, Config: testConfig()}
+	r.Handle("fail", func(context.Context, *models.Job) error { return errors.New("down") })
+	if err := Enqueue(db.DB, "fail", struct{}{}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	for attempt := 1; attempt <= 3; attempt++ {
+		// Make the job due again without waiting out its backoff
+		if err := db.Model(&models.Job{}).Where("kind = ?", "fail").Update("run_at", time.Now()).Error; err != nil {
+			t.Fatal(err)
+		}
+		job, err := r.claim(ctx)
+		if err != nil || job == nil {
+			t.Fatalf("attempt %d: claimed %v, %v", attempt, job, err)
+		}
+		if leased := loadJob(t, r, job.ID); leased.Status != models.JobRunning {
+			t.Errorf("attempt %d: claimed job is %s, want running", attempt, leased.Status)
+		}
+		if again, err := r.claim(ctx); err != nil || again != nil {
+			t.Fatalf("attempt %d: leased job claimed twice", attempt)
+		}
+		if err := r.run(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+		got := loadJob(t, r, job.ID)
+		want := models.JobPending
+		if attempt == 3 {
+			want = models.JobDead
+		}
+		if got.Status != want || got.Attempts != attempt || got.LastError != "down" {
+			t.Errorf("after attempt %d: status %s, attempts %d, error %q", attempt, got.Status, got.Attempts, got.LastError)
+		}
+	}
+}
+
+func TestScheduleLeavesRunningJobs(t *testing.T) {
+	db := dbtest.Open(t)
+	ctx := context.Background()
+	r := &Runner{DB: db.DB, Config: testConfig()}
+	r.Every("tick", time.Minute, func(context.Context, *models.Job) error { return nil })
+	if err := r.schedule(ctx); err != nil {
+		t.Fatal(err)
+	}
+	job, err := r.claim(ctx)
+	if err != nil || job == nil {
+		t.Fatalf("claimed %v, %v", job, err)
+	}
+	leased := loadJob(t, r, job.ID)
+
+	// Another server starting up mustn't cut the lease short, even with a
+	// shorter interval than the lease
+	other := &Runner{DB: db.DB, Config: testConfig()}
+	other.Every("tick", time.Second, func(context.Context, *models.Job) error { return nil })
+	if err := other.schedule(ctx); err != nil {
+		t.Fatal(err)
+	}
+	got := loadJob(t, r, job.ID)
+	if got.Status != models.JobRunning || !got.RunAt.Equal(leased.RunAt) {
+		t.Errorf("running job rescheduled: status %s, run_at %v, want running until %v", got.Status, got.RunAt, leased.RunAt)
+	}
+
+	// Once it has run it comes due no later than the new interval
+	if err := r.run(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.schedule(ctx); err != nil {
+		t.Fatal(err)
+	}
+	got = loadJob(t, r, job.ID)
+	if got.Status != models.JobPending || got.RunAt.After(time.Now().Add(time.Second)) {
+		t.Errorf("finished job: status %s, run_at %v", got.Status, got.RunAt)
+	}
+}
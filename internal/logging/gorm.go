@@ -0,0 +1,83 @@
+// internal/logging/gorm.go
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// GormLogger sends GORM's logs to slog, tagged with the request ID of the
+// context the query ran under. Failed queries are logged as errors and
+// queries slower than the threshold as warnings.
+type GormLogger struct {
+	log           *slog.Logger
+	slowThreshold time.Duration
+	level         logger.LogLevel
+}
+
+// NewGormLogger returns a GORM logger writing to l. A zero slowThreshold
+// disables slow query logging.
+func NewGormLogger(l *slog.Logger, slowThreshold time.Duration) *GormLogger {
+	return &GormLogger{log: l, slowThreshold: slowThreshold, level: logger.Warn}
+}
+
+func (g *GormLogger) LogMode(level logger.LogLevel) logger.Interface {
+	copy := *g
+	copy.level = level
+	return &copy
+}
+
+func (g *GormLogger) Info(ctx context.Context, msg string, args ...interface{}) {
+	if g.level >= logger.Info {
+		g.log.InfoContext(ctx, fmt.Sprintf(msg, args...), requestAttr(ctx))
+	}
+}
+
+func (g *GormLogger) Warn(ctx context.Context, msg string, args ...interface{}) {
+	if g.level >= logger.Warn {
+		g.log.WarnContext(ctx, fmt.Sprintf(msg, args...), requestAttr(ctx))
+	}
+}
+
+func (g *GormLogger) Error(ctx context.Context, msg string, args ...interface{}) {
+	if g.level >= logger.Error {
+		g.log.ErrorContext(ctx, fmt.Sprintf(msg, args...), requestAttr(ctx))
+	}
+}
+
+func (g *GormLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if g.level <= logger.Silent {
+		return
+	}
+
+	elapsed := time.Since(begin)
+	switch {
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound) && g.level >= logger.Error:
+		sql, rows := fc()
+		g.log.ErrorContext(ctx, "query failed", requestAttr(ctx),
+			"error", err, "sql", sql, "rows", rows, "duration_ms", elapsed.Milliseconds())
+	case g.slowThreshold > 0 && elapsed > g.slowThreshold && g.level >= logger.Warn:
+		sql, rows := fc()
+		g.log.WarnContext(ctx, "slow query", requestAttr(ctx),
+			"sql", sql, "rows", rows, "duration_ms", elapsed.Milliseconds())
+	case g.level >= logger.Info:
+		sql, rows := fc()
+		g.log.DebugContext(ctx, "query", requestAttr(ctx),
+			"sql", sql, "rows", rows, "duration_ms", elapsed.Milliseconds())
+	}
+}
+
+// ParamsFilter keeps bound values, such as password hashes, out of the logs
+func (g *GormLogger) ParamsFilter(ctx context.Context, sql string, params ...interface{}) (string, []interface{}) {
+	return sql, nil
+}
+
+func requestAttr(ctx context.Context) slog.Attr {
+	return slog.String("request_id", RequestID(ctx))
+}
@@ -0,0 +1,64 @@
+// internal/logging/logging.go
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+)
+
+// New returns a logger that writes JSON lines at or above level
+func New(w io.Writer, level slog.Level) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level}))
+}
+
+// requestFields is shared by everything handling one request. It is stored
+// as a pointer so that values learned deep in the handler chain, like the
+// authenticated user, are visible to the middleware that logs the request.
+type requestFields struct {
+	id     string
+	userID int64
+}
+
+type contextKey struct{}
+
+// WithRequestID starts the request-scoped fields for a request
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, &requestFields{id: id})
+}
+
+func fields(ctx context.Context) *requestFields {
+	f, _ := ctx.Value(contextKey{}).(*requestFields)
+	return f
+}
+
+// RequestID returns the ID of the request ctx belongs to, or ""
+func RequestID(ctx context.Context) string {
+	if f := fields(ctx); f != nil {
+		return f.id
+	}
+	return ""
+}
+
+// SetUserID records the authenticated user for the request ctx belongs to
+func SetUserID(ctx context.Context, id int64) {
+	if f := fields(ctx); f != nil {
+		f.userID = id
+	}
+}
+
+// UserID returns the authenticated user recorded by SetUserID, or 0
+func UserID(ctx context.Context) int64 {
+	if f := fields(ctx); f != nil {
+		return f.userID
+	}
+	return 0
+}
+
+// FromContext returns the default logger tagged with the request ID
+func FromContext(ctx context.Context) *slog.Logger {
+	if id := RequestID(ctx); id != "" {
+		return slog.Default().With("request_id", id)
+	}
+	return slog.Default()
+}
@@ -0,0 +1,145 @@
+// internal/mail/mail.go
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// Message is an email with a plain text body, an optional HTML
+// alternative and any attachments
+type Message struct {
+	From        string       `json:"from"`
+	To          []string     `json:"to"`
+	Subject     string       `json:"subject"`
+	Text        string       `json:"text"`
+	HTML        string       `json:"html"`
+	Attachments []Attachment `json:"attachments"`
+}
+
+// Attachment is a file sent with a message
+type Attachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Data        []byte `json:"data"`
+}
+
+// Sender delivers messages. Implementations must be safe for concurrent
+// use.
+type Sender interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// Bytes encodes the message in MIME form, dated now
+func (m *Message) Bytes(now time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	body := multipart.NewWriter(&buf)
+
+	header := func(name, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", name, value)
+	}
+	header("From", m.From)
+	header("To", strings.Join(m.To, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header("Date", now.Format(time.RFC1123Z))
+	header("Message-ID", messageID(m.From))
+	header("MIME-Version", "1.0")
+	header("Content-Type", "multipart/mixed; boundary="+body.Boundary())
+	buf.WriteString("\r\n")
+
+	// The text and HTML bodies are alternatives to each other
+	var alt bytes.Buffer
+	alternatives := multipart.NewWriter(&alt)
+	if err := writeText(alternatives, "text/plain; charset=utf-8", m.Text); err != nil {
+		return nil, err
+	}
+	if m.HTML != "" {
+		if err := writeText(alternatives, "text/html; charset=utf-8", m.HTML); err != nil {
+			return nil, err
+		}
+	}
+	if err := alternatives.Close(); err != nil {
+		return nil, err
+	}
+	part, err := body.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"multipart/alternative; boundary=" + alternatives.Boundary()},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := part.Write(alt.Bytes()); err != nil {
+		return nil, err
+	}
+
+	for _, a := range m.Attachments {
+		part, err := body.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {a.ContentType},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeBase64(part, a.Data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := body.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeText adds a quoted-printable text part
+func writeText(w *multipart.Writer, contentType, text string) error {
+	part, err := w.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+	qp := quotedprintable.NewWriter(part)
+	if _, err := qp.Write([]byte(text)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// writeBase64 writes data base64 encoded in lines of 76 characters, as
+// MIME requires
+func writeBase64(w io.Writer, data []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 0 {
+		n := min(len(encoded), 76)
+		if _, err := fmt.Fprintf(w, "%s\r\n", encoded[:n]); err != nil {
+			return err
+		}
+		encoded = encoded[n:]
+	}
+	return nil
+}
+
+// messageID returns a unique Message-ID in the sender's domain
+func messageID(from string) string {
+	domain := "localhost"
+	if addr, err := mail.ParseAddress(from); err == nil {
+		_, domain, _ = strings.Cut(addr.Address, "@")
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	return "<" + hex.EncodeToString(b) + "@" + domain + ">"
+}
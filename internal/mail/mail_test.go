@@ -0,0 +1,124 @@
+// internal/mail/mail_test.go
+package mail
+
+import (
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	netmail "net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testMessage() *Message {
+	return &Message{
+		From:    "Rooms <rooms@example.com>",
+		To:      []string{"Ada <ada@example.com>"},
+		Subject: "Reserva confirmada: Sala Ñ",
+		Text:    "Hello Ada,\nYour booking is confirmed.",
+		HTML:    "<p>Hello Ada,</p>",
+		Attachments: []Attachment{
+			{Filename: "invite.ics", ContentType: "text/calendar; charset=utf-8; method=REQUEST", Data: []byte(strings.Repeat("BEGIN:VCALENDAR\r\n", 10))},
+		},
+	}
+}
+
+// parts returns the content type and decoded body of each leaf part of raw
+func parts(t *testing.T, raw []byte) (*netmail.Message, map[string]string) {
+	t.Helper()
+	msg, err := netmail.ReadMessage(strings.NewReader(string(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(map[string]string)
+	var walk func(contentType string, body io.Reader, encoding string)
+	walk = func(contentType string, body io.Reader, encoding string) {
+		mediaType, params, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(mediaType, "multipart/") {
+			r := multipart.NewReader(body, params["boundary"])
+			for {
+				p, err := r.NextRawPart()
+				if err == io.EOF {
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				walk(p.Header.Get("Content-Type"), p, p.Header.Get("Content-Transfer-Encoding"))
+			}
+		}
+		if encoding == "quoted-printable" {
+			body = quotedprintable.NewReader(body)
+		}
+		b, err := io.ReadAll(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Text goes over the wire with CRLF line endings
+		out[mediaType] = strings.ReplaceAll(string(b), "\r\n", "\n")
+	}
+	walk(msg.Header.Get("Content-Type"), msg.Body, "")
+	return msg, out
+}
+
+func TestBytes(t *testing.T) {
+	m := testMessage()
+	raw, err := m.Bytes(time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, bodies := parts(t, raw)
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != m.Subject {
+		t.Errorf("Subject = %q, %v", subject, err)
+	}
+	if !strings.HasSuffix(msg.Header.Get("Message-ID"), "@example.com>") {
+		t.Errorf("Message-ID = %q, want one in the sender's domain", msg.Header.Get("Message-ID"))
+	}
+	if bodies["text/plain"] != m.Text {
+		t.Errorf("text part = %q", bodies["text/plain"])
+	}
+	if bodies["text/html"] != m.HTML {
+		t.Errorf("HTML part = %q", bodies["text/html"])
+	}
+	if !strings.Contains(string(raw), "filename=invite.ics") {
+		t.Error("attachment has no filename")
+	}
+	for _, line := range strings.Split(string(raw), "\r\n") {
+		if len(line) > 998 {
+			t.Errorf("line of %d characters", len(line))
+		}
+	}
+}
+
+func TestMaildirSender(t *testing.T) {
+	dir := t.TempDir()
+	s := &MaildirSender{Dir: dir}
+	if err := s.Send(context.Background(), testMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	delivered, err := os.ReadDir(filepath.Join(dir, "new"))
+	if err != nil || len(delivered) != 1 {
+		t.Fatalf("new holds %v, %v", delivered, err)
+	}
+	if left, _ := os.ReadDir(filepath.Join(dir, "tmp")); len(left) != 0 {
+		t.Errorf("tmp still holds %v", left)
+	}
+	raw, err := os.ReadFile(filepath.Join(dir, "new", delivered[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, bodies := parts(t, raw); bodies["text/plain"] != testMessage().Text {
+		t.Errorf("delivered text = %q", bodies["text/plain"])
+	}
+}
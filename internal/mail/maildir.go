@@ -0,0 +1,47 @@
+// internal/mail/maildir.go
+package mail
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// MaildirSender delivers mail into a Maildir instead of sending it, for
+// development and testing. Any mail client that reads Maildir can open
+// it, or the files can be read as they are.
+type MaildirSender struct {
+	Dir string
+}
+
+// Send writes msg to the Maildir's new folder. It is written under tmp
+// first and moved into place, so readers never see half a message.
+func (s *MaildirSender) Send(ctx context.Context, msg *Message) error {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(s.Dir, sub), 0o700); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	raw, err := msg.Bytes(now)
+	if err != nil {
+		return err
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	host, _ := os.Hostname()
+	name := fmt.Sprintf("%d.%s.%s", now.UnixNano(), hex.EncodeToString(id), host)
+
+	tmp := filepath.Join(s.Dir, "tmp", name)
+	if err := os.WriteFile(tmp, raw, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(s.Dir, "new", name))
+}
@@ -0,0 +1,94 @@
+// internal/mail/smtp.go
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+// implicitTLSPort is the submission port that speaks TLS from the start
+// rather than upgrading with STARTTLS
+const implicitTLSPort = 465
+
+// SMTPSender sends mail through an SMTP server. It upgrades to TLS when
+// the server offers STARTTLS, and only authenticates over TLS.
+type SMTPSender struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+}
+
+// Send delivers msg, giving up when ctx is done
+func (s *SMTPSender) Send(ctx context.Context, msg *Message) error {
+	raw, err := msg.Bytes(time.Now())
+	if err != nil {
+		return err
+	}
+	from, err := mail.ParseAddress(msg.From)
+	if err != nil {
+		return err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(s.Host, strconv.Itoa(s.Port)))
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	tlsConfig := &tls.Config{ServerName: s.Host, MinVersion: tls.VersionTLS12}
+	if s.Port == implicitTLSPort {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	c, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok && s.Port != implicitTLSPort {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if s.Username != "" {
+		// PlainAuth refuses to send the password unencrypted, except to
+		// localhost
+		if err := c.Auth(smtp.PlainAuth("", s.Username, s.Password, s.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(from.Address); err != nil {
+		return err
+	}
+	for _, to := range msg.To {
+		addr, err := mail.ParseAddress(to)
+		if err != nil {
+			return err
+		}
+		if err := c.Rcpt(addr.Address); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(raw); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
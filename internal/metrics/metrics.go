@@ -0,0 +1,138 @@
+// internal/metrics/metrics.go
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry holds every metric the server exposes on /metrics
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+var (
+	httpRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "skedda_http_requests_total",
+		Help: "HTTP requests served, by route template, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	httpDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "skedda_http_request_duration_seconds",
+		Help:    "Time taken to serve HTTP requests, by route template and method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	// BookingsCreated counts bookings created, by space ID and priority level
+	BookingsCreated = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "skedda_bookings_created_total",
+		Help: "Bookings created, by space and priority level.",
+	}, []string{"space", "priority"})
+
+	// BookingsCancelled counts bookings cancelled, by space ID and priority level
+	BookingsCancelled = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "skedda_bookings_cancelled_total",
+		Help: "Bookings cancelled, by space and priority level.",
+	}, []string{"space", "priority"})
+
+	// BookingsReleased counts bookings released as no-shows, by space ID
+	BookingsReleased = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "skedda_bookings_released_total",
+		Help: "Bookings released because nobody checked in, by space.",
+	}, []string{"space"})
+
+	// BookingConflicts counts bookings rejected for overlapping another, by space ID
+	BookingConflicts = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "skedda_booking_conflicts_total",
+		Help: "Booking requests rejected because they overlap an existing booking, by space.",
+	}, []string{"space"})
+
+	// LoginFailures counts failed logins, by reason
+	LoginFailures = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "skedda_login_failures_total",
+		Help: "Failed login attempts, by reason.",
+	}, []string{"reason"})
+
+	// WebhookDeliveries counts webhook delivery attempts, by event and the
+	// resulting delivery status
+	WebhookDeliveries = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "skedda_webhook_deliveries_total",
+		Help: "Webhook delivery attempts, by event and resulting status (pending means a retry is scheduled).",
+	}, []string{"event", "status"})
+
+	// JobRuns counts background job runs, by kind and outcome
+	JobRuns = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "skedda_job_runs_total",
+		Help: "Background job runs, by kind and outcome (succeeded, retry or dead).",
+	}, []string{"kind", "outcome"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// RegisterDB exposes the connection pool statistics of db
+func RegisterDB(db *sql.DB, name string) error {
+	return Registry.Register(collectors.NewDBStatsCollector(db, name))
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// Instrument is router middleware recording the count and latency of
+// requests. It labels by route template rather than path, so IDs in URLs
+// don't create a series per record.
+func Instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unmatched"
+		if current := mux.CurrentRoute(r); current != nil {
+			if tmpl, err := current.GetPathTemplate(); err == nil {
+				route = tmpl
+			}
+		}
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		httpDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+		httpRequests.WithLabelValues(route, r.Method, strconv.Itoa(rec.status)).Inc()
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if !s.wroteHeader {
+		s.status = code
+		s.wroteHeader = true
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	s.wroteHeader = true
+	return s.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
@@ -0,0 +1,75 @@
+// internal/middleware/realip.go
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// RealIP sets each request's RemoteAddr to the client's address when it
+// came through one of the trusted proxies, so rate limits, audit entries
+// and logs see the client rather than the proxy. The client is the last
+// address in X-Forwarded-For that isn't itself a trusted proxy; headers
+// from anyone else are ignored, as they could say anything.
+func RealIP(trusted []netip.Prefix) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(trusted) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if client, ok := forwardedFor(r, trusted); ok {
+				r2 := *r
+				r2.RemoteAddr = net.JoinHostPort(client.String(), "0")
+				r = &r2
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// forwardedFor returns the client address a trusted proxy passed on for r
+func forwardedFor(r *http.Request, trusted []netip.Prefix) (netip.Addr, bool) {
+	peer, ok := parseAddr(r.RemoteAddr)
+	if !ok || !isTrusted(peer, trusted) {
+		return netip.Addr{}, false
+	}
+
+	// Each proxy appends the address it got the request from, so walk back
+	// from the nearest hop until one isn't a proxy we trust
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	client, found := netip.Addr{}, false
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		client, found = addr.Unmap(), true
+		if !isTrusted(client, trusted) {
+			break
+		}
+	}
+	return client, found
+}
+
+func parseAddr(hostport string) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = hostport
+	}
+	addr, err := netip.ParseAddr(host)
+	return addr.Unmap(), err == nil
+}
+
+func isTrusted(addr netip.Addr, trusted []netip.Prefix) bool {
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
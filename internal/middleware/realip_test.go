@@ -0,0 +1,60 @@
+// internal/middleware/realip_test.go
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestRealIP(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("::1/128")}
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded []string
+		want      string
+	}{
+		{"direct client", "203.0.113.7:5000", nil, "203.0.113.7:5000"},
+		{"untrusted peer can't claim an address", "203.0.113.7:5000", []string{"198.51.100.1"}, "203.0.113.7:5000"},
+		{"trusted proxy", "10.0.0.2:5000", []string{"198.51.100.1"}, "198.51.100.1:0"},
+		{"chain of trusted proxies", "10.0.0.2:5000", []string{"198.51.100.1, 10.0.0.9"}, "198.51.100.1:0"},
+		{"spoofed hop before the client", "10.0.0.2:5000", []string{"1.2.3.4, 198.51.100.1"}, "198.51.100.1:0"},
+		{"repeated headers", "10.0.0.2:5000", []string{"1.2.3.4", "198.51.100.1"}, "198.51.100.1:0"},
+		{"IPv6 proxy", "[::1]:5000", []string{"2001:db8::1"}, "[2001:db8::1]:0"},
+		{"garbage", "10.0.0.2:5000", []string{"not-an-ip"}, "10.0.0.2:5000"},
+		{"no header", "10.0.0.2:5000", nil, "10.0.0.2:5000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			handler := RealIP(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.RemoteAddr
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			for _, v := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+			if got != tt.want {
+				t.Errorf("RemoteAddr = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRealIPWithoutTrustedProxies(t *testing.T) {
+	var got string
+	handler := RealIP(nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.RemoteAddr
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.2:5000"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if got != "10.0.0.2:5000" {
+		t.Errorf("RemoteAddr = %q, want the peer address", got)
+	}
+}
@@ -0,0 +1,272 @@
+// internal/mockidp/mockidp.go
+package mockidp
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"html/template"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// User is an identity the mock provider can sign in as
+type User struct {
+	Subject       string
+	Email         string
+	Name          string
+	EmailVerified bool
+}
+
+// Server is a minimal OpenID Connect provider for trying out and testing
+// single sign-on offline. It supports the authorization code flow with
+// PKCE and signs users in without a password: the user is picked by the
+// login_hint parameter, or from a list when there is more than one.
+type Server struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Users        []User
+
+	key   *rsa.PrivateKey
+	keyID string
+
+	mu     sync.Mutex
+	grants map[string]grant
+}
+
+// grant is an issued authorization code awaiting exchange
+type grant struct {
+	user        User
+	redirectURI string
+	nonce       string
+	challenge   string
+	expires     time.Time
+}
+
+// New returns a provider whose issuer URL is issuer. Mount it at the root
+// of the issuer, for example with httptest.NewServer.
+func New(issuer, clientID, clientSecret string, users ...User) (*Server, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{
+		Issuer:       strings.TrimSuffix(issuer, "/"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Users:        users,
+		key:          key,
+		keyID:        randomString(8),
+		grants:       make(map[string]grant),
+	}, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		s.discovery(w, r)
+	case "/authorize":
+		s.authorize(w, r)
+	case "/token":
+		s.token(w, r)
+	case "/jwks":
+		s.jwks(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                s.Issuer,
+		"authorization_endpoint":                s.Issuer + "/authorize",
+		"token_endpoint":                        s.Issuer + "/token",
+		"jwks_uri":                              s.Issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+var chooser = template.Must(template.New("chooser").Parse(`<!DOCTYPE html>
+<title>Mock identity provider</title>
+<h1>Sign in as</h1>
+<ul>{{range .Users}}<li><a href="{{$.URL}}&login_hint={{.Email}}">{{.Name}} &lt;{{.Email}}&gt;</a></li>{{end}}</ul>`))
+
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("response_type") != "code" || q.Get("client_id") != s.ClientID {
+		http.Error(w, "unsupported response_type or unknown client_id", http.StatusBadRequest)
+		return
+	}
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		http.Error(w, "PKCE with S256 is required", http.StatusBadRequest)
+		return
+	}
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || !redirect.IsAbs() {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := s.pickUser(q.Get("login_hint"))
+	if !ok {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		chooser.Execute(w, map[string]any{"Users": s.Users, "URL": r.URL.String()})
+		return
+	}
+
+	code := randomString(16)
+	s.mu.Lock()
+	s.grants[code] = grant{
+		user:        user,
+		redirectURI: redirect.String(),
+		nonce:       q.Get("nonce"),
+		challenge:   q.Get("code_challenge"),
+		expires:     time.Now().Add(time.Minute),
+	}
+	s.mu.Unlock()
+
+	values := redirect.Query()
+	values.Set("code", code)
+	values.Set("state", q.Get("state"))
+	redirect.RawQuery = values.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (s *Server) pickUser(hint string) (User, bool) {
+	if hint == "" && len(s.Users) == 1 {
+		return s.Users[0], true
+	}
+	for _, u := range s.Users {
+		if strings.EqualFold(u.Email, hint) {
+			return u, true
+		}
+	}
+	return User{}, false
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.ParseForm()
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != s.ClientID || subtle.ConstantTimeCompare([]byte(clientSecret), []byte(s.ClientSecret)) != 1 {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+
+	// Codes are single use, whether or not the exchange succeeds
+	s.mu.Lock()
+	g, ok := s.grants[r.PostForm.Get("code")]
+	delete(s.grants, r.PostForm.Get("code"))
+	s.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	switch {
+	case !ok || time.Now().After(g.expires):
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	case g.redirectURI != r.PostForm.Get("redirect_uri"):
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "redirect_uri mismatch"})
+		return
+	case base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+		return
+	}
+
+	now := time.Now()
+	idToken, err := s.sign(map[string]any{
+		"iss":            s.Issuer,
+		"sub":            g.user.Subject,
+		"aud":            s.ClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          g.nonce,
+		"email":          g.user.Email,
+		"email_verified": g.user.EmailVerified,
+		"name":           g.user.Name,
+	})
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": randomString(16),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func (s *Server) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := s.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": s.keyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+// sign returns claims as an RS256 JWT
+func (s *Server) sign(claims map[string]any) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": s.keyID})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,211 @@
+// internal/mockidp/mockidp_test.go
+package mockidp
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const redirectURI = "http://app.test/callback"
+
+func newServer(t *testing.T, users ...User) (*Server, *httptest.Server) {
+	t.Helper()
+	if len(users) == 0 {
+		users = []User{{Subject: "1", Email: "ada@example.com", Name: "Ada", EmailVerified: true}}
+	}
+	srv := httptest.NewServer(nil)
+	t.Cleanup(srv.Close)
+	idp, err := New(srv.URL+"/", "client", "secret", users...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Config.Handler = idp
+	return idp, srv
+}
+
+var noRedirects = &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+	return http.ErrUseLastResponse
+}}
+
+func challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// authorize starts a sign-on and returns the code from the redirect
+func authorize(t *testing.T, srv *httptest.Server, params url.Values) string {
+	t.Helper()
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {"client"},
+		"redirect_uri":          {redirectURI},
+		"state":                 {"st"},
+		"nonce":                 {"n"},
+		"code_challenge":        {challenge("verifier")},
+		"code_challenge_method": {"S256"},
+	}
+	for k, v := range params {
+		q[k] = v
+	}
+	resp, err := noRedirects.Get(srv.URL + "/authorize?" + q.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize returned %s", resp.Status)
+	}
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if location.Query().Get("state") != "st" {
+		t.Errorf("state not passed back: %s", location)
+	}
+	return location.Query().Get("code")
+}
+
+func exchange(srv *httptest.Server, code, verifier, redirect string) (*http.Response, map[string]any, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirect},
+		"code_verifier": {verifier},
+	}
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("client", "secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	var body map[string]any
+	json.NewDecoder(resp.Body).Decode(&body)
+	return resp, body, nil
+}
+
+func TestDiscoveryTrimsIssuer(t *testing.T) {
+	_, srv := newServer(t)
+	resp, err := http.Get(srv.URL + "/.well-known/openid-configuration")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var d map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		t.Fatal(err)
+	}
+	if d["issuer"] != srv.URL || d["token_endpoint"] != srv.URL+"/token" {
+		t.Errorf("discovery = %v, want endpoints under %s", d, srv.URL)
+	}
+}
+
+func TestCodeFlow(t *testing.T) {
+	idp, srv := newServer(t)
+	code := authorize(t, srv, nil)
+
+	resp, body, err := exchange(srv, code, "verifier", redirectURI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("token returned %s: %v", resp.Status, body)
+	}
+
+	// The ID token is signed with the key the JWKS endpoint publishes
+	raw, _ := body["id_token"].(string)
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		t.Fatalf("id_token %q isn't a JWT", raw)
+	}
+	signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(&idp.key.PublicKey, crypto.SHA256, digest[:], signature); err != nil {
+		t.Errorf("signature doesn't verify: %v", err)
+	}
+	payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	var claims map[string]any
+	json.Unmarshal(payload, &claims)
+	if claims["iss"] != srv.URL || claims["aud"] != "client" || claims["email"] != "ada@example.com" || claims["nonce"] != "n" {
+		t.Errorf("unexpected claims %v", claims)
+	}
+
+	// Codes can only be exchanged once
+	if resp, _, _ := exchange(srv, code, "verifier", redirectURI); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("second exchange returned %s", resp.Status)
+	}
+}
+
+func TestTokenRejectsBadExchange(t *testing.T) {
+	_, srv := newServer(t)
+	tests := []struct {
+		name, verifier, redirect string
+	}{
+		{"wrong verifier", "other", redirectURI},
+		{"other redirect", "verifier", "http://evil.test/callback"},
+	}
+	for _, tt := range tests {
+		code := authorize(t, srv, nil)
+		resp, body, err := exchange(srv, code, tt.verifier, tt.redirect)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusBadRequest || body["error"] != "invalid_grant" {
+			t.Errorf("%s: %s %v, want invalid_grant", tt.name, resp.Status, body)
+		}
+	}
+}
+
+func TestAuthorizeRequiresPKCE(t *testing.T) {
+	_, srv := newServer(t)
+	q := url.Values{"response_type": {"code"}, "client_id": {"client"}, "redirect_uri": {redirectURI}}
+	resp, err := noRedirects.Get(srv.URL + "/authorize?" + q.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("authorize without PKCE returned %s", resp.Status)
+	}
+}
+
+func TestAuthorizePicksUser(t *testing.T) {
+	_, srv := newServer(t,
+		User{Subject: "1", Email: "ada@example.com", Name: "Ada", EmailVerified: true},
+		User{Subject: "2", Email: "ben@example.com", Name: "Ben", EmailVerified: true},
+	)
+
+	// With several users and no hint, a chooser is shown
+	q := url.Values{
+		"response_type": {"code"}, "client_id": {"client"}, "redirect_uri": {redirectURI},
+		"code_challenge": {challenge("verifier")}, "code_challenge_method": {"S256"},
+	}
+	resp, err := noRedirects.Get(srv.URL + "/authorize?" + q.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		t.Errorf("authorize without a hint returned %s %s", resp.Status, resp.Header.Get("Content-Type"))
+	}
+
+	code := authorize(t, srv, url.Values{"login_hint": {"BEN@example.com"}})
+	_, body, err := exchange(srv, code, "verifier", redirectURI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := body["id_token"].(string)
+	payload, _ := base64.RawURLEncoding.DecodeString(strings.Split(raw, ".")[1])
+	if !strings.Contains(string(payload), `"sub":"2"`) {
+		t.Errorf("signed in as the wrong user: %s", payload)
+	}
+}
@@ -0,0 +1,25 @@
+// internal/models/apikey.go
+package models
+
+import "time"
+
+// APIKey lets a script act on behalf of a teacher, limited to its scopes.
+// Only a hash of the key is stored; Prefix is kept so people can tell
+// their keys apart.
+type APIKey struct {
+	ID         int64      `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	TeacherID  int64      `json:"teacher_id" gorm:"index"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	KeyHash    string     `json:"-" gorm:"uniqueIndex"`
+	Scopes     []string   `json:"scopes" gorm:"serializer:json"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+// Usable reports whether the key can authenticate requests at now
+func (k *APIKey) Usable(now time.Time) bool {
+	return k.RevokedAt == nil && now.Before(k.ExpiresAt)
+}
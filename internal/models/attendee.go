@@ -0,0 +1,62 @@
+// internal/models/attendee.go
+package models
+
+import "time"
+
+// Kinds of attendee
+const (
+	AttendeeStudent = "student"
+	AttendeeTeacher = "teacher"
+	AttendeeGuest   = "guest"
+)
+
+// RSVP responses. Declined attendees keep their place on the list but
+// don't count towards capacity or conflicts.
+const (
+	RSVPPending   = "pending"
+	RSVPAccepted  = "accepted"
+	RSVPTentative = "tentative"
+	RSVPDeclined  = "declined"
+)
+
+// RSVPs lists every RSVP response
+var RSVPs = []string{RSVPPending, RSVPAccepted, RSVPTentative, RSVPDeclined}
+
+// Attendance marks, shared with the desktop app. An attendee who hasn't
+// been marked has no attendance.
+const (
+	AttendancePresent = "present"
+	AttendanceLate    = "late"
+	AttendanceAbsent  = "absent"
+	AttendanceExcused = "excused"
+)
+
+// AttendanceMarks lists every attendance mark
+var AttendanceMarks = []string{AttendancePresent, AttendanceLate, AttendanceAbsent, AttendanceExcused}
+
+// Attendee is someone taking part in a booking: a student or teacher on
+// record, or a guest known only by name and email
+type Attendee struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	BookingID uint      `json:"booking_id" gorm:"not null;uniqueIndex:idx_attendee_student;uniqueIndex:idx_attendee_teacher"`
+	Kind      string    `json:"kind"`
+	StudentID *int64    `json:"student_id,omitempty" gorm:"uniqueIndex:idx_attendee_student;index"`
+	Student   *Student  `json:"student,omitempty" gorm:"constraint:OnDelete:CASCADE"`
+	TeacherID *int64    `json:"teacher_id,omitempty" gorm:"uniqueIndex:idx_attendee_teacher;index"`
+	Teacher   *Teacher  `json:"teacher,omitempty" gorm:"constraint:OnDelete:CASCADE"`
+	// Name and Email identify a guest
+	Name        string     `json:"name,omitempty"`
+	Email       string     `json:"email,omitempty"`
+	RSVP        string     `json:"rsvp"`
+	RespondedAt *time.Time `json:"responded_at,omitempty"`
+	// Attendance is who actually turned up, and who said so
+	Attendance string     `json:"attendance,omitempty" gorm:"not null;default:'';index"`
+	MarkedAt   *time.Time `json:"marked_at,omitempty"`
+	MarkedBy   *int64     `json:"marked_by,omitempty"`
+}
+
+// Counts reports whether a takes up a place in the booking
+func (a *Attendee) Counts() bool {
+	return a.RSVP != RSVPDeclined
+}
@@ -0,0 +1,23 @@
+// internal/models/audit.go
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// AuditEntry is one append-only record of an action taken through the API.
+// Changes holds the fields a write changed, each as {"before", "after"}.
+type AuditEntry struct {
+	ID         int64           `json:"id"`
+	CreatedAt  time.Time       `json:"created_at" gorm:"index"`
+	ActorID    *int64          `json:"actor_id" gorm:"index"`
+	APIKeyID   *int64          `json:"api_key_id,omitempty"`
+	Action     string          `json:"action" gorm:"index"`
+	EntityType string          `json:"entity_type" gorm:"index:idx_audit_entity"`
+	EntityID   string          `json:"entity_id" gorm:"index:idx_audit_entity"`
+	RequestID  string          `json:"request_id" gorm:"index"`
+	IP         string          `json:"ip"`
+	Details    json.RawMessage `json:"details,omitempty" gorm:"type:jsonb"`
+	Changes    json.RawMessage `json:"changes,omitempty" gorm:"type:jsonb"`
+}
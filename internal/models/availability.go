@@ -0,0 +1,22 @@
+// internal/models/availability.go
+package models
+
+// Kinds of people with availability calendars
+const (
+	OwnerTeacher = "teacher"
+	OwnerStudent = "student"
+)
+
+// AvailabilityWindow is a weekly span when a teacher or student can take
+// lessons, such as Mondays 09:00 to 12:00. Times are HH:MM in the booking
+// time zone. Someone with no windows is taken to be available whenever
+// spaces are open.
+type AvailabilityWindow struct {
+	ID        int64  `json:"id"`
+	OwnerType string `json:"owner_type" gorm:"index:idx_availability_owner"`
+	OwnerID   int64  `json:"owner_id" gorm:"index:idx_availability_owner"`
+	// Weekday counts from Sunday, as time.Weekday does
+	Weekday   int    `json:"weekday"`
+	StartTime string `json:"start_time"`
+	EndTime   string `json:"end_time"`
+}
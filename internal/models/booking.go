@@ -1,31 +1,79 @@
-// internal/models/booking.go
-package models
-
-import (
-	"time"
-
-	"gorm.io/gorm"
-)
-
-type PriorityLevel int
-
-const (
-	UnbaptizedContact    PriorityLevel = 1
-	PersecutedMember     PriorityLevel = 2
-	UnbaptizedZoom       PriorityLevel = 3
-	PersecutedZoomMember PriorityLevel = 4
-	BaptizedZoom         PriorityLevel = 5
-	GroupActivities      PriorityLevel = 6
-	TeamActivities       PriorityLevel = 7
-)
-
-type Booking struct {
-	gorm.Model // Adds fields `ID`, `CreatedAt`, `UpdatedAt`, `DeletedAt`
-	SpaceID    int64
-	StartTime  time.Time
-	EndTime    time.Time
-	User       string
-	Notes      string
-	Status     string
-	Priority   PriorityLevel
-}
+// internal/models/booking.go
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type PriorityLevel int
+
+const (
+	UnbaptizedContact    PriorityLevel = 1
+	PersecutedMember     PriorityLevel = 2
+	UnbaptizedZoom       PriorityLevel = 3
+	PersecutedZoomMember PriorityLevel = 4
+	BaptizedZoom         PriorityLevel = 5
+	GroupActivities      PriorityLevel = 6
+	TeamActivities       PriorityLevel = 7
+)
+
+var priorityNames = map[PriorityLevel]string{
+	UnbaptizedContact:    "UnbaptizedContact",
+	PersecutedMember:     "PersecutedMember",
+	UnbaptizedZoom:       "UnbaptizedZoom",
+	PersecutedZoomMember: "PersecutedZoomMember",
+	BaptizedZoom:         "BaptizedZoom",
+	GroupActivities:      "GroupActivities",
+	TeamActivities:       "TeamActivities",
+}
+
+func (p PriorityLevel) String() string {
+	if name, ok := priorityNames[p]; ok {
+		return name
+	}
+	return "Unknown"
+}
+
+// Valid reports whether p is one of the defined priority levels
+func (p PriorityLevel) Valid() bool {
+	_, ok := priorityNames[p]
+	return ok
+}
+
+// Booking statuses, shared with the desktop app. A booking nobody checked
+// in to is released as a no-show. A booking of a space that requires
+// approval is pending until an admin approves it, holding the space
+// meanwhile.
+const (
+	StatusPending   = "Pending"
+	StatusConfirmed = "Confirmed"
+	StatusCancelled = "Cancelled"
+	StatusNoShow    = "NoShow"
+)
+
+// InactiveStatuses are the statuses of bookings that no longer hold their
+// space or their attendees' time
+var InactiveStatuses = []string{StatusCancelled, StatusNoShow}
+
+type Booking struct {
+	gorm.Model               // Adds fields `ID`, `CreatedAt`, `UpdatedAt`, `DeletedAt`
+	SpaceID    int64         `json:"space_id"`
+	StartTime  time.Time     `json:"start_time"`
+	EndTime    time.Time     `json:"end_time"`
+	User       string        `json:"user"`
+	Notes      string        `json:"notes"`
+	Status     string        `json:"status"`
+	Priority   PriorityLevel `json:"priority"`
+	// CheckedInAt is when someone claimed the booking at the space
+	CheckedInAt *time.Time `json:"checked_in_at"`
+	// A booking for a lesson links the teacher and the subject, and lists
+	// its students among the attendees. User stays free text for bookings
+	// that aren't lessons.
+	TeacherID *int64     `json:"teacher_id" gorm:"index"`
+	Teacher   *Teacher   `json:"teacher,omitempty" gorm:"constraint:OnDelete:SET NULL"`
+	SubjectID *int64     `json:"subject_id" gorm:"index"`
+	Subject   *Subject   `json:"subject,omitempty" gorm:"constraint:OnDelete:SET NULL"`
+	Attendees []Attendee `json:"attendees,omitempty" gorm:"constraint:OnDelete:CASCADE"`
+}
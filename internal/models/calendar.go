@@ -0,0 +1,24 @@
+// internal/models/calendar.go
+package models
+
+import "time"
+
+// Kinds of calendar feed, by whose bookings they list
+const (
+	FeedSpace   = "space"
+	FeedTeacher = "teacher"
+	FeedStudent = "student"
+)
+
+// CalendarFeed is a secret URL serving the bookings of one space, teacher
+// or student as iCalendar, for subscribing to from calendar apps. Only a
+// hash of the URL's token is stored; rotating the token breaks the old URL.
+type CalendarFeed struct {
+	ID        int64      `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	OwnerID   int64      `json:"owner_id" gorm:"index"`
+	Kind      string     `json:"kind"`
+	EntityID  int64      `json:"entity_id"`
+	TokenHash string     `json:"-" gorm:"uniqueIndex"`
+	RotatedAt *time.Time `json:"rotated_at"`
+}
@@ -0,0 +1,19 @@
+// internal/models/event.go
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Event is a change pushed to live clients. Events are kept for a while
+// after they are sent, so clients that reconnect can catch up on what
+// they missed. Resource is the API resource the event belongs to, such as
+// "bookings", which decides who may see it.
+type Event struct {
+	ID        int64           `json:"id"`
+	CreatedAt time.Time       `json:"created_at" gorm:"index"`
+	Type      string          `json:"type"`
+	Resource  string          `json:"resource"`
+	Data      json.RawMessage `json:"data" gorm:"type:jsonb"`
+}
@@ -0,0 +1,35 @@
+// internal/models/job.go
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// States of a background job. A recurring job goes back to pending after
+// each run, coming due again each Interval. A running job's RunAt is when
+// its lease runs out.
+const (
+	JobPending   = "pending"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobDead      = "dead"
+)
+
+// Job is a unit of background work, run once RunAt has passed. Key, when
+// set, keeps a job from being queued twice.
+type Job struct {
+	ID        int64           `json:"id"`
+	CreatedAt time.Time       `json:"created_at"`
+	Kind      string          `json:"kind" gorm:"index"`
+	Key       *string         `json:"key" gorm:"uniqueIndex"`
+	Payload   json.RawMessage `json:"payload" gorm:"type:jsonb"`
+	Status    string          `json:"status" gorm:"index:idx_job_due"`
+	RunAt     time.Time       `json:"run_at" gorm:"index:idx_job_due"`
+	// Interval is how often a recurring job runs, zero for one-off jobs
+	Interval   time.Duration `json:"interval"`
+	Attempts   int           `json:"attempts"`
+	LastRunAt  *time.Time    `json:"last_run_at"`
+	LastError  string        `json:"last_error"`
+	FinishedAt *time.Time    `json:"finished_at" gorm:"index"`
+}
@@ -1,18 +1,20 @@
-// internal/models/models.go
-package models
-
-type Student struct {
-	ID   int64  `json:"id" gorm:"primaryKey"`
-	Name string `json:"name"`
-}
-
-type Subject struct {
-	ID          int64  `json:"id" gorm:"primaryKey"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
-}
-
-type StudentSubject struct {
-	StudentID int64 `json:"student_id" gorm:"primaryKey"`
-	SubjectID int64 `json:"subject_id" gorm:"primaryKey"`
-}
+// internal/models/models.go
+package models
+
+type Student struct {
+	ID   int64  `json:"id" gorm:"primaryKey"`
+	Name string `json:"name"`
+	// Email is where the student is sent notifications, if anywhere
+	Email string `json:"email,omitempty"`
+}
+
+type Subject struct {
+	ID          int64  `json:"id" gorm:"primaryKey"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type StudentSubject struct {
+	StudentID int64 `json:"student_id" gorm:"primaryKey"`
+	SubjectID int64 `json:"subject_id" gorm:"primaryKey"`
+}
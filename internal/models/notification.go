@@ -0,0 +1,70 @@
+// internal/models/notification.go
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+// Channels notifications can be sent through
+const (
+	ChannelEmail = "email"
+)
+
+// NotificationChannels lists every notification channel
+var NotificationChannels = []string{ChannelEmail}
+
+// Ways of delivering notifications: each as it happens, or booking changes
+// batched into one message a day
+const (
+	DeliveryImmediate = "immediate"
+	DeliveryDigest    = "digest"
+)
+
+// DefaultDigestTime is when daily digests go out for people who haven't
+// picked a time
+const DefaultDigestTime = "07:00"
+
+// NotificationPreference is how a teacher or student wants to hear about
+// their bookings. Someone without one is sent everything as it happens.
+type NotificationPreference struct {
+	ID        int64     `json:"id"`
+	UpdatedAt time.Time `json:"updated_at"`
+	OwnerType string    `json:"owner_type" gorm:"uniqueIndex:idx_notification_owner"`
+	OwnerID   int64     `json:"owner_id" gorm:"uniqueIndex:idx_notification_owner"`
+	// Channels they are notified through; none turns notifications off
+	Channels []string `json:"channels" gorm:"serializer:json"`
+	// Events are the booking events they are notified of
+	Events []string `json:"events" gorm:"serializer:json"`
+	// QuietStart and QuietEnd, HH:MM in the booking time zone, bound a
+	// daily span in which nothing is sent. It may run past midnight, and
+	// is unset when both are empty.
+	QuietStart string `json:"quiet_start"`
+	QuietEnd   string `json:"quiet_end"`
+	// Delivery is immediate or digest. Digests hold booking changes until
+	// DigestTime; reminders still go out on time.
+	Delivery   string `json:"delivery"`
+	DigestTime string `json:"digest_time"`
+}
+
+// Wants reports whether the owner is to be notified of event over channel
+func (p *NotificationPreference) Wants(channel, event string) bool {
+	return slices.Contains(p.Channels, channel) && slices.Contains(p.Events, event)
+}
+
+// DigestEntry is a booking change held back for someone's daily digest
+type DigestEntry struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	OwnerType string    `json:"owner_type"`
+	OwnerID   int64     `json:"owner_id"`
+	// Name, Email and Locale are the recipient's when the change was made
+	Name      string `json:"name"`
+	Email     string `json:"email"`
+	Locale    string `json:"locale"`
+	Event     string `json:"event"`
+	BookingID uint   `json:"booking_id"`
+	// DueAt is when the digest holding the change is sent
+	DueAt  time.Time  `json:"due_at" gorm:"index:idx_digest_due"`
+	SentAt *time.Time `json:"sent_at" gorm:"index:idx_digest_due"`
+}
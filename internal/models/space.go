@@ -0,0 +1,23 @@
+// internal/models/space.go
+package models
+
+import "time"
+
+// Space is a bookable room or area
+type Space struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Name      string    `json:"name" gorm:"uniqueIndex"`
+	// Capacity is how many people the space holds, 0 if unknown
+	Capacity  int      `json:"capacity"`
+	Amenities []string `json:"amenities" gorm:"serializer:json"`
+	// CheckInMinutes is how long after a booking starts it must be
+	// checked in to before it is released, 0 if check-in isn't required
+	CheckInMinutes int `json:"check_in_minutes"`
+	// RequiresApproval holds bookings of the space, other than admins',
+	// pending until an admin approves them
+	RequiresApproval bool `json:"requires_approval"`
+	// CheckInTokenHash identifies the space's check-in QR code
+	CheckInTokenHash string `json:"-" gorm:"index"`
+}
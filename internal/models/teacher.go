@@ -1,26 +1,67 @@
-// internal/models/teacher.go
-package models
-
-import "golang.org/x/crypto/bcrypt"
-
-type Teacher struct {
-	ID           int64  `json:"id"`
-	Name         string `json:"name"`
-	Email        string `json:"email"`
-	PasswordHash string `json:"-"`
-}
-
-// SetPassword hashes and sets the teacher's password
-func (t *Teacher) SetPassword(password string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return err
-	}
-	t.PasswordHash = string(hash)
-	return nil
-}
-
-// CheckPassword verifies if the password matches the hashed password
-func (t *Teacher) CheckPassword(password string) bool {
-	return bcrypt.CompareHashAndPassword([]byte(t.PasswordHash), []byte(password)) == nil
-}
+// internal/models/teacher.go
+package models
+
+import (
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Roles a teacher account can hold. Admins can manage other accounts.
+const (
+	RoleTeacher = "teacher"
+	RoleAdmin   = "admin"
+)
+
+type Teacher struct {
+	ID           int64  `json:"id"`
+	Name         string `json:"name"`
+	Email        string `json:"email"`
+	Role         string `json:"role" gorm:"default:teacher"`
+	PasswordHash string `json:"-"`
+	// Consecutive failed logins, and when the resulting lockout ends
+	FailedLogins int        `json:"-"`
+	LockedUntil  *time.Time `json:"-"`
+	// TOTPSecret is set when enrollment starts; TOTPEnabled once the
+	// teacher has confirmed it with a code. TOTPLastStep is the time step
+	// of the last accepted code, which can't be used again.
+	TOTPSecret   string `json:"-"`
+	TOTPEnabled  bool   `json:"totp_enabled"`
+	TOTPLastStep int64  `json:"-"`
+	// Locale picks the language of the emails the teacher is sent, such
+	// as "en" or "es"; empty means the server default
+	Locale string `json:"locale"`
+}
+
+// RecoveryCode is a hashed single-use code that stands in for a TOTP code
+type RecoveryCode struct {
+	ID        int64      `json:"id"`
+	TeacherID int64      `json:"teacher_id" gorm:"index"`
+	CodeHash  string     `json:"-" gorm:"uniqueIndex"`
+	UsedAt    *time.Time `json:"used_at"`
+}
+
+// IsLocked reports whether the account is locked out at now
+func (t *Teacher) IsLocked(now time.Time) bool {
+	return t.LockedUntil != nil && now.Before(*t.LockedUntil)
+}
+
+// IsAdmin reports whether the teacher holds the admin role
+func (t *Teacher) IsAdmin() bool {
+	return t.Role == RoleAdmin
+}
+
+// SetPassword hashes and sets the teacher's password
+func (t *Teacher) SetPassword(password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	t.PasswordHash = string(hash)
+	return nil
+}
+
+// CheckPassword verifies if the password matches the hashed password
+func (t *Teacher) CheckPassword(password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(t.PasswordHash), []byte(password)) == nil
+}
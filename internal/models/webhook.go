@@ -0,0 +1,70 @@
+// internal/models/webhook.go
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Events a webhook can subscribe to, which are also streamed to live
+// clients
+const (
+	EventBookingCreated         = "booking.created"
+	EventBookingApproved        = "booking.approved"
+	EventBookingUpdated         = "booking.updated"
+	EventBookingCancelled       = "booking.cancelled"
+	EventBookingNoShow          = "booking.no_show"
+	EventBookingReminder        = "booking.reminder"
+	EventStudentCreated         = "student.created"
+	EventStudentAssignedSubject = "student.assigned_subject"
+	EventSubjectCreated         = "subject.created"
+	EventSpaceCreated           = "space.created"
+	EventSpaceUpdated           = "space.updated"
+)
+
+// EventBookingApprovalRequested asks admins to approve a pending booking.
+// It is only emailed, never published.
+const EventBookingApprovalRequested = "booking.approval_requested"
+
+// WebhookEvents lists every event a webhook can subscribe to
+var WebhookEvents = []string{
+	EventBookingCreated, EventBookingApproved, EventBookingUpdated, EventBookingCancelled, EventBookingNoShow, EventBookingReminder,
+	EventStudentCreated, EventStudentAssignedSubject, EventSubjectCreated,
+	EventSpaceCreated, EventSpaceUpdated,
+}
+
+// WebhookSubscription sends the events it lists to URL. Payloads are
+// signed with Secret, which is kept as is because signing needs it.
+type WebhookSubscription struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	CreatedBy int64     `json:"created_by"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"-"`
+	Events    []string  `json:"events" gorm:"serializer:json"`
+	Active    bool      `json:"active"`
+}
+
+// States of a webhook delivery
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryDead      = "dead"
+)
+
+// WebhookDelivery is one event queued for one subscription. Pending
+// deliveries are attempted once NextAttemptAt has passed.
+type WebhookDelivery struct {
+	ID             int64           `json:"id"`
+	CreatedAt      time.Time       `json:"created_at"`
+	SubscriptionID int64           `json:"subscription_id" gorm:"index"`
+	EventID        string          `json:"event_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload" gorm:"type:jsonb"`
+	Status         string          `json:"status" gorm:"index:idx_webhook_due"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at" gorm:"index:idx_webhook_due"`
+	LastAttemptAt  *time.Time      `json:"last_attempt_at"`
+	ResponseStatus int             `json:"response_status"`
+	LastError      string          `json:"last_error"`
+}
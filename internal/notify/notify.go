@@ -0,0 +1,400 @@
+// internal/notify/notify.go
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	netmail "net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"skedda-goclone/internal/ical"
+	"skedda-goclone/internal/jobs"
+	"skedda-goclone/internal/mail"
+	"skedda-goclone/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// Job kinds
+const (
+	// KindBooking works out who to email about a booking event and queues
+	// their emails
+	KindBooking = "notify.booking"
+	// KindSend sends one email
+	KindSend = "notify.send"
+)
+
+// eventTemplates maps the booking events people are emailed about to the
+// email they get
+var eventTemplates = map[string]string{
+	models.EventBookingCreated:           TemplateConfirmation,
+	models.EventBookingUpdated:           TemplateChange,
+	models.EventBookingCancelled:         TemplateCancellation,
+	models.EventBookingReminder:          TemplateReminder,
+	models.EventBookingApprovalRequested: TemplateApprovalRequest,
+}
+
+// bookingEvent is the payload of a KindBooking job
+type bookingEvent struct {
+	Event     string `json:"event"`
+	BookingID uint   `json:"booking_id"`
+}
+
+// Enqueue queues emails about event to the people b concerns. Pass the
+// transaction that made the change, so nobody is told about a change that
+// didn't happen. Events nobody is emailed about are ignored.
+func Enqueue(tx *gorm.DB, event string, b *models.Booking) error {
+	switch {
+	case event == models.EventBookingCreated && b.Status == models.StatusPending:
+		// Until it is approved, a booking is only news to the admins
+		event = models.EventBookingApprovalRequested
+	case event == models.EventBookingApproved:
+		// and once it is, it is confirmed to the people it is for
+		event = models.EventBookingCreated
+	}
+	if _, ok := eventTemplates[event]; !ok {
+		return nil
+	}
+	return jobs.Enqueue(tx, KindBooking, bookingEvent{Event: event, BookingID: b.ID}, time.Now())
+}
+
+// View is what email templates are rendered with. Name is the reader's,
+// and times are in the time zone bookings are made in.
+type View struct {
+	Name      string
+	Event     string
+	Space     string
+	Start     time.Time
+	End       time.Time
+	Subject   string
+	Teacher   string
+	Attendees []string
+	Notes     string
+	Booking   *models.Booking
+}
+
+// recipient is someone emailed about a booking. OwnerType and OwnerID name
+// the teacher or student they are, whose preferences apply; guests have
+// neither.
+type recipient struct {
+	Name      string
+	Email     string
+	Locale    string
+	OwnerType string
+	OwnerID   int64
+}
+
+// recipients returns the people to email about b: its teacher and every
+// attendee with an email address who hasn't declined
+func recipients(b *models.Booking) []recipient {
+	var out []recipient
+	seen := make(map[string]bool)
+	add := func(r recipient) {
+		key := strings.ToLower(strings.TrimSpace(r.Email))
+		if key == "" || seen[key] {
+			return
+		}
+		seen[key] = true
+		r.Email = strings.TrimSpace(r.Email)
+		out = append(out, r)
+	}
+	if t := b.Teacher; t != nil {
+		add(recipient{Name: t.Name, Email: t.Email, Locale: t.Locale, OwnerType: models.OwnerTeacher, OwnerID: t.ID})
+	}
+	for _, a := range b.Attendees {
+		if !a.Counts() {
+			continue
+		}
+		switch {
+		case a.Teacher != nil:
+			add(recipient{Name: a.Teacher.Name, Email: a.Teacher.Email, Locale: a.Teacher.Locale, OwnerType: models.OwnerTeacher, OwnerID: a.Teacher.ID})
+		case a.Student != nil:
+			email := a.Email
+			if email == "" {
+				email = a.Student.Email
+			}
+			add(recipient{Name: a.Student.Name, Email: email, OwnerType: models.OwnerStudent, OwnerID: a.Student.ID})
+		default:
+			add(recipient{Name: a.Name, Email: a.Email})
+		}
+	}
+	return out
+}
+
+// current reports whether event still describes b. Emails are prepared a
+// while after the change they follow, by which time the booking may have
+// moved on: one cancelled before its confirmation went out needs no
+// confirmation, and one already approved or declined needs no approver.
+// Otherwise the email describes the booking as it is now.
+func current(event string, b *models.Booking) bool {
+	switch event {
+	case models.EventBookingCancelled:
+		return b.Status == models.StatusCancelled
+	case models.EventBookingApprovalRequested:
+		return b.Status == models.StatusPending
+	}
+	return b.Status == models.StatusConfirmed
+}
+
+// approvers returns the admins, who are asked to approve pending bookings
+func approvers(db *gorm.DB) ([]recipient, error) {
+	var admins []models.Teacher
+	if err := db.Where("role = ?", models.RoleAdmin).Order("id").Find(&admins).Error; err != nil {
+		return nil, err
+	}
+	var out []recipient
+	for _, t := range admins {
+		if t.Email != "" {
+			out = append(out, recipient{Name: t.Name, Email: t.Email, Locale: t.Locale, OwnerType: models.OwnerTeacher, OwnerID: t.ID})
+		}
+	}
+	return out, nil
+}
+
+// UIDHost names the server in calendar event UIDs: the host of its public
+// URL. Feeds and invites must both use it, as UIDs must match for calendars
+// to treat them as the same event, and must stay the same across restarts
+// and servers.
+func UIDHost(publicURL string) string {
+	if u, err := url.Parse(publicURL); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return "localhost"
+}
+
+// BookingEvent converts a booking to a calendar event. host, from UIDHost,
+// names the server in the event's UID.
+func BookingEvent(host string, b *models.Booking, spaceName string) ical.Event {
+	if spaceName == "" {
+		spaceName = "Space " + strconv.FormatInt(b.SpaceID, 10)
+	}
+	summary := spaceName
+	if b.User != "" {
+		summary = b.User + " (" + spaceName + ")"
+	}
+	if b.Subject != nil {
+		summary = b.Subject.Name + ": " + summary
+	}
+	organizer := ""
+	if b.Teacher != nil {
+		organizer = b.Teacher.Email
+	}
+
+	status := ical.StatusTentative
+	switch b.Status {
+	case models.StatusConfirmed:
+		status = ical.StatusConfirmed
+	case models.StatusCancelled, models.StatusNoShow:
+		status = ical.StatusCancelled
+	}
+
+	return ical.Event{
+		UID: fmt.Sprintf("booking-%d@%s", b.ID, host),
+		// Each save moves UpdatedAt on, so this grows with every change
+		Sequence:     int64(b.UpdatedAt.Sub(b.CreatedAt) / time.Second),
+		Stamp:        b.UpdatedAt,
+		Start:        b.StartTime,
+		End:          b.EndTime,
+		Summary:      summary,
+		Location:     spaceName,
+		Status:       status,
+		Organizer:    organizer,
+		LastModified: b.UpdatedAt,
+	}
+}
+
+// Notifier emails people about their bookings, through jobs so a mail
+// server being down only delays them
+type Notifier struct {
+	DB *gorm.DB
+	// Sender delivers the emails; without one nothing is sent
+	Sender    mail.Sender
+	Templates *Templates
+	From      string
+	// Location is the time zone bookings are shown in
+	Location *time.Location
+	// Host names the server in calendar invites; see UIDHost
+	Host string
+}
+
+// Prepare handles a KindBooking job: it renders the email for everyone
+// the booking concerns, in their own locale, and queues each to be sent.
+// Approval requests go to the admins instead. Their preferences decide
+// whether they get it at all, whether it waits out their quiet hours, and
+// whether it is held for their daily digest. Events the booking has since
+// moved on from are dropped.
+func (n *Notifier) Prepare(ctx context.Context, job *models.Job) error {
+	if n.Sender == nil {
+		return nil
+	}
+	var p bookingEvent
+	if err := json.Unmarshal(job.Payload, &p); err != nil {
+		return err
+	}
+	name, ok := eventTemplates[p.Event]
+	if !ok {
+		return nil
+	}
+
+	db := n.DB.WithContext(ctx)
+	var b models.Booking
+	err := db.Preload("Teacher").Preload("Subject").
+		Preload("Attendees", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		Preload("Attendees.Teacher").Preload("Attendees.Student").
+		First(&b, p.BookingID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !current(p.Event, &b) {
+		return nil
+	}
+	var space models.Space
+	if err := db.Select("name").Find(&space, b.SpaceID).Error; err != nil {
+		return err
+	}
+
+	to := recipients(&b)
+	if p.Event == models.EventBookingApprovalRequested {
+		if to, err = approvers(db); err != nil {
+			return err
+		}
+	}
+	if len(to) == 0 {
+		return nil
+	}
+	view := n.view(&b, p.Event, space.Name)
+	// Approvers aren't going, so get no invitation
+	var attachments []mail.Attachment
+	if p.Event != models.EventBookingApprovalRequested {
+		attachments = append(attachments, n.invite(&b, p.Event, space.Name, to))
+	}
+
+	now := time.Now()
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, rcpt := range to {
+			pref := DefaultPreference(rcpt.OwnerType, rcpt.OwnerID)
+			if rcpt.OwnerType != "" {
+				loaded, err := LoadPreference(tx, rcpt.OwnerType, rcpt.OwnerID)
+				if err != nil {
+					return err
+				}
+				pref = loaded
+			}
+			if !pref.Wants(models.ChannelEmail, p.Event) {
+				continue
+			}
+			// Reminders and approval requests are only any use on time, so
+			// are never held back
+			urgent := p.Event == models.EventBookingReminder || p.Event == models.EventBookingApprovalRequested
+			if pref.Delivery == models.DeliveryDigest && !urgent {
+				entry := models.DigestEntry{
+					OwnerType: rcpt.OwnerType, OwnerID: rcpt.OwnerID,
+					Name: rcpt.Name, Email: rcpt.Email, Locale: rcpt.Locale,
+					Event: p.Event, BookingID: b.ID, DueAt: nextDigest(&pref, now, n.Location),
+				}
+				if err := tx.Create(&entry).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			runAt := sendAt(&pref, now, n.Location)
+			if p.Event == models.EventBookingReminder && !runAt.Before(b.StartTime) {
+				continue
+			}
+
+			v := view
+			v.Name = rcpt.Name
+			subject, text, html, err := n.Templates.Render(name, rcpt.Locale, v)
+			if err != nil {
+				return err
+			}
+			msg := mail.Message{
+				From:        n.From,
+				To:          []string{(&netmail.Address{Name: rcpt.Name, Address: rcpt.Email}).String()},
+				Subject:     subject,
+				Text:        text,
+				HTML:        html,
+				Attachments: attachments,
+			}
+			// Running this job again mustn't send everyone a second copy
+			key := fmt.Sprintf("%s:%d:%s", KindSend, job.ID, strings.ToLower(rcpt.Email))
+			if err := jobs.EnqueueOnce(tx, KindSend, key, msg, runAt); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Send handles a KindSend job by sending its email
+func (n *Notifier) Send(ctx context.Context, job *models.Job) error {
+	if n.Sender == nil {
+		return nil
+	}
+	var msg mail.Message
+	if err := json.Unmarshal(job.Payload, &msg); err != nil {
+		return err
+	}
+	return n.Sender.Send(ctx, &msg)
+}
+
+// view returns the template data for b, for a reader to be filled in
+func (n *Notifier) view(b *models.Booking, event, spaceName string) View {
+	v := View{
+		Event:   event,
+		Space:   spaceName,
+		Start:   b.StartTime.In(n.Location),
+		End:     b.EndTime.In(n.Location),
+		Notes:   b.Notes,
+		Booking: b,
+	}
+	if b.Subject != nil {
+		v.Subject = b.Subject.Name
+	}
+	if b.Teacher != nil {
+		v.Teacher = b.Teacher.Name
+	}
+	for _, a := range b.Attendees {
+		if !a.Counts() {
+			continue
+		}
+		switch {
+		case a.Student != nil:
+			v.Attendees = append(v.Attendees, a.Student.Name)
+		case a.Teacher != nil:
+			v.Attendees = append(v.Attendees, a.Teacher.Name)
+		case a.Name != "":
+			v.Attendees = append(v.Attendees, a.Name)
+		}
+	}
+	if len(v.Attendees) == 0 && b.User != "" {
+		v.Attendees = []string{b.User}
+	}
+	return v
+}
+
+// invite returns the calendar invitation sent with emails about b. A
+// cancellation withdraws the event from calendars that accepted it.
+func (n *Notifier) invite(b *models.Booking, event, spaceName string, to []recipient) mail.Attachment {
+	e := BookingEvent(n.Host, b, spaceName)
+	for _, r := range to {
+		e.Attendees = append(e.Attendees, r.Email)
+	}
+	cal := ical.Calendar{TimeZone: n.Location.String(), Method: "REQUEST", Events: []ical.Event{e}}
+	if event == models.EventBookingCancelled {
+		cal.Method = "CANCEL"
+	}
+	return mail.Attachment{
+		Filename:    "invite.ics",
+		ContentType: "text/calendar; charset=utf-8; method=" + cal.Method,
+		Data:        cal.Marshal(),
+	}
+}
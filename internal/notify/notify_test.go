@@ -0,0 +1,233 @@
+// internal/notify/notify_test.go
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"skedda-goclone/internal/dbtest"
+	"skedda-goclone/internal/mail"
+	"skedda-goclone/internal/models"
+
+	"gorm.io/gorm"
+)
+
+func TestUIDHost(t *testing.T) {
+	for publicURL, want := range map[string]string{
+		"https://rooms.example.com":      "rooms.example.com",
+		"https://rooms.example.com/app/": "rooms.example.com",
+		"http://localhost:8080":          "localhost:8080",
+		"":                               "localhost",
+		"not a url":                      "localhost",
+	} {
+		if got := UIDHost(publicURL); got != want {
+			t.Errorf("UIDHost(%q) = %q, want %q", publicURL, got, want)
+		}
+	}
+}
+
+func TestBookingEventUID(t *testing.T) {
+	b := &models.Booking{SpaceID: 2}
+	b.ID = 7
+	e := BookingEvent(UIDHost("https://rooms.example.com/"), b, "")
+	if e.UID != "booking-7@rooms.example.com" {
+		t.Errorf("UID = %q", e.UID)
+	}
+	if !strings.Contains(e.Summary, "Space 2") {
+		t.Errorf("Summary = %q, want the space's ID when it has no name", e.Summary)
+	}
+}
+
+func TestCurrent(t *testing.T) {
+	for _, tt := range []struct {
+		event, status string
+		want          bool
+	}{
+		{models.EventBookingCreated, models.StatusConfirmed, true},
+		// A confirmation that runs after the booking was cancelled
+		{models.EventBookingCreated, models.StatusCancelled, false},
+		{models.EventBookingUpdated, models.StatusNoShow, false},
+		{models.EventBookingReminder, models.StatusPending, false},
+		{models.EventBookingCancelled, models.StatusCancelled, true},
+		{models.EventBookingApprovalRequested, models.StatusPending, true},
+		// Approved or declined before the request went out
+		{models.EventBookingApprovalRequested, models.StatusConfirmed, false},
+		{models.EventBookingApprovalRequested, models.StatusCancelled, false},
+	} {
+		if got := current(tt.event, &models.Booking{Status: tt.status}); got != tt.want {
+			t.Errorf("current(%s) of a %s booking = %v, want %v", tt.event, tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestRecipients(t *testing.T) {
+	teacher := &models.Teacher{ID: 1, Name: "Ada", Email: "Ada@example.com"}
+	student := &models.Student{ID: 2, Name: "Ben", Email: "ben@example.com"}
+	b := &models.Booking{
+		Teacher: teacher,
+		Attendees: []models.Attendee{
+			{Kind: models.AttendeeStudent, Student: student, RSVP: models.RSVPAccepted},
+			// The teacher again, in a different case
+			{Kind: models.AttendeeGuest, Name: "Ada", Email: " ada@example.com ", RSVP: models.RSVPAccepted},
+			{Kind: models.AttendeeGuest, Name: "Cy", Email: "cy@example.com", RSVP: models.RSVPDeclined},
+			{Kind: models.AttendeeGuest, Name: "No email", RSVP: models.RSVPAccepted},
+		},
+	}
+	var got []string
+	for _, r := range recipients(b) {
+		got = append(got, r.Email)
+	}
+	if strings.Join(got, ",") != "Ada@example.com,ben@example.com" {
+		t.Errorf("recipients = %v", got)
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	templates := &Templates{DefaultLocale: "en"}
+	if err := templates.Check(); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	view := View{Name: "Ada", Space: "Room A", Start: start, End: start.Add(time.Hour)}
+	subject, text, html, err := templates.Render(TemplateApprovalRequest, "es-MX", view)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject != "Aprobación pendiente: Room A, 02/03 09:00" {
+		t.Errorf("subject = %q", subject)
+	}
+	if !strings.Contains(text, "Hola Ada") || !strings.Contains(html, "<p>Hola Ada,</p>") {
+		t.Errorf("body not rendered in Spanish:\n%s\n%s", text, html)
+	}
+
+	// A locale without templates falls back to the default
+	if subject, _, _, err := templates.Render(TemplateConfirmation, "fr", view); err != nil || !strings.HasPrefix(subject, "Booking confirmed") {
+		t.Errorf("fallback subject = %q, %v", subject, err)
+	}
+}
+
+func TestSendAt(t *testing.T) {
+	loc := time.UTC
+	at := func(hhmm string) time.Time {
+		c, _ := time.Parse("15:04", hhmm)
+		return time.Date(2026, 3, 2, c.Hour(), c.Minute(), 0, 0, loc)
+	}
+	overnight := &models.NotificationPreference{QuietStart: "22:00", QuietEnd: "07:00"}
+	for _, tt := range []struct {
+		now, want time.Time
+	}{
+		{at("12:00"), at("12:00")},
+		{at("23:00"), at("07:00").AddDate(0, 0, 1)},
+		{at("06:00"), at("07:00")},
+		{at("07:00"), at("07:00")},
+	} {
+		if got := sendAt(overnight, tt.now, loc); !got.Equal(tt.want) {
+			t.Errorf("sendAt(%v) = %v, want %v", tt.now, got, tt.want)
+		}
+	}
+
+	digest := &models.NotificationPreference{DigestTime: "18:00"}
+	if got := nextDigest(digest, at("09:00"), loc); !got.Equal(at("18:00")) {
+		t.Errorf("nextDigest before the time = %v", got)
+	}
+	if got := nextDigest(digest, at("18:00"), loc); !got.Equal(at("18:00").AddDate(0, 0, 1)) {
+		t.Errorf("nextDigest at the time = %v", got)
+	}
+}
+
+// queuedSends returns the emails queued for sending
+func queuedSends(t *testing.T, db *gorm.DB) []mail.Message {
+	t.Helper()
+	var jobs []models.Job
+	if err := db.Where("kind = ?", KindSend).Order("id").Find(&jobs).Error; err != nil {
+		t.Fatal(err)
+	}
+	msgs := make([]mail.Message, len(jobs))
+	for i, job := range jobs {
+		if err := json.Unmarshal(job.Payload, &msgs[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return msgs
+}
+
+func TestPrepare(t *testing.T) {
+	db := dbtest.Open(t).DB
+	ctx := context.Background()
+	n := &Notifier{
+		DB: db, Sender: &mail.MaildirSender{Dir: t.TempDir()}, Templates: &Templates{DefaultLocale: "en"},
+		From: "rooms@example.com", Location: time.UTC, Host: "rooms.example.com",
+	}
+
+	admin := models.Teacher{Name: "Ada", Email: "ada@example.com", Role: models.RoleAdmin}
+	teacher := models.Teacher{Name: "Tom", Email: "tom@example.com", Role: models.RoleTeacher}
+	space := models.Space{Name: "Room A", RequiresApproval: true}
+	for _, v := range []any{&admin, &teacher, &space} {
+		if err := db.Create(v).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Now().Add(24 * time.Hour).Truncate(time.Hour)
+	booking := models.Booking{SpaceID: space.ID, StartTime: start, EndTime: start.Add(time.Hour), Status: models.StatusPending, TeacherID: &teacher.ID}
+	if err := db.Create(&booking).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// prepare runs the job Enqueue queued for event
+	prepare := func(event string) {
+		t.Helper()
+		if err := Enqueue(db, event, &booking); err != nil {
+			t.Fatal(err)
+		}
+		var job models.Job
+		if err := db.Where("kind = ?", KindBooking).Order("id DESC").First(&job).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := n.Prepare(ctx, &job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A pending booking asks the admins to approve it, without an invite
+	prepare(models.EventBookingCreated)
+	sent := queuedSends(t, db)
+	if len(sent) != 1 || !strings.Contains(sent[0].To[0], admin.Email) || len(sent[0].Attachments) != 0 {
+		t.Fatalf("approval request sent as %+v", sent)
+	}
+	if !strings.HasPrefix(sent[0].Subject, "Approval needed") {
+		t.Errorf("approval request subject = %q", sent[0].Subject)
+	}
+
+	// Once approved, the teacher gets a confirmation with an invite
+	booking.Status = models.StatusConfirmed
+	if err := db.Model(&booking).Update("status", booking.Status).Error; err != nil {
+		t.Fatal(err)
+	}
+	prepare(models.EventBookingApproved)
+	sent = queuedSends(t, db)
+	if len(sent) != 2 || !strings.Contains(sent[1].To[0], teacher.Email) || len(sent[1].Attachments) != 1 {
+		t.Fatalf("confirmation sent as %+v", sent[1:])
+	}
+
+	// An update prepared after the booking was cancelled is dropped
+	if err := Enqueue(db, models.EventBookingUpdated, &booking); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Model(&booking).Update("status", models.StatusCancelled).Error; err != nil {
+		t.Fatal(err)
+	}
+	var job models.Job
+	if err := db.Where("kind = ?", KindBooking).Order("id DESC").First(&job).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Prepare(ctx, &job); err != nil {
+		t.Fatal(err)
+	}
+	if sent := queuedSends(t, db); len(sent) != 2 {
+		t.Errorf("stale update sent: %+v", sent[2:])
+	}
+}
@@ -0,0 +1,215 @@
+// internal/notify/preferences.go
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	netmail "net/mail"
+	"slices"
+	"strings"
+	"time"
+
+	"skedda-goclone/internal/jobs"
+	"skedda-goclone/internal/mail"
+	"skedda-goclone/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// KindDigest sends the daily digests that have come due
+const KindDigest = "notify.digest"
+
+// DigestInterval is how often due digests are looked for, and so how late
+// one can be
+const DigestInterval = 5 * time.Minute
+
+// Events lists the booking events people can be notified of
+var Events = []string{
+	models.EventBookingCreated, models.EventBookingUpdated, models.EventBookingCancelled, models.EventBookingReminder,
+	models.EventBookingApprovalRequested,
+}
+
+// DefaultPreference is the preference of someone who hasn't set one: every
+// event by email, as it happens
+func DefaultPreference(ownerType string, ownerID int64) models.NotificationPreference {
+	return models.NotificationPreference{
+		OwnerType:  ownerType,
+		OwnerID:    ownerID,
+		Channels:   slices.Clone(models.NotificationChannels),
+		Events:     slices.Clone(Events),
+		Delivery:   models.DeliveryImmediate,
+		DigestTime: models.DefaultDigestTime,
+	}
+}
+
+// LoadPreference returns the owner's notification preference, or the
+// default if they haven't set one
+func LoadPreference(db *gorm.DB, ownerType string, ownerID int64) (models.NotificationPreference, error) {
+	var p models.NotificationPreference
+	err := db.Where("owner_type = ? AND owner_id = ?", ownerType, ownerID).First(&p).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return DefaultPreference(ownerType, ownerID), nil
+	}
+	return p, err
+}
+
+// clock returns the time of day hhmm on the day of t, in t's location
+func clock(t time.Time, hhmm string) (time.Time, error) {
+	c, err := time.Parse("15:04", hhmm)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), c.Hour(), c.Minute(), 0, 0, t.Location()), nil
+}
+
+// quietUntil returns when the quiet hours of p that t falls in end, or the
+// zero time if t is outside them
+func quietUntil(p *models.NotificationPreference, t time.Time, loc *time.Location) time.Time {
+	if p.QuietStart == "" || p.QuietEnd == "" || p.QuietStart == p.QuietEnd {
+		return time.Time{}
+	}
+	t = t.In(loc)
+	start, err := clock(t, p.QuietStart)
+	if err != nil {
+		return time.Time{}
+	}
+	end, err := clock(t, p.QuietEnd)
+	if err != nil {
+		return time.Time{}
+	}
+	if start.Before(end) {
+		if !t.Before(start) && t.Before(end) {
+			return end
+		}
+		return time.Time{}
+	}
+	// Quiet hours that run past midnight cover the evening and the next
+	// morning
+	switch {
+	case t.Before(end):
+		return end
+	case !t.Before(start):
+		return end.AddDate(0, 0, 1)
+	}
+	return time.Time{}
+}
+
+// sendAt returns when a message to the owner of p can go out, t or the
+// end of the quiet hours t falls in
+func sendAt(p *models.NotificationPreference, t time.Time, loc *time.Location) time.Time {
+	if until := quietUntil(p, t, loc); !until.IsZero() {
+		return until
+	}
+	return t
+}
+
+// nextDigest returns when the first digest of p after t is due
+func nextDigest(p *models.NotificationPreference, t time.Time, loc *time.Location) time.Time {
+	due, err := clock(t.In(loc), p.DigestTime)
+	if err != nil {
+		due, _ = clock(t.In(loc), models.DefaultDigestTime)
+	}
+	if !due.After(t) {
+		due = due.AddDate(0, 0, 1)
+	}
+	return due
+}
+
+// DigestView is what the digest template is rendered with: the reader's
+// name, and the latest change to each booking, soonest first
+type DigestView struct {
+	Name    string
+	Changes []View
+}
+
+// Digest handles a KindDigest job: it batches each person's due digest
+// entries into one email. Entries are marked sent in the transaction that
+// queues the email, so each change is only ever in one digest.
+func (n *Notifier) Digest(ctx context.Context, _ *models.Job) error {
+	if n.Sender == nil {
+		return nil
+	}
+	return n.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		var entries []models.DigestEntry
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("sent_at IS NULL AND due_at <= ?", now).Order("id").Find(&entries).Error
+		if err != nil || len(entries) == 0 {
+			return err
+		}
+
+		// Group entries by who they go to, keeping the first seen order
+		var order []string
+		groups := make(map[string][]models.DigestEntry)
+		for _, e := range entries {
+			key := strings.ToLower(e.Email)
+			if _, ok := groups[key]; !ok {
+				order = append(order, key)
+			}
+			groups[key] = append(groups[key], e)
+		}
+		for _, key := range order {
+			if err := n.queueDigest(tx, groups[key]); err != nil {
+				return err
+			}
+		}
+
+		ids := make([]int64, len(entries))
+		for i, e := range entries {
+			ids[i] = e.ID
+		}
+		return tx.Model(&models.DigestEntry{}).Where("id IN ?", ids).Update("sent_at", now).Error
+	})
+}
+
+// queueDigest queues the digest email of one person's entries. A booking
+// changed several times is listed once, with its latest change.
+func (n *Notifier) queueDigest(tx *gorm.DB, entries []models.DigestEntry) error {
+	latest := make(map[uint]string)
+	var ids []uint
+	for _, e := range entries {
+		if _, ok := latest[e.BookingID]; !ok {
+			ids = append(ids, e.BookingID)
+		}
+		latest[e.BookingID] = e.Event
+	}
+
+	var bookings []models.Booking
+	err := tx.Preload("Teacher").Preload("Subject").
+		Preload("Attendees", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		Preload("Attendees.Teacher").Preload("Attendees.Student").
+		Where("id IN ?", ids).Order("start_time").Find(&bookings).Error
+	if err != nil || len(bookings) == 0 {
+		return err
+	}
+	var spaces []models.Space
+	if err := tx.Select("id", "name").Find(&spaces).Error; err != nil {
+		return err
+	}
+	spaceNames := make(map[int64]string, len(spaces))
+	for _, s := range spaces {
+		spaceNames[s.ID] = s.Name
+	}
+
+	last := entries[len(entries)-1]
+	view := DigestView{Name: last.Name}
+	for i := range bookings {
+		b := &bookings[i]
+		view.Changes = append(view.Changes, n.view(b, latest[b.ID], spaceNames[b.SpaceID]))
+	}
+	subject, text, html, err := n.Templates.Render(TemplateDigest, last.Locale, view)
+	if err != nil {
+		return err
+	}
+	msg := mail.Message{
+		From:    n.From,
+		To:      []string{(&netmail.Address{Name: last.Name, Address: last.Email}).String()},
+		Subject: subject,
+		Text:    text,
+		HTML:    html,
+	}
+	key := fmt.Sprintf("%s:%d", KindDigest, entries[0].ID)
+	return jobs.EnqueueOnce(tx, KindSend, key, msg, time.Now())
+}
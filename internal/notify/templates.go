@@ -0,0 +1,201 @@
+// internal/notify/templates.go
+package notify
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"skedda-goclone/internal/models"
+)
+
+//go:embed all:templates
+var builtin embed.FS
+
+// fallbackLocale has every template
+const fallbackLocale = "en"
+
+// Names of the emails there are templates for
+const (
+	TemplateConfirmation = "confirmation"
+	TemplateChange       = "change"
+	TemplateCancellation = "cancellation"
+	TemplateReminder     = "reminder"
+	// TemplateApprovalRequest asks an admin to approve a pending booking
+	TemplateApprovalRequest = "approval_request"
+	TemplateDigest          = "digest"
+)
+
+var templateNames = []string{TemplateConfirmation, TemplateChange, TemplateCancellation, TemplateReminder, TemplateApprovalRequest}
+
+var funcs = map[string]any{"join": strings.Join}
+
+// Templates renders notification emails. Each email has a text template,
+// whose "subject" block is the subject line, and optionally an HTML one,
+// in a folder per locale: en/confirmation.txt and en/confirmation.html.
+// The templates of a locale share the blocks defined in _details.txt and
+// _details.html. Files in Dir, laid out the same way, take the place of
+// the built-in ones and are read afresh for every email, so they can be
+// edited without a restart.
+type Templates struct {
+	Dir           string
+	DefaultLocale string
+}
+
+// read returns a template file from Dir, or failing that the built-in one
+func (t *Templates) read(name string) ([]byte, error) {
+	if t.Dir != "" {
+		b, err := os.ReadFile(filepath.Join(t.Dir, filepath.FromSlash(name)))
+		if !errors.Is(err, fs.ErrNotExist) {
+			return b, err
+		}
+	}
+	return builtin.ReadFile(path.Join("templates", name))
+}
+
+// locales returns the locales to look for templates in, most specific
+// first: for es-MX that is es-mx, es, then the default locale and English
+func (t *Templates) locales(locale string) []string {
+	var out []string
+	add := func(l string) {
+		if l = strings.ToLower(strings.ReplaceAll(l, "_", "-")); l != "" && !slices.Contains(out, l) {
+			out = append(out, l)
+		}
+	}
+	for _, l := range []string{locale, t.DefaultLocale, fallbackLocale} {
+		add(l)
+		if base, _, ok := strings.Cut(l, "-"); ok {
+			add(base)
+		}
+	}
+	return out
+}
+
+// Render renders the email called name for a reader of locale, in the
+// closest locale that has it
+func (t *Templates) Render(name, locale string, data any) (subject, text, html string, err error) {
+	for _, l := range t.locales(locale) {
+		src, err := t.read(l + "/" + name + ".txt")
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", "", "", err
+		}
+		return t.render(l, name, src, data)
+	}
+	return "", "", "", fmt.Errorf("no %s template for locale %q", name, locale)
+}
+
+func (t *Templates) render(locale, name string, src []byte, data any) (subject, text, html string, err error) {
+	tt, err := texttemplate.New(name).Funcs(funcs).Parse(string(src))
+	if err != nil {
+		return "", "", "", fmt.Errorf("%s/%s.txt: %w", locale, name, err)
+	}
+	if shared, err := t.read(locale + "/_details.txt"); err == nil {
+		if _, err := tt.New("_details").Parse(string(shared)); err != nil {
+			return "", "", "", fmt.Errorf("%s/_details.txt: %w", locale, err)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", "", "", err
+	}
+
+	var buf bytes.Buffer
+	if tt.Lookup("subject") == nil {
+		return "", "", "", fmt.Errorf("%s/%s.txt has no subject block", locale, name)
+	}
+	if err := tt.ExecuteTemplate(&buf, "subject", data); err != nil {
+		return "", "", "", err
+	}
+	subject = strings.Join(strings.Fields(buf.String()), " ")
+	buf.Reset()
+	if err := tt.ExecuteTemplate(&buf, name, data); err != nil {
+		return "", "", "", err
+	}
+	text = buf.String()
+
+	src, err = t.read(locale + "/" + name + ".html")
+	if errors.Is(err, fs.ErrNotExist) {
+		return subject, text, "", nil
+	}
+	if err != nil {
+		return "", "", "", err
+	}
+	ht, err := htmltemplate.New(name).Funcs(funcs).Parse(string(src))
+	if err != nil {
+		return "", "", "", fmt.Errorf("%s/%s.html: %w", locale, name, err)
+	}
+	if shared, err := t.read(locale + "/_details.html"); err == nil {
+		if _, err := ht.New("_details").Parse(string(shared)); err != nil {
+			return "", "", "", fmt.Errorf("%s/_details.html: %w", locale, err)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", "", "", err
+	}
+	buf.Reset()
+	if err := ht.ExecuteTemplate(&buf, name, data); err != nil {
+		return "", "", "", err
+	}
+	return subject, text, buf.String(), nil
+}
+
+// Check renders every email in every locale with sample data, so mistakes
+// in edited templates show up at startup rather than when mail is due
+func (t *Templates) Check() error {
+	locales := []string{fallbackLocale}
+	add := func(fsys fs.FS) error {
+		entries, err := fs.ReadDir(fsys, ".")
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if e.IsDir() && !slices.Contains(locales, e.Name()) {
+				locales = append(locales, e.Name())
+			}
+		}
+		return nil
+	}
+	sub, _ := fs.Sub(builtin, "templates")
+	if err := add(sub); err != nil {
+		return err
+	}
+	if t.Dir != "" {
+		if err := add(os.DirFS(t.Dir)); err != nil {
+			return err
+		}
+	}
+
+	start := time.Now().Truncate(time.Hour)
+	sample := View{
+		Name: "Sample", Space: "Room A", Start: start, End: start.Add(time.Hour),
+		Subject: "Subject", Teacher: "Teacher", Attendees: []string{"Student"}, Notes: "Notes",
+	}
+	digest := DigestView{Name: "Sample"}
+	for _, event := range []string{models.EventBookingCreated, models.EventBookingUpdated, models.EventBookingCancelled} {
+		v := sample
+		v.Event = event
+		digest.Changes = append(digest.Changes, v)
+	}
+	var errs []error
+	for _, l := range locales {
+		for _, name := range templateNames {
+			if _, _, _, err := t.Render(name, l, sample); err != nil {
+				errs = append(errs, fmt.Errorf("%s template for %s: %w", name, l, err))
+			}
+		}
+		if _, _, _, err := t.Render(TemplateDigest, l, digest); err != nil {
+			errs = append(errs, fmt.Errorf("%s template for %s: %w", TemplateDigest, l, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,91 @@
+// internal/reminders/reminders.go
+package reminders
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"skedda-goclone/internal/events"
+	"skedda-goclone/internal/jobs"
+	"skedda-goclone/internal/models"
+	"skedda-goclone/internal/notify"
+	"skedda-goclone/internal/webhook"
+
+	"gorm.io/gorm"
+)
+
+// Job kinds
+const (
+	// KindScan looks for bookings starting soon and queues their reminders
+	KindScan = "reminders.scan"
+	// KindSend sends one booking's reminder
+	KindSend = "reminders.send"
+)
+
+// ScanInterval is how often bookings starting soon are looked for
+const ScanInterval = time.Minute
+
+// payload identifies the booking a reminder is for, and the start time it
+// was queued for
+type payload struct {
+	BookingID uint      `json:"booking_id"`
+	StartTime time.Time `json:"start_time"`
+}
+
+// Scan queues a reminder for every confirmed booking starting within lead
+// of now. A booking's reminder is queued once per start time, so moving a
+// booking brings a fresh reminder for its new time.
+func Scan(ctx context.Context, db *gorm.DB, lead time.Duration, now time.Time) error {
+	db = db.WithContext(ctx)
+	var due []models.Booking
+	err := db.Select("id", "start_time").
+		Where("status = ? AND start_time > ? AND start_time <= ?", models.StatusConfirmed, now, now.Add(lead)).
+		Find(&due).Error
+	if err != nil || len(due) == 0 {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, b := range due {
+			key := fmt.Sprintf("%s:%d:%d", KindSend, b.ID, b.StartTime.Unix())
+			if err := jobs.EnqueueOnce(tx, KindSend, key, payload{BookingID: b.ID, StartTime: b.StartTime}, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Send announces a booking's reminder as a booking.reminder event and
+// emails the people it concerns, unless the booking has since been
+// cancelled, released or moved
+func Send(ctx context.Context, db *gorm.DB, job *models.Job) error {
+	var p payload
+	if err := json.Unmarshal(job.Payload, &p); err != nil {
+		return err
+	}
+
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var b models.Booking
+		err := tx.Preload("Attendees", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).First(&b, p.BookingID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if b.Status != models.StatusConfirmed || !b.StartTime.Equal(p.StartTime) {
+			return nil
+		}
+		if err := webhook.Enqueue(tx, models.EventBookingReminder, b); err != nil {
+			return err
+		}
+		if err := notify.Enqueue(tx, models.EventBookingReminder, &b); err != nil {
+			return err
+		}
+		return events.Publish(tx, models.EventBookingReminder, b)
+	})
+}
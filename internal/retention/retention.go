@@ -0,0 +1,60 @@
+// internal/retention/retention.go
+package retention
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"skedda-goclone/internal/config"
+	"skedda-goclone/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// Kind is the job kind of the purge
+const Kind = "retention.purge"
+
+// Interval is how often old records are purged
+const Interval = time.Hour
+
+// Purge deletes the records cfg no longer keeps as of now: events, webhook
+// deliveries that are done with, and finished jobs along with the digest
+// entries they sent
+func Purge(ctx context.Context, db *gorm.DB, cfg config.RetentionConfig, now time.Time) error {
+	db = db.WithContext(ctx)
+	purges := []struct {
+		name   string
+		keep   time.Duration
+		delete func(cutoff time.Time) *gorm.DB
+	}{
+		{"events", cfg.Events, func(cutoff time.Time) *gorm.DB {
+			return db.Where("created_at < ?", cutoff).Delete(&models.Event{})
+		}},
+		{"webhook_deliveries", cfg.WebhookDeliveries, func(cutoff time.Time) *gorm.DB {
+			return db.Where("status IN ? AND COALESCE(last_attempt_at, created_at) < ?",
+				[]string{models.DeliverySucceeded, models.DeliveryDead}, cutoff).Delete(&models.WebhookDelivery{})
+		}},
+		{"jobs", cfg.Jobs, func(cutoff time.Time) *gorm.DB {
+			return db.Where("status IN ? AND finished_at < ?",
+				[]string{models.JobSucceeded, models.JobDead}, cutoff).Delete(&models.Job{})
+		}},
+		{"digest_entries", cfg.Jobs, func(cutoff time.Time) *gorm.DB {
+			return db.Where("sent_at < ?", cutoff).Delete(&models.DigestEntry{})
+		}},
+	}
+
+	for _, p := range purges {
+		if p.keep == 0 {
+			continue
+		}
+		result := p.delete(now.Add(-p.keep))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			slog.Info("purged old records", "table", p.name, "count", result.RowsAffected)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,262 @@
+// internal/webhook/webhook.go
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"skedda-goclone/internal/config"
+	"skedda-goclone/internal/metrics"
+	"skedda-goclone/internal/models"
+	"slices"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Headers sent with every delivery
+const (
+	EventHeader     = "X-Skedda-Event"
+	DeliveryHeader  = "X-Skedda-Delivery"
+	TimestampHeader = "X-Skedda-Timestamp"
+	SignatureHeader = "X-Skedda-Signature"
+)
+
+// Envelope is the body of every delivery. ID is the same for every
+// subscription an event goes to, and across retries, so receivers can
+// ignore repeats.
+type Envelope struct {
+	ID        string    `json:"id"`
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// NewSecret returns a random signing secret for a subscription
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// Sign returns the signature of a delivery: the hex HMAC-SHA256, keyed
+// with the subscription's secret, of the timestamp, a dot and the body.
+// Covering the timestamp lets receivers reject replayed deliveries.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Enqueue queues event for every active subscription to it. Pass the
+// transaction that made the change, so deliveries are only queued if the
+// change is kept.
+func Enqueue(tx *gorm.DB, event string, data any) error {
+	var subs []models.WebhookSubscription
+	if err := tx.Where("active").Find(&subs).Error; err != nil {
+		return err
+	}
+	subs = slices.DeleteFunc(subs, func(s models.WebhookSubscription) bool {
+		return !slices.Contains(s.Events, event)
+	})
+	if len(subs) == 0 {
+		return nil
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	now := time.Now()
+	payload, err := json.Marshal(Envelope{ID: hex.EncodeToString(id), Event: event, CreatedAt: now, Data: data})
+	if err != nil {
+		return err
+	}
+
+	deliveries := make([]models.WebhookDelivery, len(subs))
+	for i, s := range subs {
+		deliveries[i] = models.WebhookDelivery{
+			SubscriptionID: s.ID,
+			EventID:        hex.EncodeToString(id),
+			Event:          event,
+			Payload:        payload,
+			Status:         models.DeliveryPending,
+			NextAttemptAt:  now,
+		}
+	}
+	return tx.Create(&deliveries).Error
+}
+
+// Dispatcher sends queued deliveries. Any number of servers can run one
+// against the same database: each claims a batch by leasing it, so a
+// delivery is sent by one server at a time, and a server that dies mid
+// batch only delays its deliveries until the lease runs out. Delivery is
+// at least once.
+type Dispatcher struct {
+	DB     *gorm.DB
+	Config config.WebhookConfig
+	Client *http.Client
+}
+
+// batchSize is how many deliveries a dispatcher claims at once
+const batchSize = 20
+
+// Run sends deliveries as they fall due until ctx is cancelled
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.Config.PollInterval)
+	defer ticker.Stop()
+	for {
+		for {
+			n, err := d.dispatch(ctx)
+			if err != nil && ctx.Err() == nil {
+				slog.Error("dispatching webhooks", "error", err)
+			}
+			if n < batchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// dispatch sends one batch of due deliveries, returning how many it claimed
+func (d *Dispatcher) dispatch(ctx context.Context) (int, error) {
+	deliveries, err := d.claim(ctx)
+	if err != nil || len(deliveries) == 0 {
+		return 0, err
+	}
+
+	for i := range deliveries {
+		if err := d.attempt(ctx, &deliveries[i]); err != nil {
+			return len(deliveries), err
+		}
+	}
+	return len(deliveries), nil
+}
+
+// claim leases a batch of due deliveries by pushing back their next
+// attempt past the longest an attempt can take
+func (d *Dispatcher) claim(ctx context.Context) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	err := d.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", models.DeliveryPending, now).
+			Order("next_attempt_at").Limit(batchSize).Find(&deliveries).Error
+		if err != nil || len(deliveries) == 0 {
+			return err
+		}
+
+		ids := make([]int64, len(deliveries))
+		for i, delivery := range deliveries {
+			ids[i] = delivery.ID
+		}
+		lease := now.Add(batchSize * 2 * d.Config.Timeout)
+		return tx.Model(&models.WebhookDelivery{}).Where("id IN ?", ids).Update("next_attempt_at", lease).Error
+	})
+	return deliveries, err
+}
+
+// attempt sends delivery and records the outcome, scheduling a retry or
+// dead-lettering it on failure
+func (d *Dispatcher) attempt(ctx context.Context, delivery *models.WebhookDelivery) error {
+	var sub models.WebhookSubscription
+	if err := d.DB.WithContext(ctx).First(&sub, delivery.SubscriptionID).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		sub.Active = false
+	}
+
+	now := time.Now()
+	delivery.Attempts++
+	delivery.LastAttemptAt = &now
+	var sendErr error
+	if sub.Active {
+		delivery.ResponseStatus, sendErr = d.send(ctx, &sub, delivery, now)
+	} else {
+		sendErr = errors.New("subscription has been removed or disabled")
+		delivery.Attempts = d.Config.MaxAttempts
+	}
+
+	switch {
+	case sendErr == nil:
+		delivery.Status = models.DeliverySucceeded
+		delivery.LastError = ""
+	case delivery.Attempts >= d.Config.MaxAttempts:
+		delivery.Status = models.DeliveryDead
+		delivery.LastError = sendErr.Error()
+		slog.Warn("webhook delivery dead-lettered", "delivery_id", delivery.ID, "subscription_id", sub.ID, "error", sendErr)
+	default:
+		delivery.LastError = sendErr.Error()
+		delivery.NextAttemptAt = now.Add(d.backoff(delivery.Attempts))
+	}
+	metrics.WebhookDeliveries.WithLabelValues(delivery.Event, delivery.Status).Inc()
+
+	return d.DB.WithContext(ctx).Model(delivery).Select(
+		"status", "attempts", "next_attempt_at", "last_attempt_at", "response_status", "last_error",
+	).Updates(delivery).Error
+}
+
+// backoff returns how long to wait after the given number of failed attempts
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	wait := d.Config.RetryBase
+	for i := 1; i < attempts && wait < d.Config.RetryMax; i++ {
+		wait *= 2
+	}
+	return min(wait, d.Config.RetryMax)
+}
+
+// send posts delivery to the subscription, failing on anything but a 2xx
+// response
+func (d *Dispatcher) send(ctx context.Context, sub *models.WebhookSubscription, delivery *models.WebhookDelivery, now time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.Config.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := now.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Skedda-Webhooks/1")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, delivery.EventID)
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(sub.Secret, timestamp, delivery.Payload))
+
+	client := d.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver responded %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,296 @@
+// internal/webhook/webhook_test.go
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"skedda-goclone/internal/config"
+	"skedda-goclone/internal/dbtest"
+	"skedda-goclone/internal/models"
+)
+
+var testConfig = config.WebhookConfig{
+	MaxAttempts:  3,
+	RetryBase:    time.Minute,
+	RetryMax:     10 * time.Minute,
+	Timeout:      time.Second,
+	PollInterval: time.Second,
+}
+
+// receiver is a webhook endpoint that records what it is sent and answers
+// with status
+type receiver struct {
+	*httptest.Server
+	status int
+
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func newReceiver(t *testing.T, status int) *receiver {
+	rcv := &receiver{status: status}
+	rcv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rcv.mu.Lock()
+		rcv.requests = append(rcv.requests, r)
+		rcv.bodies = append(rcv.bodies, body)
+		rcv.mu.Unlock()
+		w.WriteHeader(rcv.status)
+	}))
+	t.Cleanup(rcv.Close)
+	return rcv
+}
+
+// received returns the requests made so far and their bodies
+func (rcv *receiver) received() ([]*http.Request, [][]byte) {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	return append([]*http.Request(nil), rcv.requests...), append([][]byte(nil), rcv.bodies...)
+}
+
+// verify checks a delivery's signature the way the docs tell receivers to
+func verify(secret string, r *http.Request, body []byte) bool {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(r.Header.Get(TimestampHeader) + "."))
+	mac.Write(body)
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(want), []byte(r.Header.Get(SignatureHeader)))
+}
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"id":"1"}`)
+	sig := Sign("whsec_test", 1700000000, body)
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.Header.Set(TimestampHeader, "1700000000")
+	r.Header.Set(SignatureHeader, sig)
+	if !verify("whsec_test", r, body) {
+		t.Errorf("signature %s doesn't verify", sig)
+	}
+	if Sign("whsec_test", 1700000001, body) == sig || Sign("whsec_other", 1700000000, body) == sig {
+		t.Error("signature doesn't cover the timestamp and secret")
+	}
+}
+
+func TestSendSignsDelivery(t *testing.T) {
+	rcv := newReceiver(t, http.StatusNoContent)
+	d := &Dispatcher{Config: testConfig}
+	sub := &models.WebhookSubscription{URL: rcv.URL, Secret: "whsec_test"}
+	delivery := &models.WebhookDelivery{EventID: "evt1", Event: models.EventBookingCreated, Payload: json.RawMessage(`{"id":"evt1"}`)}
+	now := time.Unix(1700000000, 0)
+
+	status, err := d.send(context.Background(), sub, delivery, now)
+	if err != nil || status != http.StatusNoContent {
+		t.Fatalf("send() = %d, %v", status, err)
+	}
+	requests, bodies := rcv.received()
+	r, body := requests[0], bodies[0]
+	if r.Header.Get(EventHeader) != models.EventBookingCreated || r.Header.Get(DeliveryHeader) != "evt1" ||
+		r.Header.Get(TimestampHeader) != strconv.FormatInt(now.Unix(), 10) {
+		t.Errorf("unexpected headers %v", r.Header)
+	}
+	if !verify("whsec_test", r, body) {
+		t.Error("receiver couldn't verify the signature")
+	}
+}
+
+func TestSendFailsOnErrorStatus(t *testing.T) {
+	rcv := newReceiver(t, http.StatusServiceUnavailable)
+	d := &Dispatcher{Config: testConfig}
+	sub := &models.WebhookSubscription{URL: rcv.URL, Secret: "s"}
+	status, err := d.send(context.Background(), sub, &models.WebhookDelivery{Payload: json.RawMessage(`{}`)}, time.Now())
+	if err == nil || status != http.StatusServiceUnavailable {
+		t.Errorf("send() = %d, %v; want a 503 error", status, err)
+	}
+}
+
+func TestSendTimesOut(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	cfg := testConfig
+	cfg.Timeout = 50 * time.Millisecond
+	d := &Dispatcher{Config: cfg}
+	sub := &models.WebhookSubscription{URL: srv.URL, Secret: "s"}
+	if _, err := d.send(context.Background(), sub, &models.WebhookDelivery{Payload: json.RawMessage(`{}`)}, time.Now()); err == nil {
+		t.Error("send() to a receiver that never answers succeeded")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := &Dispatcher{Config: testConfig}
+	want := []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 10 * time.Minute, 10 * time.Minute}
+	for i, w := range want {
+		if got := d.backoff(i + 1); got != w {
+			t.Errorf("backoff(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+}
+
+func TestDispatchRetriesThenDeadLetters(t *testing.T) {
+	db := dbtest.Open(t)
+	rcv := newReceiver(t, http.StatusInternalServerError)
+	sub := models.WebhookSubscription{URL: rcv.URL, Secret: "whsec_test", Events: []string{models.EventBookingCreated}, Active: true}
+	if err := db.Create(&sub).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := Enqueue(db.DB, models.EventBookingCreated, map[string]int{"id": 1}); err != nil {
+		t.Fatal(err)
+	}
+	// Events the subscription didn't ask for aren't queued
+	if err := Enqueue(db.DB, models.EventBookingCancelled, map[string]int{"id": 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &Dispatcher{DB: db.DB, Config: testConfig}
+	ctx := context.Background()
+	var delivery models.WebhookDelivery
+	for attempt := 1; attempt <= testConfig.MaxAttempts; attempt++ {
+		// Make the retry due now rather than waiting out the backoff
+		if err := db.Model(&models.WebhookDelivery{}).Where("status = ?", models.DeliveryPending).
+			Update("next_attempt_at", time.Now()).Error; err != nil {
+			t.Fatal(err)
+		}
+		before := time.Now()
+		if n, err := d.dispatch(ctx); err != nil || n != 1 {
+			t.Fatalf("attempt %d: dispatch() = %d, %v", attempt, n, err)
+		}
+		if err := db.First(&delivery).Error; err != nil {
+			t.Fatal(err)
+		}
+		if delivery.Attempts != attempt || delivery.ResponseStatus != http.StatusInternalServerError || delivery.LastError == "" {
+			t.Errorf("attempt %d: delivery = %+v", attempt, delivery)
+		}
+		if attempt < testConfig.MaxAttempts {
+			if delivery.Status != models.DeliveryPending || delivery.NextAttemptAt.Before(before.Add(d.backoff(attempt))) {
+				t.Errorf("attempt %d: not rescheduled after the backoff: %+v", attempt, delivery)
+			}
+		}
+	}
+	if delivery.Status != models.DeliveryDead {
+		t.Errorf("status after %d failures = %s, want dead", testConfig.MaxAttempts, delivery.Status)
+	}
+	requests, _ := rcv.received()
+	if len(requests) != testConfig.MaxAttempts {
+		t.Errorf("receiver got %d requests, want %d", len(requests), testConfig.MaxAttempts)
+	}
+	// Every retry carries the same event ID, so receivers can ignore repeats
+	for _, r := range requests {
+		if r.Header.Get(DeliveryHeader) != delivery.EventID {
+			t.Errorf("retry sent with event ID %q, want %q", r.Header.Get(DeliveryHeader), delivery.EventID)
+		}
+	}
+}
+
+func TestDispatchSucceeds(t *testing.T) {
+	db := dbtest.Open(t)
+	rcv := newReceiver(t, http.StatusOK)
+	sub := models.WebhookSubscription{URL: rcv.URL, Secret: "whsec_test", Events: []string{models.EventBookingCreated}, Active: true}
+	if err := db.Create(&sub).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := Enqueue(db.DB, models.EventBookingCreated, map[string]int{"id": 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &Dispatcher{DB: db.DB, Config: testConfig}
+	if _, err := d.dispatch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var delivery models.WebhookDelivery
+	if err := db.First(&delivery).Error; err != nil {
+		t.Fatal(err)
+	}
+	if delivery.Status != models.DeliverySucceeded || delivery.Attempts != 1 {
+		t.Errorf("delivery = %+v, want succeeded on the first attempt", delivery)
+	}
+	requests, bodies := rcv.received()
+	if len(requests) != 1 || !verify("whsec_test", requests[0], bodies[0]) {
+		t.Error("receiver couldn't verify the signature")
+	}
+}
+
+func TestClaimLeasesDeliveries(t *testing.T) {
+	db := dbtest.Open(t)
+	sub := models.WebhookSubscription{URL: "http://127.0.0.1:1", Secret: "s", Events: []string{models.EventBookingCreated}, Active: true}
+	if err := db.Create(&sub).Error; err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := Enqueue(db.DB, models.EventBookingCreated, map[string]int{"id": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d := &Dispatcher{DB: db.DB, Config: testConfig}
+	ctx := context.Background()
+	first, err := d.claim(ctx)
+	if err != nil || len(first) != 3 {
+		t.Fatalf("claim() = %d deliveries, %v; want 3", len(first), err)
+	}
+	// A second dispatcher finds nothing while the lease holds
+	second, err := d.claim(ctx)
+	if err != nil || len(second) != 0 {
+		t.Errorf("second claim() = %d deliveries, %v; want none", len(second), err)
+	}
+
+	var leased []models.WebhookDelivery
+	if err := db.Find(&leased).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, delivery := range leased {
+		if !delivery.NextAttemptAt.After(time.Now().Add(testConfig.Timeout)) {
+			t.Errorf("delivery %d leased only until %v", delivery.ID, delivery.NextAttemptAt)
+		}
+	}
+
+	// Once the lease runs out, another dispatcher picks them up
+	if err := db.Model(&models.WebhookDelivery{}).Where("1 = 1").Update("next_attempt_at", time.Now()).Error; err != nil {
+		t.Fatal(err)
+	}
+	if again, err := d.claim(ctx); err != nil || len(again) != 3 {
+		t.Errorf("claim() after the lease = %d deliveries, %v; want 3", len(again), err)
+	}
+}
+
+func TestDisabledSubscriptionDeadLetters(t *testing.T) {
+	db := dbtest.Open(t)
+	rcv := newReceiver(t, http.StatusOK)
+	sub := models.WebhookSubscription{URL: rcv.URL, Secret: "s", Events: []string{models.EventBookingCreated}, Active: true}
+	if err := db.Create(&sub).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := Enqueue(db.DB, models.EventBookingCreated, map[string]int{"id": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Model(&sub).Update("active", false).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	d := &Dispatcher{DB: db.DB, Config: testConfig}
+	if _, err := d.dispatch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var delivery models.WebhookDelivery
+	if err := db.First(&delivery).Error; err != nil {
+		t.Fatal(err)
+	}
+	if requests, _ := rcv.received(); delivery.Status != models.DeliveryDead || len(requests) != 0 {
+		t.Errorf("delivery to a disabled subscription = %+v after %d requests", delivery, len(requests))
+	}
+}
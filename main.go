@@ -4,12 +4,16 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 	
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/driver/desktop"
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
 	_ "github.com/mattn/go-sqlite3"
@@ -26,37 +30,29 @@ type Booking struct {
 }
 
 type BookingSystem struct {
-	spaces   []string
-	bookings []Booking
-	window   fyne.Window
-	db       *sql.DB
+	spaces []string
+	window fyne.Window
+	db     *sql.DB
+	// tray is set when the platform has a system tray
+	tray desktop.App
+
+	mu           sync.Mutex
+	bookings     []Booking // on show; the scheduler reloads them too
+	lastReminder *Booking  // the reminder the tray offers to snooze
+	notices      []string  // the latest notifications, for the tray menu
 }
 
+const databasePath = "./bookings.db"
+
 func NewBookingSystem() *BookingSystem {
 	// Initialize SQLite database
-	db, err := sql.Open("sqlite3", "./bookings.db")
+	db, err := sql.Open("sqlite3", databasePath)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// Create tables if they don't exist
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS spaces (
-			id INTEGER PRIMARY KEY,
-			name TEXT NOT NULL
-		);
-		CREATE TABLE IF NOT EXISTS bookings (
-			id INTEGER PRIMARY KEY,
-			space_id INTEGER,
-			start_time DATETIME,
-			end_time DATETIME,
-			user TEXT,
-			notes TEXT,
-			status TEXT,
-			FOREIGN KEY(space_id) REFERENCES spaces(id)
-		);
-	`)
-	if err != nil {
+	// Bring the schema up to date, backing up existing data first
+	if err := migrateDatabase(db, databasePath); err != nil {
 		log.Fatal(err)
 	}
 
@@ -84,7 +80,7 @@ func (bs *BookingSystem) loadBookings() {
 	}
 	defer rows.Close()
 
-	bs.bookings = nil
+	var bookings []Booking
 	for rows.Next() {
 		var b Booking
 		var spaceID int
@@ -94,16 +90,74 @@ func (bs *BookingSystem) loadBookings() {
 			continue
 		}
 		b.Space = bs.spaces[spaceID] // Convert space_id to space name
-		bs.bookings = append(bs.bookings, b)
+		bookings = append(bookings, b)
+	}
+	bs.setBookings(bookings)
+}
+
+// The bookings on show are read by the UI and reloaded by the scheduler,
+// so they are only touched under bs.mu, through these
+
+// setBookings replaces the bookings on show
+func (bs *BookingSystem) setBookings(bookings []Booking) {
+	bs.mu.Lock()
+	bs.bookings = bookings
+	bs.mu.Unlock()
+}
+
+// snapshot returns a copy of the bookings on show
+func (bs *BookingSystem) snapshot() []Booking {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	return slices.Clone(bs.bookings)
+}
+
+// bookingCount returns how many bookings are on show
+func (bs *BookingSystem) bookingCount() int {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	return len(bs.bookings)
+}
+
+// bookingAt returns the booking on show in row i, if there still is one
+func (bs *BookingSystem) bookingAt(i int) (Booking, bool) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	if i < 0 || i >= len(bs.bookings) {
+		return Booking{}, false
+	}
+	return bs.bookings[i], true
+}
+
+// updateBooking applies change to the booking on show with id, if any
+func (bs *BookingSystem) updateBooking(id int64, change func(*Booking)) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	for i := range bs.bookings {
+		if bs.bookings[i].ID == id {
+			change(&bs.bookings[i])
+		}
 	}
 }
 
+// refresh redraws the window. It may be called from any goroutine, as the
+// redraw happens on the UI's.
+func (bs *BookingSystem) refresh() {
+	fyne.Do(func() { bs.window.Content().Refresh() })
+}
+
 func (bs *BookingSystem) createMainUI() fyne.CanvasObject {
 	// Add a toolbar with common actions
 	toolbar := widget.NewToolbar(
 		widget.NewToolbarAction(theme.DocumentCreateIcon(), func() {
 			bs.showBookingDialog(time.Now())
 		}),
+		widget.NewToolbarAction(theme.SearchIcon(), func() {
+			bs.showSlotFinder()
+		}),
+		widget.NewToolbarAction(theme.SettingsIcon(), func() {
+			bs.showNotificationSettings()
+		}),
 		widget.NewToolbarSeparator(),
 		widget.NewToolbarAction(theme.ViewRefreshIcon(), func() {
 			bs.loadBookings()
@@ -123,7 +177,7 @@ func (bs *BookingSystem) createMainUI() fyne.CanvasObject {
 	go func() {
 		for {
 			time.Sleep(time.Minute)
-			statusBar.SetText(fmt.Sprintf("Last updated: %s", time.Now().Format("15:04")))
+			fyne.Do(func() { statusBar.SetText(fmt.Sprintf("Last updated: %s", time.Now().Format("15:04"))) })
 		}
 	}()
 
@@ -226,7 +280,7 @@ func (bs *BookingSystem) createSpacesView() fyne.CanvasObject {
             
             spaceName := bs.spaces[id]
             bookingCount := 0
-            for _, booking := range bs.bookings {
+            for _, booking := range bs.snapshot() {
                 if booking.Space == spaceName {
                     bookingCount++
                 }
@@ -240,14 +294,35 @@ func (bs *BookingSystem) createSpacesView() fyne.CanvasObject {
     // Add button to manage spaces
     addButton := widget.NewButton("Add Space", func() {
         entry := widget.NewEntry()
+        capacity := widget.NewEntry()
+        capacity.SetPlaceHolder("0")
+        amenities := widget.NewEntry()
+        amenities.SetPlaceHolder("projector, whiteboard")
+        checkInWindow := widget.NewEntry()
+        checkInWindow.SetPlaceHolder("0")
         dialog.ShowForm("Add Space", "Add", "Cancel",
             []*widget.FormItem{
                 {Text: "Space Name", Widget: entry},
+                {Text: "Capacity", Widget: capacity},
+                {Text: "Amenities", Widget: amenities},
+                {Text: "Check-in window (minutes)", Widget: checkInWindow, HintText: "Release bookings not checked in to within this time; 0 for never"},
             },
             func(submitted bool) {
                 if submitted && entry.Text != "" {
+                    seats, err := optionalInt(capacity.Text)
+                    if err != nil {
+                        dialog.ShowError(fmt.Errorf("capacity must be a number"), bs.window)
+                        return
+                    }
+                    minutes, err := optionalInt(checkInWindow.Text)
+                    if err != nil {
+                        dialog.ShowError(fmt.Errorf("check-in window must be a number of minutes"), bs.window)
+                        return
+                    }
+
                     // Save to database
-                    _, err := bs.db.Exec("INSERT INTO spaces (name) VALUES (?)", entry.Text)
+                    _, err = bs.db.Exec("INSERT INTO spaces (name, capacity, amenities, check_in_minutes) VALUES (?, ?, ?, ?)",
+                        entry.Text, seats, strings.Join(splitList(amenities.Text), ","), minutes)
                     if err != nil {
                         dialog.ShowError(err, bs.window)
                         return
@@ -272,19 +347,18 @@ func (bs *BookingSystem) createSpacesView() fyne.CanvasObject {
 func (bs *BookingSystem) createBookingsView() fyne.CanvasObject {
     // Create table for bookings
     table := widget.NewTable(
-        func() (int, int) { return len(bs.bookings), 5 }, // Added column for status
+        func() (int, int) { return bs.bookingCount(), 5 }, // Added column for status
         func() fyne.CanvasObject { 
             return widget.NewLabel("") 
         },
         func(id widget.TableCellID, cell fyne.CanvasObject) {
             label := cell.(*widget.Label)
-            if id.Row >= len(bs.bookings) {
+            booking, ok := bs.bookingAt(id.Row)
+            if !ok {
                 label.SetText("")
                 return
             }
             
-            booking := bs.bookings[id.Row]
-            
             switch id.Col {
             case 0:
                 label.SetText(booking.Space)
@@ -325,11 +399,10 @@ func (bs *BookingSystem) createBookingsView() fyne.CanvasObject {
 
     // Add context menu for booking management
     table.OnSelected = func(id widget.TableCellID) {
-        if id.Row >= len(bs.bookings) {
+        booking, ok := bs.bookingAt(id.Row)
+        if !ok {
             return
         }
-        
-        booking := bs.bookings[id.Row]
         menu := fyne.NewMenu("Booking",
             fyne.NewMenuItem("Cancel Booking", func() {
                 dialog.ShowConfirm("Cancel Booking",
@@ -337,23 +410,30 @@ func (bs *BookingSystem) createBookingsView() fyne.CanvasObject {
                     func(yes bool) {
                         if yes {
                             // Update status in database
-                            _, err := bs.db.Exec(
-                                "UPDATE bookings SET status = 'Cancelled' WHERE id = ?",
-                                booking.ID,
-                            )
+                            err := bs.updateBookingField(booking.ID, "booking.cancel", "status", "Cancelled")
                             if err != nil {
                                 dialog.ShowError(err, bs.window)
                                 return
                             }
                             
                             // Update in memory
-                            bs.bookings[id.Row].Status = "Cancelled"
+                            bs.updateBooking(booking.ID, func(b *Booking) { b.Status = "Cancelled" })
                             table.Refresh()
                         }
                     },
                     bs.window,
                 )
             }),
+            fyne.NewMenuItem("Check In", func() {
+                if err := bs.checkIn(booking); err != nil {
+                    dialog.ShowError(err, bs.window)
+                    return
+                }
+                dialog.ShowInformation("Check In", "Checked in to "+booking.Space+".", bs.window)
+            }),
+            fyne.NewMenuItem("Mark Attendance", func() {
+                bs.showAttendanceDialog(booking)
+            }),
             fyne.NewMenuItem("Edit Notes", func() {
                 notes := widget.NewMultiLineEntry()
                 notes.SetText(booking.Notes)
@@ -365,17 +445,14 @@ func (bs *BookingSystem) createBookingsView() fyne.CanvasObject {
                     func(submitted bool) {
                         if submitted {
                             // Update in database
-                            _, err := bs.db.Exec(
-                                "UPDATE bookings SET notes = ? WHERE id = ?",
-                                notes.Text, booking.ID,
-                            )
+                            err := bs.updateBookingField(booking.ID, "booking.edit_notes", "notes", notes.Text)
                             if err != nil {
                                 dialog.ShowError(err, bs.window)
                                 return
                             }
                             
                             // Update in memory
-                            bs.bookings[id.Row].Notes = notes.Text
+                            bs.updateBooking(booking.ID, func(b *Booking) { b.Notes = notes.Text })
                             table.Refresh()
                         }
                     },
@@ -407,7 +484,7 @@ func (bs *BookingSystem) createBookingsView() fyne.CanvasObject {
         }
         defer rows.Close()
 
-        bs.bookings = nil
+        var bookings []Booking
         for rows.Next() {
             var b Booking
             var spaceID int
@@ -417,8 +494,9 @@ func (bs *BookingSystem) createBookingsView() fyne.CanvasObject {
                 continue
             }
             b.Space = bs.spaces[spaceID]
-            bs.bookings = append(bs.bookings, b)
+            bookings = append(bookings, b)
         }
+        bs.setBookings(bookings)
         
         table.Refresh()
     }
@@ -437,7 +515,7 @@ func (bs *BookingSystem) hasBookingsOnDate(year, month, day int) bool {
 	date := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.Local)
 	nextDay := date.AddDate(0, 0, 1)
 	
-	for _, booking := range bs.bookings {
+	for _, booking := range bs.snapshot() {
 		if booking.StartTime.After(date) && booking.StartTime.Before(nextDay) {
 			return true
 		}
@@ -491,34 +569,74 @@ func (bs *BookingSystem) showBookingDialog(date time.Time) {
 			}
 
 			// Save to database
-			result, err := bs.db.Exec(`
-				INSERT INTO bookings (space_id, start_time, end_time, user, notes, status)
-				VALUES (?, ?, ?, ?, ?, ?)
-			`, bs.getSpaceID(spaceSelect.Selected), start, end, user.Text, notes.Text, "Confirmed")
-			
-			if err != nil {
+			if err := bs.addBooking(spaceSelect.Selected, start, end, user.Text, notes.Text); err != nil {
 				dialog.ShowError(err, bs.window)
 				return
 			}
 
-			id, _ := result.LastInsertId()
-			bs.bookings = append(bs.bookings, Booking{
-				ID:        id,
-				Space:     spaceSelect.Selected,
-				StartTime: start,
-				EndTime:   end,
-				User:     user.Text,
-				Notes:    notes.Text,
-				Status:   "Confirmed",
-			})
-
 			bs.window.Content().Refresh()
 		}
 	}, bs.window)
 }
 
+// addBooking saves a confirmed booking and adds it to the loaded bookings.
+// It is audited, so other users of the database can be told about it.
+func (bs *BookingSystem) addBooking(space string, start, end time.Time, user, notes string) error {
+	tx, err := bs.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		INSERT INTO bookings (space_id, start_time, end_time, user, notes, status)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, bs.getSpaceID(space), start, end, user, notes, "Confirmed")
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	err = recordAudit(tx, "booking.create", "booking", id, map[string]fieldChange{
+		"space":      {After: space},
+		"start_time": {After: start},
+		"end_time":   {After: end},
+		"user":       {After: user},
+	})
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	bs.bookings = append(bs.bookings, Booking{
+		ID:        id,
+		Space:     space,
+		StartTime: start,
+		EndTime:   end,
+		User:      user,
+		Notes:     notes,
+		Status:    "Confirmed",
+	})
+	return nil
+}
+
+// holdsSpace reports whether b still holds its space. Cancelled and
+// released bookings don't.
+func (b *Booking) holdsSpace() bool {
+	return b.Status != "Cancelled" && b.Status != "NoShow"
+}
+
 func (bs *BookingSystem) hasConflictingBooking(space string, start, end time.Time) bool {
-	for _, booking := range bs.bookings {
+	for _, booking := range bs.snapshot() {
+		if !booking.holdsSpace() {
+			continue
+		}
 		if booking.Space == space &&
 			((start.After(booking.StartTime) && start.Before(booking.EndTime)) ||
 			(end.After(booking.StartTime) && end.Before(booking.EndTime)) ||
@@ -546,6 +664,15 @@ func main() {
 	bs.window = window
 	
 	window.SetContent(bs.createMainUI())
+
+	// With a system tray, closing the window hides it and the app keeps
+	// running there to send reminders. The tray menu has Quit.
+	if desk, ok := myApp.(desktop.App); ok {
+		bs.tray = desk
+		window.SetCloseIntercept(window.Hide)
+		bs.updateTray()
+	}
+	go bs.runScheduler(bs.scheduledJobs())
 	window.Resize(fyne.NewSize(800, 600))
 	window.ShowAndRun()
 }
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// migration is a single ordered step of the desktop schema. A migration's
+// position in the migrations slice (starting at 1) is the schema version the
+// database is at once it has been applied.
+type migration struct {
+	name string
+	sql  string
+}
+
+// migrations must only ever be appended to. Editing an entry that has
+// already shipped leaves existing bookings.db files out of step with new ones.
+var migrations = []migration{
+	{
+		name: "initial schema",
+		sql: `
+			CREATE TABLE IF NOT EXISTS spaces (
+				id INTEGER PRIMARY KEY,
+				name TEXT NOT NULL
+			);
+			CREATE TABLE IF NOT EXISTS bookings (
+				id INTEGER PRIMARY KEY,
+				space_id INTEGER,
+				start_time DATETIME,
+				end_time DATETIME,
+				user TEXT,
+				notes TEXT,
+				status TEXT,
+				FOREIGN KEY(space_id) REFERENCES spaces(id)
+			);
+		`,
+	},
+	{
+		name: "audit log",
+		sql: `
+			CREATE TABLE audit_log (
+				id INTEGER PRIMARY KEY,
+				created_at DATETIME NOT NULL,
+				actor TEXT NOT NULL,
+				action TEXT NOT NULL,
+				entity_type TEXT NOT NULL,
+				entity_id INTEGER NOT NULL,
+				changes TEXT
+			);
+			CREATE INDEX audit_log_entity ON audit_log (entity_type, entity_id);
+			CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log
+			BEGIN SELECT RAISE(ABORT, 'audit log is append-only'); END;
+			CREATE TRIGGER audit_log_no_delete BEFORE DELETE ON audit_log
+			BEGIN SELECT RAISE(ABORT, 'audit log is append-only'); END;
+		`,
+	},
+	{
+		name: "space capacity and amenities",
+		sql: `
+			ALTER TABLE spaces ADD COLUMN capacity INTEGER NOT NULL DEFAULT 0;
+			ALTER TABLE spaces ADD COLUMN amenities TEXT NOT NULL DEFAULT '';
+		`,
+	},
+	{
+		name: "attendance",
+		sql: `
+			CREATE TABLE attendance (
+				id INTEGER PRIMARY KEY,
+				booking_id INTEGER NOT NULL,
+				attendee TEXT NOT NULL,
+				status TEXT NOT NULL DEFAULT '',
+				marked_at DATETIME,
+				UNIQUE (booking_id, attendee),
+				FOREIGN KEY(booking_id) REFERENCES bookings(id)
+			);
+		`,
+	},
+	{
+		name: "check-in",
+		sql: `
+			ALTER TABLE spaces ADD COLUMN check_in_minutes INTEGER NOT NULL DEFAULT 0;
+			ALTER TABLE bookings ADD COLUMN checked_in_at DATETIME;
+		`,
+	},
+	{
+		name: "scheduled jobs",
+		sql: `
+			CREATE TABLE jobs (
+				name TEXT PRIMARY KEY,
+				next_run_at DATETIME NOT NULL,
+				locked_until DATETIME,
+				attempts INTEGER NOT NULL DEFAULT 0,
+				last_error TEXT NOT NULL DEFAULT ''
+			);
+		`,
+	},
+	{
+		name: "reminders and settings",
+		sql: `
+			CREATE TABLE settings (
+				key TEXT PRIMARY KEY,
+				value TEXT NOT NULL
+			);
+			CREATE TABLE reminders_sent (
+				booking_id INTEGER NOT NULL,
+				lead_minutes INTEGER NOT NULL,
+				sent_at DATETIME NOT NULL,
+				PRIMARY KEY (booking_id, lead_minutes),
+				FOREIGN KEY(booking_id) REFERENCES bookings(id)
+			);
+			CREATE TABLE reminder_snoozes (
+				booking_id INTEGER PRIMARY KEY,
+				until DATETIME NOT NULL,
+				FOREIGN KEY(booking_id) REFERENCES bookings(id)
+			);
+		`,
+	},
+}
+
+// schemaVersion is the version a fully migrated database reports.
+func schemaVersion() int {
+	return len(migrations)
+}
+
+// migrateDatabase brings the database at path up to the latest schema
+// version, recorded in PRAGMA user_version. Databases created before
+// versioning report version 0, which the initial migration handles because
+// it only creates missing tables. A backup is taken before any upgrade of a
+// database that already holds data.
+func migrateDatabase(db *sql.DB, path string) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("reading schema version: %w", err)
+	}
+
+	if version > schemaVersion() {
+		return fmt.Errorf("database schema version %d is newer than this application supports (%d)", version, schemaVersion())
+	}
+	if version == schemaVersion() {
+		return nil
+	}
+
+	if err := backupDatabase(db, path, version); err != nil {
+		return fmt.Errorf("backing up database before migration: %w", err)
+	}
+
+	for i := version; i < len(migrations); i++ {
+		if err := applyMigration(db, i+1, migrations[i]); err != nil {
+			return err
+		}
+		log.Printf("Applied migration %d (%s)", i+1, migrations[i].name)
+	}
+	return nil
+}
+
+// applyMigration runs m and bumps user_version in one transaction, so a
+// failed step leaves the database at the previous version.
+func applyMigration(db *sql.DB, version int, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(m.sql); err != nil {
+		return fmt.Errorf("migration %d (%s): %w", version, m.name, err)
+	}
+	// PRAGMA statements do not accept bound parameters.
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version)); err != nil {
+		return fmt.Errorf("migration %d (%s): recording version: %w", version, m.name, err)
+	}
+	return tx.Commit()
+}
+
+// backupDatabase writes a consistent copy of the database next to path,
+// named after the version being upgraded from. Empty databases, such as one
+// created on first launch, are not backed up.
+func backupDatabase(db *sql.DB, path string, version int) error {
+	var tables int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table'").Scan(&tables); err != nil {
+		return err
+	}
+	if tables == 0 {
+		return nil
+	}
+
+	backupPath := fmt.Sprintf("%s.v%d-%s.bak", path, version, time.Now().Format("20060102-150405"))
+	if _, err := db.Exec("VACUUM INTO ?", backupPath); err != nil {
+		return err
+	}
+	log.Printf("Backed up database to %s", backupPath)
+	return nil
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// Settings that control how the user is notified, kept per account with
+// userKey
+const (
+	settingNotifyChannels = "notifications.channels"
+	settingNotifyEvents   = "notifications.events"
+	settingQuietStart     = "notifications.quiet_start"
+	settingQuietEnd       = "notifications.quiet_end"
+	settingNotifyDelivery = "notifications.delivery"
+	settingDigestTime     = "notifications.digest_time"
+	settingLastDigest     = "notifications.last_digest"
+	settingLastAuditEntry = "notifications.last_audit_entry"
+	defaultNotifyChannels = channelPopup + ", " + channelTray
+	defaultNotifyEvents   = notifyReminders + ", " + notifyChanges + ", " + notifyReleases
+	defaultDigestTime     = "07:00"
+	// trayNotices is how many recent notifications the tray menu lists
+	trayNotices = 5
+)
+
+// Channels notifications are shown through
+const (
+	channelPopup = "popup"
+	channelTray  = "tray"
+)
+
+// Kinds of notification
+const (
+	notifyReminders = "reminders"
+	notifyChanges   = "changes"
+	notifyReleases  = "releases"
+)
+
+// Ways of delivering booking changes: each as it happens, or all of a
+// day's in one notification
+const (
+	deliveryImmediate = "immediate"
+	deliveryDigest    = "digest"
+)
+
+// changeActions are the audited booking changes the user is told about,
+// and how each is described
+var changeActions = map[string]string{
+	"booking.create":     "New booking",
+	"booking.cancel":     "Cancelled",
+	"booking.edit_notes": "Notes changed",
+	"booking.no_show":    "Released as a no-show",
+}
+
+// Labels of the choices in the notification settings
+var (
+	channelLabels = map[string]string{
+		channelPopup: "Pop-up",
+		channelTray:  "Tray menu",
+	}
+	notifyLabels = map[string]string{
+		notifyReminders: "Reminders",
+		notifyChanges:   "Changes made by others",
+		notifyReleases:  "No-show releases",
+	}
+	deliveryLabels = map[string]string{
+		deliveryImmediate: "As they happen",
+		deliveryDigest:    "Daily digest",
+	}
+)
+
+// notificationSettings says how, when and about what the user is notified.
+// Quiet hours hold notifications back until they end; they are unset when
+// quietStart is empty. In digest mode booking changes wait for one
+// notification a day, while reminders still come on time.
+type notificationSettings struct {
+	channels   []string
+	events     []string
+	quietStart string
+	quietEnd   string
+	delivery   string
+	digestTime string
+}
+
+// notificationSettings loads the notification settings, falling back to
+// the defaults for any that can't be read
+func (bs *BookingSystem) notificationSettings() notificationSettings {
+	s := notificationSettings{
+		channels:   splitList(bs.userSetting(settingNotifyChannels, defaultNotifyChannels)),
+		events:     splitList(bs.userSetting(settingNotifyEvents, defaultNotifyEvents)),
+		quietStart: bs.userSetting(settingQuietStart, ""),
+		quietEnd:   bs.userSetting(settingQuietEnd, ""),
+		delivery:   bs.userSetting(settingNotifyDelivery, deliveryImmediate),
+		digestTime: bs.userSetting(settingDigestTime, defaultDigestTime),
+	}
+	if _, _, err := parseQuietHours(s.quietStart, s.quietEnd); err != nil {
+		log.Printf("Invalid quiet hours, ignoring them: %v", err)
+		s.quietStart, s.quietEnd = "", ""
+	}
+	if _, err := time.Parse("15:04", s.digestTime); err != nil {
+		log.Printf("Invalid digest time, using default: %v", err)
+		s.digestTime = defaultDigestTime
+	}
+	return s
+}
+
+// wants reports whether the user is to be notified of kind at all
+func (s notificationSettings) wants(kind string) bool {
+	return len(s.channels) > 0 && slices.Contains(s.events, kind)
+}
+
+// parseQuietHours checks a quiet hours span of HH:MM times, which must be
+// set together or not at all
+func parseQuietHours(start, end string) (time.Time, time.Time, error) {
+	start, end = strings.TrimSpace(start), strings.TrimSpace(end)
+	if start == "" && end == "" {
+		return time.Time{}, time.Time{}, nil
+	}
+	from, err := time.Parse("15:04", start)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("quiet hours start %q must be HH:MM", start)
+	}
+	to, err := time.Parse("15:04", end)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("quiet hours end %q must be HH:MM", end)
+	}
+	return from, to, nil
+}
+
+// quiet reports whether now falls in the quiet hours, which may run past
+// midnight
+func (s notificationSettings) quiet(now time.Time) bool {
+	from, to, err := parseQuietHours(s.quietStart, s.quietEnd)
+	if err != nil || s.quietStart == "" || from.Equal(to) {
+		return false
+	}
+	minutes := func(t time.Time) int { return t.Hour()*60 + t.Minute() }
+	m := minutes(now)
+	if from.Before(to) {
+		return m >= minutes(from) && m < minutes(to)
+	}
+	return m >= minutes(from) || m < minutes(to)
+}
+
+// deliver shows a notification through the channels the user picked: as
+// a pop-up, and in the tray menu's list of recent notifications
+func (bs *BookingSystem) deliver(s notificationSettings, title, body string) {
+	if slices.Contains(s.channels, channelPopup) {
+		fyne.Do(func() { fyne.CurrentApp().SendNotification(fyne.NewNotification(title, body)) })
+	}
+	if slices.Contains(s.channels, channelTray) {
+		bs.mu.Lock()
+		bs.notices = append([]string{title}, bs.notices[:min(len(bs.notices), trayNotices-1)]...)
+		bs.mu.Unlock()
+	}
+}
+
+// bookingChange is an audited change to one of the user's bookings
+type bookingChange struct {
+	id      int64
+	action  string
+	booking Booking
+}
+
+// kind returns the kind of notification the change is
+func (c bookingChange) kind() string {
+	if c.action == "booking.no_show" {
+		return notifyReleases
+	}
+	return notifyChanges
+}
+
+// describe sums the change up in one line
+func (c bookingChange) describe() string {
+	return fmt.Sprintf("%s: %s, %s", changeActions[c.action], c.booking.Space, c.booking.StartTime.Format("Mon 2 Jan 15:04"))
+}
+
+// lastAuditEntry returns the audit log entry changes were last checked up
+// to. The first time, that is the latest entry, so the user isn't told
+// about everything that ever happened.
+func (bs *BookingSystem) lastAuditEntry() (int64, error) {
+	if id, err := strconv.ParseInt(bs.userSetting(settingLastAuditEntry, ""), 10, 64); err == nil {
+		return id, nil
+	}
+	var id int64
+	if err := bs.db.QueryRow("SELECT COALESCE(MAX(id), 0) FROM audit_log").Scan(&id); err != nil {
+		return 0, err
+	}
+	return id, bs.saveSettings(map[string]string{userKey(settingLastAuditEntry): strconv.FormatInt(id, 10)})
+}
+
+// bookingChanges returns the changes to user's bookings audited after the
+// entry after, oldest first, and the last entry looked at. Changes the
+// user made themselves are left out, apart from releases, which the app
+// makes on nobody's behalf.
+func (bs *BookingSystem) bookingChanges(user string, after int64) ([]bookingChange, int64, error) {
+	rows, err := bs.db.Query(`
+		SELECT a.id, a.actor, a.action, b.id, b.space_id, b.start_time, b.end_time, b.user, b.notes, b.status
+		FROM audit_log a
+		JOIN bookings b ON b.id = a.entity_id
+		WHERE a.entity_type = 'booking' AND a.id > ?
+		ORDER BY a.id
+	`, after)
+	if err != nil {
+		return nil, after, err
+	}
+	defer rows.Close()
+
+	last := after
+	me := auditActor()
+	var changes []bookingChange
+	for rows.Next() {
+		var c bookingChange
+		var actor string
+		var spaceID int
+		b := &c.booking
+		if err := rows.Scan(&c.id, &actor, &c.action, &b.ID, &spaceID, &b.StartTime, &b.EndTime, &b.User, &b.Notes, &b.Status); err != nil {
+			return nil, after, err
+		}
+		last = c.id
+		if _, ok := changeActions[c.action]; !ok || spaceID < 0 || spaceID >= len(bs.spaces) {
+			continue
+		}
+		if actor == me && c.action != "booking.no_show" {
+			continue
+		}
+		b.Space = bs.spaces[spaceID]
+		if attends(*b, user) {
+			changes = append(changes, c)
+		}
+	}
+	return changes, last, rows.Err()
+}
+
+// sendChanges tells the user about changes to their bookings: each as it
+// happens, or once a day in digest mode. Changes that come up in quiet
+// hours wait for them to end.
+func (bs *BookingSystem) sendChanges() error {
+	settings := bs.notificationSettings()
+	now := time.Now()
+	digest := settings.delivery == deliveryDigest
+	var digestDue time.Time
+	if digest {
+		t, _ := time.Parse("15:04", settings.digestTime)
+		digestDue = time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, now.Location())
+		last, _ := time.Parse(time.RFC3339, bs.userSetting(settingLastDigest, ""))
+		if now.Before(digestDue) || !last.Before(digestDue) {
+			return nil
+		}
+	} else if settings.quiet(now) {
+		return nil
+	}
+
+	after, err := bs.lastAuditEntry()
+	if err != nil {
+		return err
+	}
+	changes, last, err := bs.bookingChanges(bs.reminderSettings().user, after)
+	if err != nil {
+		return err
+	}
+	changes = slices.DeleteFunc(changes, func(c bookingChange) bool { return !settings.wants(c.kind()) })
+
+	if digest {
+		if len(changes) > 0 {
+			lines := make([]string, len(changes))
+			for i, c := range changes {
+				lines[i] = c.describe()
+			}
+			bs.deliver(settings, fmt.Sprintf("%d booking changes", len(changes)), strings.Join(lines, "\n"))
+		}
+	} else {
+		for _, c := range changes {
+			body := c.booking.StartTime.Format("Mon 2 Jan 15:04")
+			if c.booking.User != "" {
+				body += " · " + c.booking.User
+			}
+			bs.deliver(settings, changeActions[c.action]+": "+c.booking.Space, body)
+		}
+	}
+
+	values := map[string]string{userKey(settingLastAuditEntry): strconv.FormatInt(last, 10)}
+	if digest {
+		values[userKey(settingLastDigest)] = now.Format(time.RFC3339)
+	}
+	if err := bs.saveSettings(values); err != nil {
+		return err
+	}
+	if len(changes) > 0 {
+		bs.updateTray()
+	}
+	return nil
+}
+
+// checkChoices returns a check group of choices, in order and shown by
+// their labels, with the stored list selected
+func checkChoices(choices []string, labels map[string]string, selected []string) *widget.CheckGroup {
+	var options, checked []string
+	for _, c := range choices {
+		options = append(options, labels[c])
+		if slices.Contains(selected, c) {
+			checked = append(checked, labels[c])
+		}
+	}
+	group := widget.NewCheckGroup(options, nil)
+	group.Horizontal = true
+	group.SetSelected(checked)
+	return group
+}
+
+// chosen maps the labels selected in a check group back to their choices
+func chosen(choices []string, labels map[string]string, selected []string) string {
+	var out []string
+	for _, c := range choices {
+		if slices.Contains(selected, labels[c]) {
+			out = append(out, c)
+		}
+	}
+	return strings.Join(out, ", ")
+}
+
+// showNotificationSettings lets the user choose what they are reminded and
+// notified of, how, and when
+func (bs *BookingSystem) showNotificationSettings() {
+	reminders := bs.reminderSettings()
+	settings := bs.notificationSettings()
+
+	user := widget.NewEntry()
+	user.SetText(reminders.user)
+	leads := widget.NewEntry()
+	leads.SetText(bs.setting(settingReminderLeads, defaultReminderLeads))
+	snooze := widget.NewSelect(snoozeChoices, nil)
+	snooze.SetSelected(bs.setting(settingReminderSnooze, defaultReminderSnooze))
+
+	channelChoices := []string{channelPopup, channelTray}
+	channels := checkChoices(channelChoices, channelLabels, settings.channels)
+	eventChoices := []string{notifyReminders, notifyChanges, notifyReleases}
+	events := checkChoices(eventChoices, notifyLabels, settings.events)
+
+	quietStart := widget.NewEntry()
+	quietStart.SetPlaceHolder("22:00")
+	quietStart.SetText(settings.quietStart)
+	quietEnd := widget.NewEntry()
+	quietEnd.SetPlaceHolder("07:00")
+	quietEnd.SetText(settings.quietEnd)
+
+	deliveryChoices := []string{deliveryImmediate, deliveryDigest}
+	delivery := widget.NewRadioGroup([]string{deliveryLabels[deliveryImmediate], deliveryLabels[deliveryDigest]}, nil)
+	delivery.Horizontal = true
+	delivery.SetSelected(deliveryLabels[settings.delivery])
+	digestTime := widget.NewEntry()
+	digestTime.SetText(settings.digestTime)
+
+	dialog.ShowForm("Notification Settings", "Save", "Cancel",
+		[]*widget.FormItem{
+			{Text: "Your name", Widget: user, HintText: "Leave blank to hear about every booking"},
+			{Text: "Remind me before", Widget: leads, HintText: "Comma separated, such as 15m, 2h, 1d"},
+			{Text: "Snooze for", Widget: snooze},
+			{Text: "Notify me by", Widget: channels},
+			{Text: "Notify me of", Widget: events},
+			{Text: "Quiet hours", Widget: container.NewGridWithColumns(2, quietStart, quietEnd), HintText: "Nothing is shown between these times"},
+			{Text: "Booking changes", Widget: delivery},
+			{Text: "Digest at", Widget: digestTime, HintText: "When the daily digest is shown"},
+		},
+		func(submitted bool) {
+			if !submitted {
+				return
+			}
+			if _, err := parseLeads(leads.Text); err != nil {
+				dialog.ShowError(err, bs.window)
+				return
+			}
+			if _, _, err := parseQuietHours(quietStart.Text, quietEnd.Text); err != nil {
+				dialog.ShowError(err, bs.window)
+				return
+			}
+			if _, err := time.Parse("15:04", strings.TrimSpace(digestTime.Text)); err != nil {
+				dialog.ShowError(fmt.Errorf("digest time %q must be HH:MM", digestTime.Text), bs.window)
+				return
+			}
+			mode := deliveryImmediate
+			for _, d := range deliveryChoices {
+				if deliveryLabels[d] == delivery.Selected {
+					mode = d
+				}
+			}
+			err := bs.saveSettings(map[string]string{
+				settingReminderUser:            strings.TrimSpace(user.Text),
+				settingReminderLeads:           leads.Text,
+				settingReminderSnooze:          snooze.Selected,
+				userKey(settingNotifyChannels): chosen(channelChoices, channelLabels, channels.Selected),
+				userKey(settingNotifyEvents):   chosen(eventChoices, notifyLabels, events.Selected),
+				userKey(settingQuietStart):     strings.TrimSpace(quietStart.Text),
+				userKey(settingQuietEnd):       strings.TrimSpace(quietEnd.Text),
+				userKey(settingNotifyDelivery): mode,
+				userKey(settingDigestTime):     strings.TrimSpace(digestTime.Text),
+			})
+			if err != nil {
+				dialog.ShowError(err, bs.window)
+				return
+			}
+			bs.updateTray()
+		},
+		bs.window,
+	)
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+)
+
+// Settings that control reminders
+const (
+	settingReminderUser   = "reminders.user"
+	settingReminderLeads  = "reminders.leads"
+	settingReminderSnooze = "reminders.snooze"
+)
+
+const (
+	defaultReminderLeads  = "15m, 1d"
+	defaultReminderSnooze = "10m"
+	// trayBookings is how many upcoming bookings the tray menu lists
+	trayBookings = 5
+)
+
+// snoozeChoices are the snooze lengths offered in the settings
+var snoozeChoices = []string{"5m", "10m", "15m", "30m", "1h"}
+
+// reminderSettings says whose bookings to remind about and when. An empty
+// user means every booking.
+type reminderSettings struct {
+	user   string
+	leads  []time.Duration
+	snooze time.Duration
+}
+
+// parseLead parses how long before a booking to remind, such as 15m, 2h
+// or 1d
+func parseLead(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("%q is not a number of days", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < time.Minute {
+		return 0, fmt.Errorf("%q is not a time like 15m, 2h or 1d", s)
+	}
+	return d, nil
+}
+
+// parseLeads parses a comma separated list of reminder times
+func parseLeads(s string) ([]time.Duration, error) {
+	var leads []time.Duration
+	for _, item := range splitList(s) {
+		lead, err := parseLead(item)
+		if err != nil {
+			return nil, err
+		}
+		leads = append(leads, lead)
+	}
+	slices.Sort(leads)
+	return slices.Compact(leads), nil
+}
+
+// formatWait describes a wait in the largest whole unit that fits
+func formatWait(d time.Duration) string {
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return "1 " + unit
+		}
+		return fmt.Sprintf("%d %ss", n, unit)
+	}
+	switch {
+	case d >= 48*time.Hour:
+		return plural(int(d/(24*time.Hour)), "day")
+	case d >= 2*time.Hour:
+		return plural(int(d/time.Hour), "hour")
+	default:
+		return plural(max(int(d.Round(time.Minute)/time.Minute), 1), "minute")
+	}
+}
+
+// reminderSettings loads the reminder settings, falling back to the
+// defaults for any that can't be read
+func (bs *BookingSystem) reminderSettings() reminderSettings {
+	s := reminderSettings{user: bs.setting(settingReminderUser, auditActor())}
+	leads, err := parseLeads(bs.setting(settingReminderLeads, defaultReminderLeads))
+	if err != nil {
+		log.Printf("Invalid reminder times, using defaults: %v", err)
+		leads, _ = parseLeads(defaultReminderLeads)
+	}
+	s.leads = leads
+	snooze, err := parseLead(bs.setting(settingReminderSnooze, defaultReminderSnooze))
+	if err != nil {
+		log.Printf("Invalid snooze time, using default: %v", err)
+		snooze, _ = parseLead(defaultReminderSnooze)
+	}
+	s.snooze = snooze
+	return s
+}
+
+// upcomingBookings returns the confirmed bookings that haven't ended and
+// that user attends, soonest first
+func (bs *BookingSystem) upcomingBookings(user string) ([]Booking, error) {
+	rows, err := bs.db.Query(`
+		SELECT id, space_id, start_time, end_time, user, notes, status
+		FROM bookings
+		WHERE status = 'Confirmed' AND end_time >= datetime('now')
+		ORDER BY start_time
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bookings []Booking
+	for rows.Next() {
+		var b Booking
+		var spaceID int
+		if err := rows.Scan(&b.ID, &spaceID, &b.StartTime, &b.EndTime, &b.User, &b.Notes, &b.Status); err != nil {
+			return nil, err
+		}
+		if spaceID < 0 || spaceID >= len(bs.spaces) {
+			continue
+		}
+		b.Space = bs.spaces[spaceID]
+		if attends(b, user) {
+			bookings = append(bookings, b)
+		}
+	}
+	return bookings, rows.Err()
+}
+
+// attends reports whether user attends b. Everyone attends every booking
+// when no user is set.
+func attends(b Booking, user string) bool {
+	return user == "" || slices.ContainsFunc(bookingAttendees(b), func(name string) bool {
+		return strings.EqualFold(name, user)
+	})
+}
+
+// reminderDue records the reminders of b that have come due and reports
+// whether any had not been sent yet. Recording them first means a reminder
+// is only shown once, even with two copies of the app open.
+func (bs *BookingSystem) reminderDue(b Booking, leads []time.Duration, now time.Time) (bool, error) {
+	due := false
+	for _, lead := range leads {
+		if now.Before(b.StartTime.Add(-lead)) {
+			continue
+		}
+		res, err := bs.db.Exec("INSERT OR IGNORE INTO reminders_sent (booking_id, lead_minutes, sent_at) VALUES (?, ?, ?)",
+			b.ID, int(lead/time.Minute), now.UTC())
+		if err != nil {
+			return false, err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			due = true
+		}
+	}
+
+	res, err := bs.db.Exec("DELETE FROM reminder_snoozes WHERE booking_id = ? AND until <= ?", b.ID, now.UTC())
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		due = true
+	}
+	return due, nil
+}
+
+// sendReminders shows a notification for each of the user's bookings with
+// a reminder due, and brings the tray menu up to date. Reminders that come
+// due in quiet hours are shown once they end, if the booking hasn't
+// started by then.
+func (bs *BookingSystem) sendReminders() error {
+	settings := bs.reminderSettings()
+	upcoming, err := bs.upcomingBookings(settings.user)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	notifications := bs.notificationSettings()
+	if notifications.wants(notifyReminders) && !notifications.quiet(now) {
+		for _, b := range upcoming {
+			if !b.StartTime.After(now) {
+				continue
+			}
+			due, err := bs.reminderDue(b, settings.leads, now)
+			if err != nil {
+				return err
+			}
+			if due {
+				bs.notify(notifications, b, now)
+			}
+		}
+	}
+	bs.refreshTray(upcoming, settings)
+	return nil
+}
+
+// notify shows a reminder for b, which can then be snoozed from the tray
+func (bs *BookingSystem) notify(s notificationSettings, b Booking, now time.Time) {
+	body := "Starts in " + formatWait(b.StartTime.Sub(now))
+	if b.User != "" {
+		body += " · " + b.User
+	}
+	bs.deliver(s, b.Space+" at "+b.StartTime.Format("15:04"), body)
+
+	bs.mu.Lock()
+	bs.lastReminder = &b
+	bs.mu.Unlock()
+}
+
+// snooze reminds about b again once the snooze time has passed
+func (bs *BookingSystem) snooze(b Booking, snooze time.Duration) error {
+	_, err := bs.db.Exec(`
+		INSERT INTO reminder_snoozes (booking_id, until) VALUES (?, ?)
+		ON CONFLICT (booking_id) DO UPDATE SET until = excluded.until
+	`, b.ID, time.Now().Add(snooze).UTC())
+	if err != nil {
+		return err
+	}
+
+	bs.mu.Lock()
+	if bs.lastReminder != nil && bs.lastReminder.ID == b.ID {
+		bs.lastReminder = nil
+	}
+	bs.mu.Unlock()
+	return nil
+}
+
+// updateTray reloads the user's bookings into the tray menu
+func (bs *BookingSystem) updateTray() {
+	if bs.tray == nil {
+		return
+	}
+	settings := bs.reminderSettings()
+	upcoming, err := bs.upcomingBookings(settings.user)
+	if err != nil {
+		log.Printf("Error loading upcoming bookings: %v", err)
+		return
+	}
+	bs.refreshTray(upcoming, settings)
+}
+
+// refreshTray lists the next few upcoming bookings in the system tray
+// menu, with a way to snooze the last reminder shown, and the latest
+// notifications. The tray keeps the app running, and reminding, while the
+// main window is closed.
+func (bs *BookingSystem) refreshTray(upcoming []Booking, settings reminderSettings) {
+	if bs.tray == nil {
+		return
+	}
+
+	items := []*fyne.MenuItem{
+		fyne.NewMenuItem("Open Booking System", bs.showWindow),
+		fyne.NewMenuItemSeparator(),
+	}
+	if len(upcoming) == 0 {
+		none := fyne.NewMenuItem("No upcoming bookings", nil)
+		none.Disabled = true
+		items = append(items, none)
+	}
+	for _, b := range upcoming[:min(len(upcoming), trayBookings)] {
+		label := b.StartTime.Format("Mon 15:04") + "  " + b.Space
+		items = append(items, fyne.NewMenuItem(label, bs.showWindow))
+	}
+
+	bs.mu.Lock()
+	last := bs.lastReminder
+	bs.mu.Unlock()
+	if last != nil && last.StartTime.After(time.Now()) {
+		b := *last
+		label := fmt.Sprintf("Snooze %s reminder for %s", b.Space, formatWait(settings.snooze))
+		items = append(items, fyne.NewMenuItemSeparator(), fyne.NewMenuItem(label, func() {
+			if err := bs.snooze(b, settings.snooze); err != nil {
+				log.Printf("Error snoozing reminder: %v", err)
+			}
+			bs.updateTray()
+		}))
+	}
+
+	bs.mu.Lock()
+	notices := slices.Clone(bs.notices)
+	bs.mu.Unlock()
+	if len(notices) > 0 {
+		items = append(items, fyne.NewMenuItemSeparator())
+		for _, notice := range notices {
+			items = append(items, fyne.NewMenuItem(notice, bs.showWindow))
+		}
+	}
+
+	items = append(items, fyne.NewMenuItemSeparator(), fyne.NewMenuItem("Notification Settings...", func() {
+		bs.showWindow()
+		bs.showNotificationSettings()
+	}))
+	// Reminders are sent from the scheduler, but the menu belongs to the UI
+	fyne.Do(func() { bs.tray.SetSystemTrayMenu(fyne.NewMenu("Booking System", items...)) })
+}
+
+// showWindow brings the main window back after it was closed to the tray
+func (bs *BookingSystem) showWindow() {
+	bs.window.Show()
+	bs.window.RequestFocus()
+}
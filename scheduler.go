@@ -0,0 +1,112 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+// scheduledJob is recurring work the app does in the background while it
+// is open. When it last ran is kept in the database, so restarting the app
+// doesn't run everything again, and two copies of the app sharing a
+// database don't run the same job at once.
+type scheduledJob struct {
+	name  string
+	every time.Duration
+	run   func() error
+}
+
+const (
+	// schedulerTick is how often due jobs are looked for
+	schedulerTick = 30 * time.Second
+	// jobLease is how long a claimed job is reserved for the copy of the
+	// app running it
+	jobLease = 5 * time.Minute
+	// jobRetryBase is how long to wait after a job first fails, doubling
+	// with each further failure up to the job's interval
+	jobRetryBase = 30 * time.Second
+)
+
+// scheduledJobs lists the app's background jobs
+func (bs *BookingSystem) scheduledJobs() []scheduledJob {
+	return []scheduledJob{
+		{name: "booking.release", every: time.Minute, run: func() error {
+			// Free spaces nobody checked in to, as the server does
+			n, err := bs.releaseUnclaimed()
+			if n > 0 {
+				bs.loadBookings()
+				bs.refresh()
+			}
+			return err
+		}},
+		{name: "booking.reminders", every: time.Minute, run: bs.sendReminders},
+		{name: "booking.changes", every: time.Minute, run: bs.sendChanges},
+	}
+}
+
+// runScheduler runs jobs as they fall due, for as long as the app is open
+func (bs *BookingSystem) runScheduler(jobs []scheduledJob) {
+	for {
+		for _, job := range jobs {
+			claimed, err := bs.claimJob(job)
+			if err != nil {
+				log.Printf("Error claiming job %s: %v", job.name, err)
+				continue
+			}
+			if !claimed {
+				continue
+			}
+			if err := bs.finishJob(job, job.run()); err != nil {
+				log.Printf("Error recording job %s: %v", job.name, err)
+			}
+		}
+		time.Sleep(schedulerTick)
+	}
+}
+
+// claimJob reserves job if it is due. Times are stored in UTC so they
+// compare correctly as text.
+func (bs *BookingSystem) claimJob(job scheduledJob) (bool, error) {
+	now := time.Now().UTC()
+	if _, err := bs.db.Exec("INSERT OR IGNORE INTO jobs (name, next_run_at) VALUES (?, ?)", job.name, now); err != nil {
+		return false, err
+	}
+	res, err := bs.db.Exec(`
+		UPDATE jobs SET locked_until = ?
+		WHERE name = ? AND next_run_at <= ? AND (locked_until IS NULL OR locked_until < ?)
+	`, now.Add(jobLease), job.name, now, now)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// finishJob releases job and schedules its next run: after its interval
+// if it succeeded, sooner to retry if it failed
+func (bs *BookingSystem) finishJob(job scheduledJob, runErr error) error {
+	now := time.Now().UTC()
+	if runErr == nil {
+		_, err := bs.db.Exec(`
+			UPDATE jobs SET next_run_at = ?, locked_until = NULL, attempts = 0, last_error = ''
+			WHERE name = ?
+		`, now.Add(job.every), job.name)
+		return err
+	}
+
+	log.Printf("Job %s failed: %v", job.name, runErr)
+	var attempts int
+	if err := bs.db.QueryRow("SELECT attempts FROM jobs WHERE name = ?", job.name).Scan(&attempts); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	wait := jobRetryBase
+	for i := 0; i < attempts && wait < job.every; i++ {
+		wait *= 2
+	}
+	_, err := bs.db.Exec(`
+		UPDATE jobs SET next_run_at = ?, locked_until = NULL, attempts = attempts + 1, last_error = ?
+		WHERE name = ?
+	`, now.Add(min(wait, job.every)), runErr.Error(), job.name)
+	return err
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+)
+
+// setting returns the stored value of key, or def if it was never set
+func (bs *BookingSystem) setting(key, def string) string {
+	var value string
+	err := bs.db.QueryRow("SELECT value FROM settings WHERE key = ?", key).Scan(&value)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Error loading setting %s: %v", key, err)
+		}
+		return def
+	}
+	return value
+}
+
+// userKey scopes key to the account running the app, for settings each
+// person sharing the database keeps for themselves
+func userKey(key string) string {
+	return key + "@" + auditActor()
+}
+
+// userSetting returns the current account's value of key, falling back to
+// the value shared by everyone from before settings were kept per account,
+// then to def
+func (bs *BookingSystem) userSetting(key, def string) string {
+	return bs.setting(userKey(key), bs.setting(key, def))
+}
+
+// saveSettings stores several settings in one transaction
+func (bs *BookingSystem) saveSettings(values map[string]string) error {
+	tx, err := bs.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for key, value := range values {
+		_, err := tx.Exec(`
+			INSERT INTO settings (key, value) VALUES (?, ?)
+			ON CONFLICT (key) DO UPDATE SET value = excluded.value
+		`, key, value)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// interval is a span of time from Start up to End
+type interval struct {
+	Start time.Time
+	End   time.Time
+}
+
+// overlaps reports whether i and o share any time once i is widened by
+// buffer on each side
+func (i interval) overlaps(o interval, buffer time.Duration) bool {
+	return i.Start.Add(-buffer).Before(o.End) && i.End.Add(buffer).After(o.Start)
+}
+
+// slotSpace is a space the finder can offer and the times it is booked
+type slotSpace struct {
+	ID        int
+	Name      string
+	Capacity  int
+	Amenities []string
+	Busy      []interval
+}
+
+// slotRequest describes the slots wanted and the rules they must meet
+type slotRequest struct {
+	Duration time.Duration
+	From     time.Time
+	To       time.Time
+	// Capacity is the fewest people a space must hold, 0 for any
+	Capacity  int
+	Amenities []string
+	// Preferred spaces rank ahead of others for the same start time
+	Preferred []int
+	// Limit is the most slots returned
+	Limit int
+	// Buffer is the gap left between a slot and neighbouring bookings
+	Buffer time.Duration
+	// NotBefore is the earliest a slot may start, usually now
+	NotBefore time.Time
+}
+
+// freeSlot is a free span in one space
+type freeSlot struct {
+	SpaceName string
+	Start     time.Time
+	End       time.Time
+	Preferred bool
+	// Buffer is the gap the slot was found with, kept so booking it
+	// checks the same gap
+	Buffer time.Duration
+	// spare is how much more the space holds than needed
+	spare int
+}
+
+// suits reports whether space has the capacity and amenities req asks for.
+// Amenities match case-insensitively.
+func (req *slotRequest) suits(space *slotSpace) bool {
+	if req.Capacity > 0 && space.Capacity < req.Capacity {
+		return false
+	}
+	for _, want := range req.Amenities {
+		if !slices.ContainsFunc(space.Amenities, func(have string) bool { return strings.EqualFold(have, want) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// findSlots returns free slots of req.Duration between req.From and req.To,
+// within opening hours, in the spaces that suit req. Slots are ranked by
+// start time, then preferred spaces first, then the space that fits the
+// group most tightly. This mirrors the server's slot finder.
+func findSlots(req slotRequest, spaces []slotSpace) []freeSlot {
+	var slots []freeSlot
+	for i := range spaces {
+		space := &spaces[i]
+		if !req.suits(space) {
+			continue
+		}
+		preferred := slices.Contains(req.Preferred, space.ID)
+		for _, free := range freeSpans(req, space.Busy) {
+			slots = append(slots, freeSlot{
+				SpaceName: space.Name,
+				Start:     free.Start,
+				End:       free.End,
+				Preferred: preferred,
+				Buffer:    req.Buffer,
+				spare:     space.Capacity - req.Capacity,
+			})
+		}
+	}
+
+	slices.SortStableFunc(slots, func(a, b freeSlot) int {
+		if c := a.Start.Compare(b.Start); c != 0 {
+			return c
+		}
+		if a.Preferred != b.Preferred {
+			if a.Preferred {
+				return -1
+			}
+			return 1
+		}
+		return a.spare - b.spare
+	})
+	if req.Limit > 0 && len(slots) > req.Limit {
+		slots = slots[:req.Limit]
+	}
+	return slots
+}
+
+// freeSpans returns every start, on the slot step within opening hours,
+// for which a span of req.Duration stays clear of busy by req.Buffer
+func freeSpans(req slotRequest, busy []interval) []interval {
+	from := req.From
+	if from.Before(req.NotBefore) {
+		from = req.NotBefore
+	}
+
+	var spans []interval
+	first := from.In(time.Local)
+	for day := time.Date(first.Year(), first.Month(), first.Day(), 0, 0, 0, 0, time.Local); day.Before(req.To); day = day.AddDate(0, 0, 1) {
+		open := time.Date(day.Year(), day.Month(), day.Day(), 0, openMinute, 0, 0, time.Local)
+		closing := time.Date(day.Year(), day.Month(), day.Day(), 0, closeMinute, 0, 0, time.Local)
+		if req.To.Before(closing) {
+			closing = req.To
+		}
+
+		start := open
+		if start.Before(from) {
+			// Round up onto the step grid
+			start = open.Add((from.Sub(open) + slotStep - 1) / slotStep * slotStep)
+		}
+		for ; !start.Add(req.Duration).After(closing); start = start.Add(slotStep) {
+			candidate := interval{Start: start, End: start.Add(req.Duration)}
+			if !slices.ContainsFunc(busy, func(b interval) bool { return candidate.overlaps(b, req.Buffer) }) {
+				spans = append(spans, candidate)
+			}
+		}
+	}
+	return spans
+}